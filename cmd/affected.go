@@ -0,0 +1,140 @@
+//nolint:gochecknoinits,gochecknoglobals
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/olekukonko/tablewriter"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+
+	"github.com/ice-bergtech/kr8/pkg/generate"
+	"github.com/ice-bergtech/kr8/pkg/kr8_git"
+	"github.com/ice-bergtech/kr8/pkg/types"
+	util "github.com/ice-bergtech/kr8/pkg/util"
+)
+
+// Output formats of the 'affected' command.
+const (
+	affectedOutputTable      = "table"
+	affectedOutputClusters   = "clusters"
+	affectedOutputComponents = "components"
+	affectedOutputJSON       = "json"
+)
+
+// Stores the options for the 'affected' command.
+type CmdAffectedOptions struct {
+	// Comma separated list of changed files
+	Files string
+	// Git revision to list changed files since
+	Since string
+	// Output format, `table`, `clusters`, `components` or `json`
+	Output string
+	// Lint Files with jsonnet linter before rendering cluster params
+	Lint bool
+}
+
+var cmdAffectedFlags CmdAffectedOptions
+
+func init() {
+	RootCmd.AddCommand(AffectedCmd)
+	AffectedCmd.Flags().StringVarP(&cmdAffectedFlags.Files,
+		"files", "f", "",
+		"changed files - comma separated list of paths, relative to the current directory")
+	AffectedCmd.Flags().StringVarP(&cmdAffectedFlags.Since,
+		"since", "s", "",
+		"git revision to compare against - files changed since its merge base with HEAD, "+
+			"and uncommitted changes, are used")
+	AffectedCmd.Flags().StringVarP(&cmdAffectedFlags.Output,
+		"output", "o", affectedOutputTable,
+		"output format - table, json, clusters or components. "+
+			"clusters and components print a filter for the --clusters and --components flags of generate")
+	AffectedCmd.Flags().BoolVarP(&cmdAffectedFlags.Lint, "lint", "l", false,
+		"lint Files with jsonnet linter before rendering cluster params")
+}
+
+var AffectedCmd = &cobra.Command{
+	Use:   "affected [flags]",
+	Short: "List clusters and components affected by changed files",
+	Long: `Map changed files to the clusters and components that depend on them.
+Follows each cluster's params chain, component directories, extfiles, jpaths and jsonnet imports.
+With --output clusters or components, prints a filter that can be passed to generate.
+If nothing is affected, the filter is ` + "`" + generate.AffectedNoneFilter + "`" + `, which matches no cluster or component.`,
+	Example: `kr8 affected --since origin/main
+kr8 generate --clusters "$(kr8 affected --since origin/main -o clusters)" \
+  --components "$(kr8 affected --since origin/main -o components)"`,
+
+	Args: cobra.NoArgs,
+	Run:  AffectedCommand,
+}
+
+// Lists the clusters and components affected by the changed files.
+func AffectedCommand(cmd *cobra.Command, args []string) {
+	if cmdAffectedFlags.Files == "" && cmdAffectedFlags.Since == "" {
+		log.Fatal().Msg("set --files and/or --since")
+	}
+
+	changedFiles := []string{}
+	if cmdAffectedFlags.Files != "" {
+		for file := range strings.SplitSeq(cmdAffectedFlags.Files, ",") {
+			absFile, err := filepath.Abs(strings.TrimSpace(file))
+			util.FatalErrorCheck("error resolving path "+file, err, log.Logger)
+			changedFiles = append(changedFiles, absFile)
+		}
+	}
+	if cmdAffectedFlags.Since != "" {
+		gitFiles, err := kr8_git.ChangedFiles(RootConfig.BaseDir, cmdAffectedFlags.Since)
+		util.FatalErrorCheck("error listing changed files", err, log.Logger)
+		changedFiles = append(changedFiles, gitFiles...)
+	}
+	log.Debug().Strs("files", changedFiles).Msg("Changed files")
+
+	affected, err := generate.FindAffected(
+		changedFiles,
+		types.Kr8Opts{
+			BaseDir:      RootConfig.BaseDir,
+			ComponentDir: RootConfig.ComponentDir,
+			ClusterDir:   RootConfig.ClusterDir,
+		},
+		RootConfig.VMConfig,
+		cmdAffectedFlags.Lint,
+		log.Logger,
+	)
+	util.FatalErrorCheck("error finding affected clusters", err, log.Logger)
+
+	switch cmdAffectedFlags.Output {
+	case affectedOutputTable:
+		printAffectedTable(affected)
+	case affectedOutputClusters, affectedOutputComponents:
+		fmt.Println(generate.AffectedFilter(affected, cmdAffectedFlags.Output == affectedOutputClusters))
+	case affectedOutputJSON:
+		out, err := json.MarshalIndent(affected, "", "  ")
+		util.FatalErrorCheck("error encoding affected clusters", err, log.Logger)
+		fmt.Println(string(out))
+	default:
+		log.Fatal().Str("output", cmdAffectedFlags.Output).Msg("unknown output format")
+	}
+}
+
+// Prints the affected clusters and components as a table.
+// `*` marks clusters with changed parameters, where every component is affected.
+func printAffectedTable(affected []generate.AffectedCluster) {
+	table := tablewriter.NewWriter(os.Stdout)
+	table.Header([]string{"Cluster", "Components"})
+	for _, cluster := range affected {
+		components := strings.Join(cluster.Components, ", ")
+		if cluster.AllComponents {
+			components = "*"
+		}
+		if err := table.Append([]string{cluster.Name, components}); err != nil {
+			log.Warn().Err(err).Msg("Row error")
+		}
+	}
+	if err := table.Render(); err != nil {
+		log.Warn().Err(err).Msg("Table error")
+	}
+}
@@ -0,0 +1,140 @@
+//nolint:gochecknoinits,gochecknoglobals
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+
+	"github.com/ice-bergtech/kr8/pkg/generate"
+	"github.com/ice-bergtech/kr8/pkg/kr8_check"
+	"github.com/ice-bergtech/kr8/pkg/types"
+	util "github.com/ice-bergtech/kr8/pkg/util"
+)
+
+// Stores the options for the 'check' command.
+type CmdCheckOptions struct {
+	// Stores the path to the cluster params file
+	ClusterParamsFile string
+	// Directory containing generated cluster output
+	GenerateDir string
+	// Stores the filters to apply to clusters and components
+	Filters util.PathFilterOptions
+	// Print findings as json instead of logging them
+	JSON bool
+}
+
+var cmdCheckFlags CmdCheckOptions
+
+func init() {
+	RootCmd.AddCommand(CheckCmd)
+	CheckCmd.Flags().StringVarP(&cmdCheckFlags.ClusterParamsFile,
+		"clusterparams", "p", "",
+		"provide cluster params as single file - can be combined with --cluster to override cluster")
+	CheckCmd.Flags().StringVarP(&cmdCheckFlags.Filters.Clusters,
+		"clusters", "C", "",
+		"clusters to check - comma separated list of cluster names, path-qualified cluster references "+
+			"such as aws/us-east-1/prod, and/or regular expressions")
+	CheckCmd.Flags().StringVarP(&cmdCheckFlags.Filters.Components, "components", "c", "",
+		"components to check - comma separated list of component names and/or regular expressions")
+	CheckCmd.Flags().StringVarP(&cmdCheckFlags.Filters.ComponentSelector,
+		"component-selector", "", "",
+		"only check components with matching labels - kubernetes label selector, for example 'layer=observability'")
+	CheckCmd.Flags().StringVarP(&cmdCheckFlags.GenerateDir,
+		"generate-dir", "o", "",
+		"directory containing generated output. Defaults to the generate_dir of each cluster")
+	CheckCmd.Flags().StringVarP(&cmdCheckFlags.Filters.Includes,
+		"clincludes", "i", "",
+		"filter included cluster by including clusters with matching cluster parameters - "+
+			"comma separate list of key/value conditions separated by = or ~ (for regex match)")
+	CheckCmd.Flags().StringVarP(&cmdCheckFlags.Filters.Excludes,
+		"clexcludes", "x", "",
+		"filter included cluster by excluding clusters with matching cluster parameters - "+
+			"comma separate list of key/value conditions separated by = or ~ (for regex match)")
+	CheckCmd.Flags().StringVarP(&cmdCheckFlags.Filters.Selector,
+		"select", "s", "",
+		"filter included clusters with a selector expression over cluster parameters - "+
+			"for example 'env=prod && region~^us- && !(tier=legacy)'")
+	CheckCmd.Flags().BoolVarP(&cmdCheckFlags.JSON, "json", "", false,
+		"print findings as a json list")
+}
+
+var CheckCmd = &cobra.Command{
+	Use:   "check [flags]",
+	Short: "Check generated output",
+	Long: `Run the duplicate resource, deprecated API, schema validation and policy checks configured in each cluster's _kr8_spec
+against previously generated output. Exits with a non-zero status if any finding has a deny severity.`,
+	Example: "kr8 check --clusters prod",
+
+	Args: cobra.NoArgs,
+	Run:  CheckCommand,
+}
+
+// Checks the generated output of each selected cluster and reports the findings.
+func CheckCommand(cmd *cobra.Command, args []string) {
+	allClusterParams, err := generate.GetClusterParams(RootConfig.ClusterDir, RootConfig.VMConfig, true, log.Logger)
+	util.FatalErrorCheck("error getting cluster params from "+RootConfig.ClusterDir, err, log.Logger)
+
+	clusterList := buildClusterList(allClusterParams, cmdCheckFlags.Filters)
+	sort.Strings(clusterList)
+
+	kr8Opts := types.Kr8Opts{
+		BaseDir:      RootConfig.BaseDir,
+		ComponentDir: RootConfig.ComponentDir,
+		ClusterDir:   RootConfig.ClusterDir,
+	}
+
+	allFindings := []kr8_check.Finding{}
+	denied := 0
+	for _, clusterName := range clusterList {
+		logger := log.With().Str("cluster", clusterName).Logger()
+		kr8Spec, findings, err := generate.CheckGeneratedCluster(&generate.GenerateProcessRootConfig{
+			ClusterName:       clusterName,
+			ClusterDir:        RootConfig.ClusterDir,
+			BaseDir:           RootConfig.BaseDir,
+			GenerateDir:       cmdCheckFlags.GenerateDir,
+			Kr8Opts:           kr8Opts,
+			ClusterParamsFile: cmdCheckFlags.ClusterParamsFile,
+			Filters:           cmdCheckFlags.Filters,
+			VmConfig:          RootConfig.VMConfig,
+			Noop:              true,
+			Lint:              false,
+			SignKey:           nil,
+			Bundle:            nil,
+			Kr8Version:        version,
+			ArgoCDOutputDir:   "",
+		}, logger)
+		util.FatalErrorCheck("error checking cluster", err, logger)
+		if !generate.ClusterChecksEnabled(*kr8Spec) {
+			logger.Debug().Msg("no checks configured")
+
+			continue
+		}
+
+		if cmdCheckFlags.JSON {
+			for _, finding := range findings {
+				if finding.Severity == kr8_check.SeverityDeny {
+					denied++
+				}
+			}
+			allFindings = append(allFindings, findings...)
+		} else {
+			clusterDenied := kr8_check.LogFindings(findings, logger)
+			denied += clusterDenied
+			logger.Info().Int("findings", len(findings)).Int("denied", clusterDenied).Msg("Checked cluster")
+		}
+	}
+
+	if cmdCheckFlags.JSON {
+		out, err := json.MarshalIndent(allFindings, "", "  ")
+		util.FatalErrorCheck("error encoding findings", err, log.Logger)
+		fmt.Println(string(out))
+	}
+	if denied > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,196 @@
+//nolint:gochecknoinits,gochecknoglobals
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/panjf2000/ants/v2"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+
+	"github.com/ice-bergtech/kr8/pkg/generate"
+	"github.com/ice-bergtech/kr8/pkg/jnetvm"
+	"github.com/ice-bergtech/kr8/pkg/kr8_diff"
+	"github.com/ice-bergtech/kr8/pkg/kr8_types"
+	"github.com/ice-bergtech/kr8/pkg/types"
+	util "github.com/ice-bergtech/kr8/pkg/util"
+)
+
+// Stores the options for the 'diff' command.
+type CmdDiffOptions struct {
+	// If true, also diff the generated output of the clusters
+	Output bool
+	// Substitutions applied to the first cluster's generated output, in the form `old=new`
+	Substitutions []string
+	// Components to diff the generated output of, comma separated list of regular expressions
+	Components string
+	// Lint Files with jsonnet linter before rendering cluster params
+	Lint bool
+}
+
+var cmdDiffFlags CmdDiffOptions
+
+func init() {
+	RootCmd.AddCommand(DiffCmd)
+	DiffCmd.AddCommand(DiffClustersCmd)
+	DiffClustersCmd.Flags().BoolVar(&cmdDiffFlags.Output,
+		"output", false,
+		"also generate the output of both clusters and diff the generated objects, component by component")
+	DiffClustersCmd.Flags().StringArrayVarP(&cmdDiffFlags.Substitutions,
+		"substitute", "s", nil,
+		"replace a string in the first cluster's generated objects before diffing them, in the form old=new - "+
+			"can be repeated, the first cluster's name is always replaced with the second's, after other substitutions")
+	DiffClustersCmd.Flags().StringVarP(&cmdDiffFlags.Components,
+		"components", "c", "",
+		"components to diff the generated output of - comma separated list of regular expressions")
+	DiffClustersCmd.Flags().BoolVarP(&cmdDiffFlags.Lint, "lint", "l", false,
+		"lint Files with jsonnet linter before rendering cluster params")
+}
+
+// DiffCmd represents the diff command.
+var DiffCmd = &cobra.Command{
+	Use:   "diff",
+	Short: "Compare kr8+ resources",
+	Long:  `Displays the differences between kr8+ resources such as clusters`,
+}
+
+var DiffClustersCmd = &cobra.Command{
+	Use:   "clusters <cluster-a> <cluster-b> [flags]",
+	Short: "Diff the params and generated output of two clusters",
+	Long: `Show the structural differences between two clusters' params: _cluster, _components and each component's merged params.
+With --output, both clusters are generated into a temporary directory
+and their generated objects are compared component by component.`,
+	Example: `kr8 diff clusters staging prod
+kr8 diff clusters staging prod --output -s staging.example.com=prod.example.com`,
+
+	Args: cobra.ExactArgs(2), //nolint:mnd
+	Run: func(cmd *cobra.Command, args []string) {
+		index, err := util.LoadClusterIndex(RootConfig.ClusterDir, jnetvm.ClusterMatrixLoader(RootConfig.VMConfig))
+		util.FatalErrorCheck("error indexing clusters", err, log.Logger)
+		clusterA, err := index.Resolve(args[0])
+		util.FatalErrorCheck("error finding cluster", err, log.Logger)
+		clusterB, err := index.Resolve(args[1])
+		util.FatalErrorCheck("error finding cluster", err, log.Logger)
+
+		substitutions := make([]kr8_diff.Substitution, 0, len(cmdDiffFlags.Substitutions)+1)
+		for _, input := range cmdDiffFlags.Substitutions {
+			substitution, err := kr8_diff.ParseSubstitution(input)
+			util.FatalErrorCheck("error parsing --substitute", err, log.Logger)
+			substitutions = append(substitutions, substitution)
+		}
+		substitutions = append(substitutions, kr8_diff.Substitution{Old: clusterA.Name, New: clusterB.Name})
+
+		sections, err := generate.DiffClusterParams(clusterA.Name, clusterB.Name, RootConfig.VMConfig, cmdDiffFlags.Lint)
+		util.FatalErrorCheck("error diffing cluster params", err, log.Logger)
+		printDiffSections("params", sections)
+
+		if !cmdDiffFlags.Output {
+			return
+		}
+		outputDir, err := os.MkdirTemp("", "kr8-diff-")
+		util.FatalErrorCheck("error creating temporary directory", err, log.Logger)
+		sections, err = diffClusterOutput(clusterA.Name, clusterB.Name, outputDir, substitutions)
+		// Remove the temporary directory before exiting on an error
+		if removeErr := os.RemoveAll(outputDir); removeErr != nil {
+			log.Warn().Err(removeErr).Str("dir", outputDir).Msg("error removing temporary directory")
+		}
+		util.FatalErrorCheck("error diffing generated output", err, log.Logger)
+		printDiffSections("output", sections)
+	},
+}
+
+// Generates two clusters into a directory and diffs their generated objects.
+func diffClusterOutput(
+	clusterA string,
+	clusterB string,
+	generateDir string,
+	substitutions []kr8_diff.Substitution,
+) ([]kr8_diff.Section, error) {
+	if err := generateDiffCluster(clusterA, generateDir); err != nil {
+		return nil, err
+	}
+	if err := generateDiffCluster(clusterB, generateDir); err != nil {
+		return nil, err
+	}
+
+	return generate.DiffClusterOutput(
+		filepath.Join(generateDir, clusterA),
+		filepath.Join(generateDir, clusterB),
+		substitutions,
+		log.Logger,
+	)
+}
+
+// Generates a cluster into a directory, for diffing.
+func generateDiffCluster(clusterName string, generateDir string) error {
+	pool, err := ants.NewPool(RootConfig.Parallel)
+	if err != nil {
+		return util.ErrorIfCheck("error creating pool", err)
+	}
+	defer pool.Release()
+	kr8Opts := types.Kr8Opts{
+		BaseDir:      RootConfig.BaseDir,
+		ComponentDir: RootConfig.ComponentDir,
+		ClusterDir:   RootConfig.ClusterDir,
+	}
+	err = generate.GenProcessCluster(
+		//nolint:exhaustruct
+		&generate.GenerateProcessRootConfig{
+			ClusterName: clusterName,
+			ClusterDir:  RootConfig.ClusterDir,
+			BaseDir:     RootConfig.BaseDir,
+			GenerateDir: generateDir,
+			Kr8Opts:     kr8Opts,
+			//nolint:exhaustruct
+			Filters:         util.PathFilterOptions{Components: cmdDiffFlags.Components},
+			VmConfig:        RootConfig.VMConfig,
+			Noop:            false,
+			Lint:            cmdDiffFlags.Lint,
+			ArgoCDOutputDir: filepath.Join(generateDir, kr8_types.ArgoCDOutputDir),
+		},
+		pool,
+		log.With().Str("cluster", clusterName).Logger(),
+	)
+
+	return util.ErrorIfCheck("error generating cluster "+clusterName, err)
+}
+
+// ANSI colors of added, removed and changed values in diffs.
+const (
+	colorAdded   = 32
+	colorRemoved = 31
+	colorChanged = 33
+)
+
+// Prints diff sections, one line per change.
+// Added, removed and changed values are prefixed with `+`, `-` and `~`.
+func printDiffSections(kind string, sections []kr8_diff.Section) {
+	if len(sections) == 0 {
+		fmt.Println("# no " + kind + " differences")
+
+		return
+	}
+	for _, section := range sections {
+		fmt.Println("=== " + kind + " " + section.Name)
+		for _, change := range section.Changes {
+			path := change.Path
+			if path == "" {
+				path = "(all)"
+			}
+			switch change.Type {
+			case kr8_diff.ChangeAdded:
+				fmt.Println(util.Colorize("+ "+path+": "+string(change.New), colorAdded, !RootConfig.Color))
+			case kr8_diff.ChangeRemoved:
+				fmt.Println(util.Colorize("- "+path+": "+string(change.Old), colorRemoved, !RootConfig.Color))
+			case kr8_diff.ChangeModified:
+				fmt.Println(util.Colorize(
+					"~ "+path+": "+string(change.Old)+" -> "+string(change.New),
+					colorChanged,
+					!RootConfig.Color,
+				))
+			}
+		}
+	}
+}
@@ -2,6 +2,8 @@
 package cmd
 
 import (
+	"crypto/ed25519"
+	"path/filepath"
 	"strconv"
 	"sync"
 
@@ -13,6 +15,10 @@ import (
 	"golang.org/x/exp/maps"
 
 	"github.com/ice-bergtech/kr8/pkg/generate"
+	"github.com/ice-bergtech/kr8/pkg/jnetvm"
+	"github.com/ice-bergtech/kr8/pkg/kr8_bundle"
+	"github.com/ice-bergtech/kr8/pkg/kr8_git"
+	"github.com/ice-bergtech/kr8/pkg/kr8_sign"
 	"github.com/ice-bergtech/kr8/pkg/types"
 	util "github.com/ice-bergtech/kr8/pkg/util"
 )
@@ -27,6 +33,16 @@ type CmdGenerateOptions struct {
 	Filters util.PathFilterOptions
 	// Lint Files with jsonnet linter before generating output
 	Lint bool
+	// Path to an ed25519 private key used to sign the generated output
+	SignKey string
+	// Directory to write a bundle of each generated cluster to
+	Bundle string
+	// Format of the bundles, `tar` or `oci`
+	BundleFormat string
+	// Local git repository to generate into and commit the output to
+	GitOutput string
+	// Branch of the git output repository to commit to
+	Branch string
 }
 
 var cmdGenerateFlags CmdGenerateOptions
@@ -38,9 +54,13 @@ func init() {
 		"provide cluster params as single file - can be combined with --cluster to override cluster")
 	GenerateCmd.Flags().StringVarP(&cmdGenerateFlags.Filters.Clusters,
 		"clusters", "C", "",
-		"clusters to generate - comma separated list of cluster names and/or regular expressions ")
+		"clusters to generate - comma separated list of cluster names, path-qualified cluster references "+
+			"such as aws/us-east-1/prod, and/or regular expressions")
 	GenerateCmd.Flags().StringVarP(&cmdGenerateFlags.Filters.Components, "components", "c", "",
 		"components to generate - comma separated list of component names and/or regular expressions")
+	GenerateCmd.Flags().StringVarP(&cmdGenerateFlags.Filters.ComponentSelector,
+		"component-selector", "", "",
+		"only generate components with matching labels - kubernetes label selector, for example 'layer=observability'")
 	GenerateCmd.Flags().StringVarP(&cmdGenerateFlags.GenerateDir,
 		"generate-dir", "o", "generated",
 		"output directory")
@@ -52,8 +72,28 @@ func init() {
 		"clexcludes", "x", "",
 		"filter included cluster by excluding clusters with matching cluster parameters - "+
 			"comma separate list of key/value conditions separated by = or ~ (for regex match)")
+	GenerateCmd.Flags().StringVarP(&cmdGenerateFlags.Filters.Selector,
+		"select", "s", "",
+		"filter included clusters with a selector expression over cluster parameters - "+
+			"for example 'env=prod && region~^us- && !(tier=legacy)'")
 	GenerateCmd.Flags().BoolVarP(&cmdGenerateFlags.Lint, "lint", "l", true,
 		"lint Files with jsonnet linter before generating output")
+	GenerateCmd.Flags().StringVarP(&cmdGenerateFlags.SignKey,
+		"sign-key", "", "",
+		"ed25519 private key (PEM) used to write a signed digest manifest for each generated cluster")
+	GenerateCmd.Flags().StringVarP(&cmdGenerateFlags.Bundle,
+		"bundle", "", "",
+		"directory to write a reproducible bundle of each generated cluster to")
+	GenerateCmd.Flags().StringVarP(&cmdGenerateFlags.BundleFormat,
+		"bundle-format", "", kr8_bundle.FormatTar,
+		"format of generated bundles - tar for a gzipped tarball, oci for an OCI image layout directory")
+	GenerateCmd.Flags().StringVarP(&cmdGenerateFlags.GitOutput,
+		"git-output", "", "",
+		"local git repository to generate into - changed files are staged and committed. "+
+			"Output is written to the repository root, or to --generate-dir within it if set")
+	GenerateCmd.Flags().StringVarP(&cmdGenerateFlags.Branch,
+		"branch", "", "",
+		"branch of the --git-output repository to commit to - created if it doesn't exist. Defaults to the current branch")
 }
 
 var GenerateCmd = &cobra.Command{
@@ -81,6 +121,31 @@ func GenerateCommand(cmd *cobra.Command, args []string) {
 
 	clusterList := GenerateCmdClusterListBuilder(allClusterParams)
 
+	var signKey ed25519.PrivateKey
+	if cmdGenerateFlags.SignKey != "" {
+		signKey, err = kr8_sign.LoadPrivateKey(cmdGenerateFlags.SignKey)
+		util.FatalErrorCheck("error loading signing key", err, log.Logger)
+	}
+
+	var bundle *kr8_bundle.Options
+	if cmdGenerateFlags.Bundle != "" {
+		bundle = &kr8_bundle.Options{Dir: cmdGenerateFlags.Bundle, Format: cmdGenerateFlags.BundleFormat}
+		util.FatalErrorCheck("invalid bundle options", bundle.Validate(), log.Logger)
+	}
+
+	generateDir := cmdGenerateFlags.GenerateDir
+	var outputRepo *kr8_git.OutputRepo
+	if cmdGenerateFlags.GitOutput != "" {
+		outputRepo, err = kr8_git.OpenOutputRepo(cmdGenerateFlags.GitOutput, cmdGenerateFlags.Branch)
+		util.FatalErrorCheck("error opening git output repository", err, log.Logger)
+		generateDir = outputRepo.Dir
+		if cmd.Flags().Changed("generate-dir") {
+			generateDir = filepath.Join(outputRepo.Dir, cmdGenerateFlags.GenerateDir)
+		}
+	} else if cmdGenerateFlags.Branch != "" {
+		log.Fatal().Msg("--branch requires --git-output")
+	}
+
 	// Setup the threading pools, one for clusters and one for clusters
 	var waitGroup sync.WaitGroup
 	ants_cp, _ := ants.NewPool(RootConfig.Parallel)
@@ -103,13 +168,17 @@ func GenerateCommand(cmd *cobra.Command, args []string) {
 				ClusterName:       clusterName,
 				ClusterDir:        RootConfig.ClusterDir,
 				BaseDir:           RootConfig.BaseDir,
-				GenerateDir:       cmdGenerateFlags.GenerateDir,
+				GenerateDir:       generateDir,
 				Kr8Opts:           kr8Opts,
 				ClusterParamsFile: cmdGenerateFlags.ClusterParamsFile,
 				Filters:           cmdGenerateFlags.Filters,
 				VmConfig:          RootConfig.VMConfig,
 				Noop:              false,
 				Lint:              cmdGenerateFlags.Lint,
+				SignKey:           signKey,
+				Bundle:            bundle,
+				Kr8Version:        version,
+				ArgoCDOutputDir:   "",
 			}
 
 			err = generate.GenProcessCluster(
@@ -122,20 +191,61 @@ func GenerateCommand(cmd *cobra.Command, args []string) {
 		})
 	}
 	waitGroup.Wait()
+
+	if outputRepo != nil {
+		commitGitOutput(outputRepo, generateDir)
+	}
+}
+
+// Stages and commits the generated output in the git output repository.
+// The commit message lists the changed clusters and components, and the source revision.
+func commitGitOutput(outputRepo *kr8_git.OutputRepo, generateDir string) {
+	changed, err := outputRepo.StageChanges(generateDir)
+	util.FatalErrorCheck("error staging generated output", err, log.Logger)
+	if len(changed) == 0 {
+		log.Info().Str("branch", outputRepo.Branch).Msg("Generated output unchanged, nothing to commit")
+
+		return
+	}
+	message := kr8_git.CommitMessage(
+		generate.ChangedComponents(generateDir, changed),
+		kr8_git.SourceRevision(RootConfig.BaseDir),
+	)
+	hash, err := outputRepo.Commit(message)
+	util.FatalErrorCheck("error committing generated output", err, log.Logger)
+	log.Info().Str("branch", outputRepo.Branch).Str("commit", hash.String()).
+		Int("files", len(changed)).Msg("Committed generated output")
 }
 
 func GenerateCmdClusterListBuilder(allClusterParams map[string]string) []string {
+	return buildClusterList(allClusterParams, cmdGenerateFlags.Filters)
+}
+
+// Filters the list of clusters with the cluster, include, exclude and selector filters.
+// The cluster filter takes precedence over the include and exclude filters,
+// and the selector narrows the clusters selected by the others.
+// If no filters are set, all clusters are returned.
+func buildClusterList(allClusterParams map[string]string, filters util.PathFilterOptions) []string {
 	var clusterList []string
 	// Filter out and cluster or components we don't want to generate
-	if cmdGenerateFlags.Filters.Includes != "" ||
-		cmdGenerateFlags.Filters.Excludes != "" ||
-		cmdGenerateFlags.Filters.Clusters != "" {
-		clusterList = util.CalculateClusterIncludesExcludes(allClusterParams, cmdGenerateFlags.Filters)
-		log.Debug().Msg("Have " + strconv.Itoa(len(clusterList)) + " after filtering")
+	if filters.Includes != "" || filters.Excludes != "" || filters.Clusters != "" {
+		if util.IsClusterPathRef(filters.Clusters) {
+			index, err := util.LoadClusterIndex(RootConfig.ClusterDir, jnetvm.ClusterMatrixLoader(RootConfig.VMConfig))
+			util.FatalErrorCheck("error indexing clusters", err, log.Logger)
+			filters.Clusters, err = index.ResolveFilter(filters.Clusters)
+			util.FatalErrorCheck("invalid cluster filter", err, log.Logger)
+		}
+		clusterList = util.CalculateClusterIncludesExcludes(allClusterParams, filters)
 	} else {
 		//nolint:exptostd
 		clusterList = maps.Keys(allClusterParams)
 	}
+	if filters.Selector != "" {
+		var err error
+		clusterList, err = util.SelectItems(allClusterParams, clusterList, filters.Selector)
+		util.FatalErrorCheck("invalid cluster filter", err, log.Logger)
+	}
+	log.Debug().Msg("Have " + strconv.Itoa(len(clusterList)) + " after filtering")
 
 	return clusterList
 }
@@ -16,16 +16,26 @@
 package cmd
 
 import (
+	"github.com/ice-bergtech/kr8/pkg/generate"
 	"github.com/ice-bergtech/kr8/pkg/jnetvm"
+	"github.com/ice-bergtech/kr8/pkg/kr8_types"
 	util "github.com/ice-bergtech/kr8/pkg/util"
 	"github.com/olekukonko/tablewriter"
+	"github.com/olekukonko/tablewriter/tw"
 	"github.com/spf13/cobra"
 	"github.com/tidwall/gjson"
 
+	"encoding/csv"
+	"encoding/json"
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
 
 	"github.com/rs/zerolog/log"
+
+	//nolint:exptostd
+	"golang.org/x/exp/maps"
 )
 
 // GetCmd represents the get command.
@@ -50,6 +60,16 @@ type CmdGetOptions struct {
 	Component string
 	// Param to display from the resource
 	ParamField string
+	// If true, print components in dependency order
+	Order bool
+	// Kubernetes label selector to filter components by their labels
+	ComponentSelector string
+	// If true, print the file that set each param
+	Trace bool
+	// If true, compare params across clusters
+	Compare bool
+	// Output format of --compare, `table`, `csv` or `json`
+	Output string
 }
 
 var cmdGetFlags CmdGetOptions
@@ -70,6 +90,12 @@ func init() {
 	GetComponentsCmd.PersistentFlags().StringVarP(&cmdGetFlags.Cluster,
 		"cluster", "C", "",
 		"get components for cluster")
+	GetComponentsCmd.PersistentFlags().BoolVar(&cmdGetFlags.Order,
+		"order", false,
+		"print components in dependency order, with the wave of each component")
+	GetComponentsCmd.PersistentFlags().StringVarP(&cmdGetFlags.ComponentSelector,
+		"component-selector", "l", "",
+		"only get components with matching labels - kubernetes label selector, for example 'layer=observability'")
 
 	// params
 	GetCmd.AddCommand(GetParamsCmd)
@@ -82,6 +108,20 @@ func init() {
 	GetParamsCmd.PersistentFlags().StringVarP(&cmdGetFlags.ParamField,
 		"param", "P", "",
 		"return value of json param from supplied path")
+	GetParamsCmd.PersistentFlags().StringVarP(&cmdGetFlags.ComponentSelector,
+		"component-selector", "l", "",
+		"only get params of components with matching labels - kubernetes label selector, for example 'layer=observability'")
+	GetParamsCmd.PersistentFlags().BoolVar(&cmdGetFlags.Trace,
+		"trace", false,
+		"print the file that set each param, such as a component's defaults or a profile, and the values it overrode - "+
+			"--component and --param limit the output to params under their path")
+	GetParamsCmd.PersistentFlags().BoolVar(&cmdGetFlags.Compare,
+		"compare", false,
+		"compare params across clusters - --param is a comma separated list of paths, "+
+			"relative to --component if set, and --cluster optionally filters clusters by name or regular expression")
+	GetParamsCmd.PersistentFlags().StringVarP(&cmdGetFlags.Output,
+		"output", "o", compareOutputTable,
+		"output format of --compare - table, csv or json")
 }
 
 var GetClustersCmd = &cobra.Command{
@@ -90,7 +130,7 @@ var GetClustersCmd = &cobra.Command{
 	Long:  "Get all clusters defined in kr8+ config hierarchy",
 	Run: func(cmd *cobra.Command, args []string) {
 
-		clusters, err := util.GetClusterFilenames(RootConfig.ClusterDir)
+		clusters, err := util.GetClusterFilenames(RootConfig.ClusterDir, jnetvm.ClusterMatrixLoader(RootConfig.VMConfig))
 		util.FatalErrorCheck("Error getting clusters", err, log.Logger)
 
 		if cmdGetFlags.NoTable {
@@ -101,18 +141,14 @@ var GetClustersCmd = &cobra.Command{
 			return
 		}
 
-		var entry []string
 		table := tablewriter.NewWriter(os.Stdout)
 		table.Header([]string{"Name", "Path"})
 
 		for _, c := range clusters {
-			entry = append(entry, c.Name)
-			entry = append(entry, c.Path)
-			err = table.Append(entry)
+			err = table.Append([]string{c.Name, c.Path})
 			if err != nil {
 				log.Warn().Err(err).Msg("Row error")
 			}
-			entry = entry[:0]
 		}
 		err = table.Render()
 		if err != nil {
@@ -134,16 +170,28 @@ var GetComponentsCmd = &cobra.Command{
 
 		var params []string
 		if cmdGetFlags.Cluster != "" {
-			clusterPath, err := util.GetClusterPath(RootConfig.ClusterDir, cmdGetFlags.Cluster)
-			util.FatalErrorCheck("error getting cluster path for "+cmdGetFlags.Cluster, err, log.Logger)
-			params = util.GetClusterParamsFilenames(RootConfig.ClusterDir, clusterPath)
+			var err error
+			params, err = jnetvm.ClusterParamsFilenames(RootConfig.VMConfig, RootConfig.ClusterDir, cmdGetFlags.Cluster)
+			util.FatalErrorCheck("error getting cluster params for "+cmdGetFlags.Cluster, err, log.Logger)
 		}
 		if cmdGetFlags.ClusterParams != "" {
 			params = append(params, cmdGetFlags.ClusterParams)
 		}
 
-		jvm, err := jnetvm.JsonnetRenderFiles(RootConfig.VMConfig, params, "._components", true, "", "components", false)
+		if cmdGetFlags.Order {
+			printComponentOrder()
+
+			return
+		}
+
+		components, err := jnetvm.RenderClusterComponents(RootConfig.VMConfig, params, "components", false)
 		util.FatalErrorCheck("error rendering jsonnet files", err, log.Logger)
+		rendered, err := json.Marshal(components)
+		util.FatalErrorCheck("error encoding components", err, log.Logger)
+		jvm := string(rendered)
+		if cmdGetFlags.ComponentSelector != "" {
+			jvm = filterComponents(jvm, selectComponents())
+		}
 		if cmdGetFlags.ParamField != "" {
 			value := gjson.Get(jvm, cmdGetFlags.ParamField)
 			if value.String() == "" {
@@ -161,19 +209,79 @@ var GetComponentsCmd = &cobra.Command{
 	},
 }
 
+// Prints a table of the cluster's components in dependency order.
+func printComponentOrder() {
+	config, err := jnetvm.JsonnetRenderClusterParams(
+		RootConfig.VMConfig,
+		cmdGetFlags.Cluster,
+		nil,
+		cmdGetFlags.ClusterParams,
+		false,
+		false,
+	)
+	util.FatalErrorCheck("error rendering cluster params", err, log.Logger)
+	order, err := kr8_types.ResolveComponentOrder(config)
+	util.FatalErrorCheck("error resolving component dependencies", err, log.Logger)
+	var selected map[string]bool
+	if cmdGetFlags.ComponentSelector != "" {
+		selected = componentsMatching(config)
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.Header([]string{"Wave", "Component", "Depends On"})
+	for _, name := range order.Order {
+		if selected != nil && !selected[name] {
+			continue
+		}
+		err = table.Append([]string{
+			strconv.Itoa(order.Waves[name]),
+			name,
+			strings.Join(order.DependsOn[name], ", "),
+		})
+		if err != nil {
+			log.Warn().Err(err).Msg("Row error")
+		}
+	}
+	err = table.Render()
+	if err != nil {
+		log.Warn().Err(err).Msg("Table error")
+	}
+}
+
 var GetParamsCmd = &cobra.Command{
 	Use:   "params [flags]",
 	Short: "Get parameter for components and clusters",
 	Long:  "Get parameters assigned to clusters and components in the kr8+ config hierarchy",
 	Run: func(cmd *cobra.Command, args []string) {
+		if cmdGetFlags.Compare {
+			compareParams()
+
+			return
+		}
 		if cmdGetFlags.Cluster == "" {
 			log.Fatal().Msg("Please specify a --cluster")
 		}
+		if cmdGetFlags.Trace {
+			printParamsTrace()
+
+			return
+		}
 
 		var cList []string
 		if cmdGetFlags.Component != "" {
 			cList = append(cList, cmdGetFlags.Component)
 		}
+		var selected map[string]bool
+		if cmdGetFlags.ComponentSelector != "" {
+			selected = selectComponents()
+			if cmdGetFlags.Component != "" && !selected[cmdGetFlags.Component] {
+				log.Fatal().Str("component", cmdGetFlags.Component).Msg("component doesn't match --component-selector")
+			}
+			// With no component selected, every component is rendered and then filtered out
+			if cmdGetFlags.Component == "" {
+				cList = maps.Keys(selected) //nolint:exptostd
+			}
+		}
 
 		params, err := jnetvm.JsonnetRenderClusterParams(
 			RootConfig.VMConfig,
@@ -184,6 +292,10 @@ var GetParamsCmd = &cobra.Command{
 			false,
 		)
 		util.FatalErrorCheck("error rendering cluster params", err, log.Logger)
+		if selected != nil && cmdGetFlags.Component == "" {
+			params, err = kr8_types.FilterComponentParams(params, selected)
+			util.FatalErrorCheck("error filtering component params", err, log.Logger)
+		}
 
 		// if we're not filtering the output, just pretty print and finish
 		if cmdGetFlags.ParamField == "" {
@@ -212,3 +324,186 @@ var GetParamsCmd = &cobra.Command{
 		}
 	},
 }
+
+// Prints a table of the cluster's params with the file that set each, and the values it overrode.
+func printParamsTrace() {
+	var cList []string
+	if cmdGetFlags.Component != "" {
+		cList = append(cList, cmdGetFlags.Component)
+	}
+	trace, err := jnetvm.TraceClusterParams(RootConfig.VMConfig, cmdGetFlags.Cluster, cList, cmdGetFlags.ClusterParams)
+	util.FatalErrorCheck("error tracing cluster params", err, log.Logger)
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.Header([]string{"Param", "Value", "Source", "Overridden"})
+	for _, param := range trace {
+		if !underPath(param.Path, cmdGetFlags.Component) || !underPath(param.Path, cmdGetFlags.ParamField) {
+			continue
+		}
+		overridden := make([]string, len(param.Overridden))
+		for idx, value := range param.Overridden {
+			if value.Value == "" {
+				value.Value = "<unresolved>"
+			}
+			overridden[idx] = value.Value + " (" + value.Source + ")"
+		}
+		err := table.Append([]string{param.Path, param.Value, param.Source, strings.Join(overridden, "\n")})
+		if err != nil {
+			log.Warn().Err(err).Msg("Row error")
+		}
+	}
+	if err := table.Render(); err != nil {
+		log.Warn().Err(err).Msg("Table error")
+	}
+}
+
+// Output formats of 'get params --compare'.
+const (
+	compareOutputTable = "table"
+	compareOutputCSV   = "csv"
+	compareOutputJSON  = "json"
+)
+
+// Prints the values of params across clusters.
+func compareParams() {
+	if cmdGetFlags.ParamField == "" {
+		log.Fatal().Msg("Please specify the params to compare with --param")
+	}
+	if cmdGetFlags.ClusterParams != "" {
+		log.Fatal().Msg("--clusterparams can't be combined with --compare")
+	}
+
+	allClusterParams, err := generate.GetClusterParams(RootConfig.ClusterDir, RootConfig.VMConfig, false, log.Logger)
+	util.FatalErrorCheck("error rendering cluster params", err, log.Logger)
+	//nolint:exhaustruct
+	clusters := buildClusterList(allClusterParams, util.PathFilterOptions{Clusters: cmdGetFlags.Cluster})
+
+	comparison, err := generate.CompareClusterParams(
+		clusters,
+		cmdGetFlags.Component,
+		strings.Split(cmdGetFlags.ParamField, ","),
+		RootConfig.VMConfig,
+		false,
+	)
+	util.FatalErrorCheck("error comparing params", err, log.Logger)
+
+	switch cmdGetFlags.Output {
+	case compareOutputTable:
+		printCompareTable(comparison)
+	case compareOutputCSV:
+		writer := csv.NewWriter(os.Stdout)
+		util.FatalErrorCheck("error writing csv", writer.WriteAll(compareRows(comparison, false)), log.Logger)
+	case compareOutputJSON:
+		out, err := json.MarshalIndent(comparison, "", "  ")
+		util.FatalErrorCheck("error encoding comparison", err, log.Logger)
+		fmt.Println(string(out))
+	default:
+		log.Fatal().Str("output", cmdGetFlags.Output).Msg("unknown output format")
+	}
+}
+
+// Prints compared params as a table. Values differing from the most common value are marked with `*`.
+func printCompareTable(comparison *generate.ParamComparison) {
+	rows := compareRows(comparison, true)
+	// Param paths are printed as-is
+	table := tablewriter.NewTable(os.Stdout, tablewriter.WithHeaderAutoFormat(tw.Off))
+	table.Header(rows[0])
+	for _, row := range rows[1:] {
+		if err := table.Append(row); err != nil {
+			log.Warn().Err(err).Msg("Row error")
+		}
+	}
+	if err := table.Render(); err != nil {
+		log.Warn().Err(err).Msg("Table error")
+	}
+}
+
+// Builds a header and a row per cluster of compared params.
+// Strings are printed without quotes, and unset params as `-`.
+// If highlight is set, differing values are marked with `*`, and colored if color is enabled.
+// Otherwise a last column lists the differing params of each cluster.
+func compareRows(comparison *generate.ParamComparison, highlight bool) [][]string {
+	header := append([]string{"cluster"}, comparison.Params...)
+	if !highlight {
+		header = append(header, "differs")
+	}
+	rows := [][]string{header}
+	for _, cluster := range comparison.Clusters {
+		differs := make(map[string]bool, len(cluster.Differs))
+		for _, param := range cluster.Differs {
+			differs[param] = true
+		}
+		row := []string{cluster.Name}
+		for _, param := range comparison.Params {
+			value := "-"
+			if raw, ok := cluster.Values[param]; ok {
+				value = gjson.ParseBytes(raw).String()
+			}
+			if highlight && differs[param] {
+				value = util.Colorize(value+" *", colorRed, !RootConfig.Color)
+			}
+			row = append(row, value)
+		}
+		if !highlight {
+			row = append(row, strings.Join(cluster.Differs, " "))
+		}
+		rows = append(rows, row)
+	}
+
+	return rows
+}
+
+// ANSI color of differing values in 'get params --compare'.
+const colorRed = 31
+
+// Checks if a param path is a path prefix, or is under it. An empty prefix matches every path.
+func underPath(path string, prefix string) bool {
+	return prefix == "" || path == prefix || strings.HasPrefix(path, prefix+".")
+}
+
+// Renders the params of every component of the cluster,
+// and returns the components with labels matching --component-selector.
+func selectComponents() map[string]bool {
+	config, err := jnetvm.JsonnetRenderClusterParams(
+		RootConfig.VMConfig,
+		cmdGetFlags.Cluster,
+		nil,
+		cmdGetFlags.ClusterParams,
+		false,
+		false,
+	)
+	util.FatalErrorCheck("error rendering cluster params", err, log.Logger)
+
+	return componentsMatching(config)
+}
+
+// Returns the components in rendered cluster params with labels matching --component-selector.
+func componentsMatching(config string) map[string]bool {
+	//nolint:exptostd
+	names, err := kr8_types.SelectComponents(
+		maps.Keys(gjson.Get(config, "_components").Map()),
+		config,
+		cmdGetFlags.ComponentSelector,
+	)
+	util.FatalErrorCheck("error selecting components", err, log.Logger)
+	selected := make(map[string]bool, len(names))
+	for _, name := range names {
+		selected[name] = true
+	}
+
+	return selected
+}
+
+// Keeps only the selected components of a json object keyed by component name.
+func filterComponents(object string, selected map[string]bool) string {
+	filtered := map[string]json.RawMessage{}
+	for name, value := range gjson.Parse(object).Map() {
+		if selected[name] {
+			filtered[name] = json.RawMessage(value.Raw)
+		}
+	}
+	out, err := json.Marshal(filtered)
+	util.FatalErrorCheck("error encoding components", err, log.Logger)
+
+	return string(out)
+}
@@ -67,6 +67,21 @@ var InitClusterCmd = &cobra.Command{
 			ClusterOutputDir:   RootConfig.ClusterDir,
 			EnableCache:        true,
 			CompressCache:      true,
+			KubernetesVersion:  "",
+			SchemaValidation:   nil,
+			Policies:           nil,
+			DuplicateResources: "",
+			CommonLabels:       nil,
+			CommonAnnotations:  nil,
+			InjectNamespace:    false,
+			ClusterScopedKinds: nil,
+			ConfigHash:         "",
+			SyncWaves:          false,
+			ArgoCD:             nil,
+			OutputLayout:       "",
+			Profiles:           nil,
+			ComponentOrder:     nil,
+			OutputFiles:        nil,
 		}
 
 		if cmdInitFlags.Interactive {
@@ -158,6 +173,21 @@ and initialize a git repo so you can get started`,
 			ClusterOutputDir:   "generated" + "/" + cmdInitFlags.ClusterName,
 			EnableCache:        true,
 			CompressCache:      true,
+			KubernetesVersion:  "",
+			SchemaValidation:   nil,
+			Policies:           nil,
+			DuplicateResources: "",
+			CommonLabels:       nil,
+			CommonAnnotations:  nil,
+			InjectNamespace:    false,
+			ClusterScopedKinds: nil,
+			ConfigHash:         "",
+			SyncWaves:          false,
+			ArgoCD:             nil,
+			OutputLayout:       "",
+			Profiles:           nil,
+			ComponentOrder:     nil,
+			OutputFiles:        nil,
 		}
 
 		util.FatalErrorCheck(
@@ -0,0 +1,80 @@
+//nolint:gochecknoinits,gochecknoglobals
+package cmd
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+
+	"github.com/ice-bergtech/kr8/pkg/jnetvm"
+	"github.com/ice-bergtech/kr8/pkg/kr8_source"
+	util "github.com/ice-bergtech/kr8/pkg/util"
+)
+
+// Stores the options for the 'sources' command.
+type CmdSourcesOptions struct {
+	// If true, resolve the refs of locked sources again instead of fetching the locked commits
+	Update bool
+}
+
+var cmdSourcesFlags CmdSourcesOptions
+
+func init() {
+	RootCmd.AddCommand(SourcesCmd)
+	SourcesCmd.AddCommand(SourcesFetchCmd)
+	SourcesFetchCmd.Flags().BoolVarP(&cmdSourcesFlags.Update,
+		"update", "u", false,
+		"resolve the refs of every source again and update the lockfile, instead of fetching the locked commits")
+}
+
+// SourcesCmd represents the sources command.
+var SourcesCmd = &cobra.Command{
+	Use:   "sources",
+	Short: "Manage remote component sources",
+	Long:  `Fetch the components that clusters reference with a source instead of a path`,
+}
+
+var SourcesFetchCmd = &cobra.Command{
+	Use:   "fetch [flags]",
+	Short: "Fetch component sources and pin them in the lockfile",
+	Long: `Fetch the git repositories of every component source used by a cluster into the component cache,
+` + "`" + kr8_source.CacheDir + "`" + ` in the base directory.
+The commit each source's ref resolves to is pinned in ` + "`" + kr8_source.LockFile + "`" + `.
+Locked sources are fetched at their locked commit, so generating uses the same commits until --update is set.`,
+	Example: `kr8 sources fetch
+kr8 sources fetch --update`,
+
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		clusters, err := util.GetClusterFilenames(RootConfig.ClusterDir, jnetvm.ClusterMatrixLoader(RootConfig.VMConfig))
+		util.FatalErrorCheck("error getting clusters", err, log.Logger)
+
+		sources := []kr8_source.Source{}
+		for _, cluster := range clusters {
+			clusterSources, err := jnetvm.ClusterComponentSources(RootConfig.VMConfig, cluster.Name)
+			util.FatalErrorCheck("error listing component sources of cluster "+cluster.Name, err, log.Logger)
+			for _, src := range clusterSources {
+				source, err := kr8_source.ParseSource(src)
+				util.FatalErrorCheck("error parsing component source of cluster "+cluster.Name, err, log.Logger)
+				sources = append(sources, source)
+			}
+		}
+
+		lock, err := kr8_source.LoadLock(RootConfig.BaseDir)
+		util.FatalErrorCheck("error loading lockfile", err, log.Logger)
+		err = lock.Fetch(RootConfig.BaseDir, sources, cmdSourcesFlags.Update, log.Logger)
+		util.FatalErrorCheck("error fetching component sources", err, log.Logger)
+		util.FatalErrorCheck("error writing lockfile", lock.Write(RootConfig.BaseDir), log.Logger)
+
+		repos := make([]string, 0, len(lock.Sources))
+		for repo := range lock.Sources {
+			repos = append(repos, repo)
+		}
+		sort.Strings(repos)
+		for _, repo := range repos {
+			fmt.Println(lock.Sources[repo].Commit + " " + repo)
+		}
+	},
+}
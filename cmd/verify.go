@@ -0,0 +1,109 @@
+//nolint:gochecknoinits,gochecknoglobals
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+
+	"github.com/ice-bergtech/kr8/pkg/kr8_sign"
+	util "github.com/ice-bergtech/kr8/pkg/util"
+)
+
+// Stores the options for the 'verify' command.
+type CmdVerifyOptions struct {
+	// Path to the ed25519 public key used to verify manifest signatures
+	PubKey string
+	// Directory containing generated cluster output
+	GenerateDir string
+	// Comma separated list of cluster names to verify. Defaults to all signed clusters
+	Clusters string
+}
+
+var cmdVerifyFlags CmdVerifyOptions
+
+func init() {
+	RootCmd.AddCommand(VerifyCmd)
+	VerifyCmd.Flags().StringVarP(&cmdVerifyFlags.PubKey,
+		"pub-key", "k", "",
+		"ed25519 public key (PEM) to verify manifest signatures with")
+	VerifyCmd.Flags().StringVarP(&cmdVerifyFlags.GenerateDir,
+		"generate-dir", "o", "generated",
+		"directory containing generated cluster output")
+	VerifyCmd.Flags().StringVarP(&cmdVerifyFlags.Clusters,
+		"clusters", "C", "",
+		"clusters to verify - comma separated list of cluster names. Defaults to all signed clusters")
+	_ = VerifyCmd.MarkFlagRequired("pub-key")
+}
+
+var VerifyCmd = &cobra.Command{
+	Use:     "verify [flags]",
+	Short:   "Verify generated output against signed manifests",
+	Long:    `Verify generated cluster output against the digest manifests and signatures written by 'generate --sign-key'`,
+	Example: "kr8 verify --pub-key kr8-sign.pub -o generated",
+
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		pubKey, err := kr8_sign.LoadPublicKey(cmdVerifyFlags.PubKey)
+		util.FatalErrorCheck("error loading public key", err, log.Logger)
+
+		clusters, err := listVerifyClusters(cmdVerifyFlags.GenerateDir, cmdVerifyFlags.Clusters)
+		util.FatalErrorCheck("error listing generated clusters", err, log.Logger)
+		if len(clusters) == 0 {
+			log.Fatal().Str("dir", cmdVerifyFlags.GenerateDir).Msg("no signed cluster output found")
+		}
+
+		failed := false
+		for _, cluster := range clusters {
+			logger := log.With().Str("cluster", cluster).Logger()
+			issues, err := kr8_sign.VerifyClusterOutput(cluster, filepath.Join(cmdVerifyFlags.GenerateDir, cluster), pubKey)
+			if err != nil {
+				logger.Error().Err(err).Msg("verification failed")
+				failed = true
+
+				continue
+			}
+			for _, issue := range issues {
+				logger.Error().Msg(issue)
+			}
+			if len(issues) > 0 {
+				failed = true
+
+				continue
+			}
+			logger.Info().Msg("verified")
+		}
+		if failed {
+			os.Exit(1)
+		}
+	},
+}
+
+// Builds the list of clusters to verify.
+// If no clusters are specified, every cluster directory containing a manifest is returned.
+func listVerifyClusters(generateDir string, clusters string) ([]string, error) {
+	if clusters != "" {
+		return strings.Split(clusters, ","), nil
+	}
+
+	entries, err := os.ReadDir(generateDir)
+	if err != nil {
+		return nil, err
+	}
+	result := []string{}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(generateDir, entry.Name(), kr8_sign.ManifestFile)); err == nil {
+			result = append(result, entry.Name())
+		}
+	}
+	sort.Strings(result)
+
+	return result, nil
+}
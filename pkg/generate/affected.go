@@ -0,0 +1,316 @@
+package generate
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	jsonnet "github.com/google/go-jsonnet"
+	"github.com/rs/zerolog"
+	"github.com/tidwall/gjson"
+
+	//nolint:exptostd
+	"golang.org/x/exp/maps"
+
+	jnetvm "github.com/ice-bergtech/kr8/pkg/jnetvm"
+	"github.com/ice-bergtech/kr8/pkg/kr8_source"
+	"github.com/ice-bergtech/kr8/pkg/kr8_types"
+	types "github.com/ice-bergtech/kr8/pkg/types"
+	util "github.com/ice-bergtech/kr8/pkg/util"
+)
+
+// A cluster affected by a set of changed files.
+type AffectedCluster struct {
+	// Name of the cluster
+	Name string `json:"name"`
+	// True if the cluster parameters changed, so every component of the cluster is affected
+	AllComponents bool `json:"all_components"`
+	// Sorted names of the affected components
+	Components []string `json:"components"`
+}
+
+// Maps changed files to the clusters and components that depend on them.
+// changedFiles are absolute paths, and may include files that were deleted.
+//
+// A cluster's parameters are affected by the files of its params chain, and the files they import.
+// A component is affected by:
+//   - any file in its directory
+//   - its `extfiles`
+//   - files imported by its `params.jsonnet`, jsonnet includes and postprocessor files,
+//     resolved through `lib/` and its `jpaths`
+//   - the sources lockfile, if it is fetched from a source
+//
+// Components with `enable_kr8_allparams` are affected by any affected component of the cluster,
+// components with `enable_kr8_allclusters` by changed parameters of any cluster,
+// and components with `enable_kr8_outputs` by their affected dependencies.
+// Returns the affected clusters sorted by name.
+func FindAffected(
+	changedFiles []string,
+	kr8Opts types.Kr8Opts,
+	vmConfig types.VMConfig,
+	lint bool,
+	logger zerolog.Logger,
+) ([]AffectedCluster, error) {
+	changed := make(map[string]bool, len(changedFiles))
+	for _, file := range changedFiles {
+		changed[filepath.Clean(file)] = true
+	}
+	clusters, err := util.GetClusterFilenames(kr8Opts.ClusterDir, jnetvm.ClusterMatrixLoader(vmConfig))
+	if err != nil {
+		return nil, err
+	}
+
+	// Clusters with changed parameters have every component affected
+	paramsChanged := map[string]bool{}
+	anyParamsChanged := false
+	for _, cluster := range clusters {
+		affected, err := clusterParamsAffected(cluster.Name, changed, kr8Opts, vmConfig)
+		if err != nil {
+			logger.Warn().Err(err).Str("cluster", cluster.Name).
+				Msg("error finding cluster dependencies, assuming all components are affected")
+			affected = true
+		}
+		paramsChanged[cluster.Name] = affected
+		anyParamsChanged = anyParamsChanged || affected
+	}
+
+	result := []AffectedCluster{}
+	for _, cluster := range clusters {
+		subLogger := logger.With().Str("cluster", cluster.Name).Logger()
+		config, err := jnetvm.JsonnetRenderClusterParams(vmConfig, cluster.Name, nil, "", false, lint)
+		if err := util.LogErrorIfCheck("error rendering cluster params", err, subLogger); err != nil {
+			return nil, err
+		}
+		components := maps.Keys(gjson.Get(config, "_components").Map()) //nolint:exptostd
+		sort.Strings(components)
+
+		var affected []string
+		if paramsChanged[cluster.Name] {
+			affected = components
+		} else {
+			affected, err = affectedComponents(config, components, changed, anyParamsChanged, kr8Opts, vmConfig, subLogger)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if len(affected) == 0 {
+			continue
+		}
+		result = append(result, AffectedCluster{
+			Name:          cluster.Name,
+			AllComponents: paramsChanged[cluster.Name],
+			Components:    affected,
+		})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+
+	return result, nil
+}
+
+// Checks if any file of a cluster's params chain, including its profiles, or any file it imports, changed.
+func clusterParamsAffected(
+	clusterName string,
+	changed map[string]bool,
+	kr8Opts types.Kr8Opts,
+	vmConfig types.VMConfig,
+) (bool, error) {
+	params, err := jnetvm.ClusterParamsFilenames(vmConfig, kr8Opts.ClusterDir, clusterName)
+	if err != nil {
+		return false, err
+	}
+	// Matrix clusters depend on their whole matrix file
+	for idx, entry := range params {
+		params[idx], _ = util.SplitClusterParamsEntry(entry)
+	}
+	jvm, err := jnetvm.JsonnetVM(vmConfig)
+	if err != nil {
+		return false, err
+	}
+
+	return dependenciesChanged(jvm, "", params, changed)
+}
+
+// Finds the components of a cluster affected by the changed files.
+// Returns the sorted names of the affected components.
+func affectedComponents(
+	config string,
+	components []string,
+	changed map[string]bool,
+	anyParamsChanged bool,
+	kr8Opts types.Kr8Opts,
+	vmConfig types.VMConfig,
+	logger zerolog.Logger,
+) ([]string, error) {
+	specs := make(map[string]kr8_types.Kr8ComponentSpec, len(components))
+	affected := map[string]bool{}
+	for _, component := range components {
+		compSpec, err := kr8_types.CreateComponentSpec(gjson.Get(config, component+".kr8_spec"), logger)
+		if err != nil {
+			return nil, types.Kr8Error{Message: "error creating component spec for " + component, Value: err}
+		}
+		specs[component] = compSpec
+		isAffected, err := componentAffected(GetComponentPath(config, component), compSpec, changed, kr8Opts, vmConfig)
+		if err != nil {
+			logger.Warn().Err(err).Str("component", component).
+				Msg("error finding component dependencies, assuming the component is affected")
+			isAffected = true
+		}
+		affected[component] = isAffected || (compSpec.Kr8_allClusters && anyParamsChanged)
+	}
+
+	// Propagate to components that read the parameters or outputs of other components
+	for propagated := true; propagated; {
+		propagated = false
+		anyAffected := false
+		for _, isAffected := range affected {
+			anyAffected = anyAffected || isAffected
+		}
+		for _, component := range components {
+			if affected[component] {
+				continue
+			}
+			compSpec := specs[component]
+			if compSpec.Kr8_allParams && anyAffected {
+				affected[component] = true
+			}
+			if compSpec.Kr8_outputs {
+				for _, dependency := range compSpec.DependsOn {
+					affected[component] = affected[component] || affected[dependency]
+				}
+			}
+			propagated = propagated || affected[component]
+		}
+	}
+
+	result := []string{}
+	for _, component := range components {
+		if affected[component] {
+			result = append(result, component)
+		}
+	}
+
+	return result, nil
+}
+
+// Checks if any file a component is generated from changed.
+func componentAffected(
+	compPath string,
+	compSpec kr8_types.Kr8ComponentSpec,
+	changed map[string]bool,
+	kr8Opts types.Kr8Opts,
+	vmConfig types.VMConfig,
+) (bool, error) {
+	compDir, err := filepath.Abs(filepath.Join(kr8Opts.BaseDir, compPath))
+	if err != nil {
+		return false, err
+	}
+	for file := range changed {
+		if file == compDir || strings.HasPrefix(file, compDir+string(filepath.Separator)) {
+			return true, nil
+		}
+	}
+	// Components fetched from a source change with the commit they are pinned to
+	if strings.HasPrefix(compPath, kr8_source.CacheDir+string(filepath.Separator)) {
+		lockFile, err := filepath.Abs(filepath.Join(kr8Opts.BaseDir, kr8_source.LockFile))
+		if err == nil && changed[lockFile] {
+			return true, nil
+		}
+	}
+	for _, extFile := range compSpec.ExtFiles {
+		if changed[filepath.Join(compDir, extFile)] {
+			return true, nil
+		}
+	}
+
+	// Files imported from outside the component directory
+	jvm, err := jnetvm.JsonnetVM(vmConfig)
+	if err != nil {
+		return false, err
+	}
+	jPaths := []string{filepath.Join(vmConfig.BaseDir, "lib")}
+	jPaths = append(jPaths, filepath.SplitList(os.Getenv("KR8_JPATH"))...)
+	jPaths = append(jPaths, vmConfig.JPaths...)
+	for _, jPath := range compSpec.JPaths {
+		jPaths = append(jPaths, filepath.Join(compDir, jPath))
+	}
+	jvm.Importer(&jsonnet.FileImporter{JPaths: jPaths})
+
+	sources := []string{}
+	if _, err := os.Stat(filepath.Join(compDir, "params.jsonnet")); err == nil {
+		sources = append(sources, "params.jsonnet")
+	}
+	for _, include := range compSpec.Includes {
+		if filepath.Ext(include.File) == ".jsonnet" {
+			sources = append(sources, include.File)
+		}
+	}
+	for _, postProcessor := range compSpec.PostProcessors {
+		if postProcessor.File != "" {
+			sources = append(sources, postProcessor.File)
+		}
+	}
+
+	return dependenciesChanged(jvm, compDir+string(filepath.Separator), sources, changed)
+}
+
+// Checks if any of the given jsonnet files, or the files they import, changed.
+// Files are resolved as if imported from importedFrom.
+func dependenciesChanged(jvm *jsonnet.VM, importedFrom string, files []string, changed map[string]bool) (bool, error) {
+	if len(files) == 0 {
+		return false, nil
+	}
+	for _, file := range files {
+		_, foundAt, err := jvm.ImportAST(importedFrom, file)
+		if err != nil {
+			return false, err
+		}
+		if absFile, err := filepath.Abs(foundAt); err == nil && changed[absFile] {
+			return true, nil
+		}
+	}
+	dependencies, err := jvm.FindDependencies(importedFrom, files, jsonnet.WithCanonicalPaths(false))
+	if err != nil {
+		return false, err
+	}
+	for _, dependency := range dependencies {
+		if changed[filepath.Clean(dependency)] {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// Filter matching no cluster or component, used when nothing is affected.
+// An empty filter would match every cluster and component.
+const AffectedNoneFilter = "^$"
+
+// Builds a comma separated filter matching exactly the affected clusters, or the affected components of any cluster,
+// for the `--clusters` and `--components` flags of generate.
+// Cluster names are anchored, as cluster filters match regular expressions anywhere in the name.
+// Returns [AffectedNoneFilter] if nothing is affected.
+func AffectedFilter(affected []AffectedCluster, clusters bool) string {
+	names := map[string]bool{}
+	for _, cluster := range affected {
+		if clusters {
+			names["^"+regexp.QuoteMeta(cluster.Name)+"$"] = true
+
+			continue
+		}
+		for _, component := range cluster.Components {
+			names[regexp.QuoteMeta(component)] = true
+		}
+	}
+	if len(names) == 0 {
+		return AffectedNoneFilter
+	}
+	filter := make([]string, 0, len(names))
+	for name := range names {
+		filter = append(filter, name)
+	}
+	sort.Strings(filter)
+
+	return strings.Join(filter, ",")
+}
@@ -0,0 +1,283 @@
+package generate
+
+import (
+	"bytes"
+	"encoding/json"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/Masterminds/sprig/v3"
+	goyaml "github.com/ghodss/yaml"
+	"github.com/rs/zerolog"
+	"github.com/tidwall/gjson"
+
+	"github.com/ice-bergtech/kr8/pkg/kr8_types"
+	types "github.com/ice-bergtech/kr8/pkg/types"
+	util "github.com/ice-bergtech/kr8/pkg/util"
+)
+
+// Values available to the ArgoCD `path` and `name` templates.
+type argoCDTemplateData struct {
+	Cluster     string
+	Component   string
+	Namespace   string
+	ReleaseName string
+	OutputDir   string
+}
+
+// Name of the bookkeeping file listing the ArgoCD Applications written for a cluster.
+const ArgoCDIndexFile = ".kr8_argocd.json"
+
+// Index of the ArgoCD Applications written for a cluster.
+// Stored in the cluster output directory, so only Applications the cluster wrote are removed.
+type argoCDIndex struct {
+	// Directory the Applications were written to
+	OutputDir string `json:"output_dir"`
+	// Components an Application was written for
+	Components []string `json:"components"`
+}
+
+// Loads the ArgoCD index of a cluster.
+// Returns an empty index if the cluster has none, or if it lists Applications of another output directory.
+func loadArgoCDIndex(clusterOutputDir string, outputDir string) (argoCDIndex, error) {
+	index := argoCDIndex{OutputDir: outputDir, Components: []string{}}
+	data, err := os.ReadFile(filepath.Join(clusterOutputDir, ArgoCDIndexFile))
+	if os.IsNotExist(err) {
+		return index, nil
+	} else if err != nil {
+		return index, util.ErrorIfCheck("error reading ArgoCD index", err)
+	}
+	var previous argoCDIndex
+	if err := json.Unmarshal(data, &previous); err != nil {
+		return index, util.ErrorIfCheck("error parsing ArgoCD index", err)
+	}
+	if previous.OutputDir != outputDir {
+		return index, nil
+	}
+	for _, componentName := range previous.Components {
+		if !filepath.IsLocal(componentName) || filepath.Base(componentName) != componentName {
+			return index, types.Kr8Error{Message: "ArgoCD index lists an invalid component", Value: componentName}
+		}
+	}
+	index.Components = previous.Components
+
+	return index, nil
+}
+
+// Writes an ArgoCD Application for each generated component of a cluster.
+// Applications are written to the configured output directory, one `<component>.yaml` file per component.
+// Applications of components that are disabled or no longer part of the cluster are removed,
+// if they are listed in the cluster's ArgoCD index.
+// Does nothing if `argocd` is not configured for the cluster.
+func EmitArgoCDApplications(
+	kr8Spec kr8_types.Kr8ClusterSpec,
+	compList []string,
+	config string,
+	logger zerolog.Logger,
+) error {
+	if kr8Spec.ArgoCD == nil {
+		return nil
+	}
+	outputDir := kr8Spec.ArgoCD.OutputDir
+	if err := os.MkdirAll(outputDir, 0750); err != nil {
+		return util.ErrorIfCheck("error creating ArgoCD output directory", err)
+	}
+	index, err := loadArgoCDIndex(kr8Spec.ClusterOutputDir, outputDir)
+	if err != nil {
+		return err
+	}
+
+	// Keep the Applications of components that are part of the cluster but not generated now
+	written := []string{}
+	removed := []string{}
+	for _, componentName := range index.Components {
+		switch {
+		case slices.Contains(compList, componentName):
+		case kr8Spec.ComponentOrder == nil || slices.Contains(kr8Spec.ComponentOrder.Order, componentName):
+			written = append(written, componentName)
+		default:
+			removed = append(removed, componentName)
+		}
+	}
+
+	for _, componentName := range compList {
+		outputFile := filepath.Join(outputDir, componentName+".yaml")
+		argocd := kr8Spec.ArgoCD.Merge(kr8_types.ExtractArgoCD(gjson.Get(config, componentName+".kr8_spec")))
+		if argocd.Disabled {
+			if slices.Contains(index.Components, componentName) {
+				removed = append(removed, componentName)
+			}
+
+			continue
+		}
+		app, err := BuildArgoCDApplication(kr8Spec, argocd, componentName, config)
+		if err != nil {
+			return err
+		}
+		updateNeeded, err := CheckIfUpdateNeeded(outputFile, app)
+		if err != nil {
+			return err
+		}
+		if updateNeeded {
+			logger.Debug().Str("component", componentName).Msg("Writing ArgoCD Application " + outputFile)
+			if err := os.WriteFile(outputFile, []byte(app), 0600); err != nil {
+				return err
+			}
+		}
+		written = append(written, componentName)
+	}
+
+	for _, componentName := range removed {
+		logger.Info().Str("component", componentName).Msg("Deleting ArgoCD Application of disabled or removed component")
+		if err := os.Remove(filepath.Join(outputDir, componentName+".yaml")); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	sort.Strings(written)
+	data, err := json.MarshalIndent(argoCDIndex{OutputDir: outputDir, Components: written}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(kr8Spec.ClusterOutputDir, ArgoCDIndexFile), data, 0600)
+}
+
+// Returns the directory of a component's output, relative to the cluster output directory.
+// Without an `output_layout` it is the component name.
+// With an `output_layout` it is the common parent directory of the component's files,
+// which must not contain files of other components, as the Application would deploy them too.
+func componentOutputDir(kr8Spec kr8_types.Kr8ClusterSpec, componentName string) (string, error) {
+	if kr8Spec.OutputFiles == nil {
+		return componentName, nil
+	}
+	files := kr8Spec.OutputFiles[componentName]
+	if len(files) == 0 {
+		return "", types.Kr8Error{
+			Message: "component has no output files to set the ArgoCD `path` from, set `argocd.path`",
+			Value:   componentName,
+		}
+	}
+	outputDir := path.Dir(files[0])
+	for _, file := range files[1:] {
+		for outputDir != "." && !strings.HasPrefix(file, outputDir+"/") {
+			outputDir = path.Dir(outputDir)
+		}
+	}
+	for _, other := range slices.Sorted(maps.Keys(kr8Spec.OutputFiles)) {
+		if other == componentName {
+			continue
+		}
+		for _, file := range kr8Spec.OutputFiles[other] {
+			if outputDir == "." || strings.HasPrefix(file, outputDir+"/") {
+				return "", types.Kr8Error{
+					Message: "the `output_layout` directory of component " + componentName +
+						" also contains files of component " + other + ", set `argocd.path`",
+					Value: outputDir,
+				}
+			}
+		}
+	}
+
+	return outputDir, nil
+}
+
+// Builds the ArgoCD Application yaml for a component.
+// The Application deploys the component's output directory to the component's namespace.
+// If the component order is known, the component's wave is set as the Application's sync wave.
+func BuildArgoCDApplication(
+	kr8Spec kr8_types.Kr8ClusterSpec,
+	argocd kr8_types.Kr8ArgoCDSpec,
+	componentName string,
+	config string,
+) (string, error) {
+	if argocd.RepoURL == "" {
+		return "", types.Kr8Error{Message: "`argocd.repo_url` must be set to generate an Application", Value: componentName}
+	}
+	data := argoCDTemplateData{
+		Cluster:     kr8Spec.Name,
+		Component:   componentName,
+		Namespace:   gjson.Get(config, componentName+".namespace").String(),
+		ReleaseName: gjson.Get(config, componentName+".release_name").String(),
+		OutputDir:   "",
+	}
+	if strings.Contains(argocd.Path, ".OutputDir") {
+		outputDir, err := componentOutputDir(kr8Spec, componentName)
+		if err != nil {
+			return "", err
+		}
+		data.OutputDir = outputDir
+	}
+	name, err := executeArgoCDTemplate("name", argocd.Name, data)
+	if err != nil {
+		return "", err
+	}
+	sourcePath, err := executeArgoCDTemplate("path", argocd.Path, data)
+	if err != nil {
+		return "", err
+	}
+
+	metadata := map[string]any{
+		"name":      name,
+		"namespace": argocd.Namespace,
+	}
+	if kr8Spec.ComponentOrder != nil {
+		if wave, ok := kr8Spec.ComponentOrder.Waves[componentName]; ok {
+			metadata["annotations"] = map[string]any{SyncWaveAnnotation: strconv.Itoa(wave)}
+		}
+	}
+	destination := map[string]any{}
+	if argocd.DestinationName != "" {
+		destination["name"] = argocd.DestinationName
+	} else {
+		destination["server"] = argocd.DestinationServer
+	}
+	if data.Namespace != "" {
+		destination["namespace"] = data.Namespace
+	}
+	spec := map[string]any{
+		"project": argocd.Project,
+		"source": map[string]any{
+			"repoURL":        argocd.RepoURL,
+			"targetRevision": argocd.TargetRevision,
+			"path":           sourcePath,
+		},
+		"destination": destination,
+	}
+	if argocd.SyncPolicy != nil {
+		spec["syncPolicy"] = argocd.SyncPolicy
+	}
+
+	buf, err := goyaml.Marshal(map[string]any{
+		"apiVersion": "argoproj.io/v1alpha1",
+		"kind":       "Application",
+		"metadata":   metadata,
+		"spec":       spec,
+	})
+	if err != nil {
+		return "", util.ErrorIfCheck("Error marshalling ArgoCD Application to yaml", err)
+	}
+
+	return string(buf), nil
+}
+
+// Executes an ArgoCD `path` or `name` template with Sprig functions.
+func executeArgoCDTemplate(field string, text string, data argoCDTemplateData) (string, error) {
+	tmpl, err := template.New(field).Funcs(sprig.FuncMap()).Parse(text)
+	if err != nil {
+		return "", types.Kr8Error{Message: "error parsing `argocd." + field + "` template", Value: err}
+	}
+	var buffer bytes.Buffer
+	if err := tmpl.Execute(&buffer, data); err != nil {
+		return "", types.Kr8Error{Message: "error executing `argocd." + field + "` template", Value: err}
+	}
+
+	return buffer.String(), nil
+}
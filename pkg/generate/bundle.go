@@ -0,0 +1,48 @@
+package generate
+
+import (
+	"path/filepath"
+
+	"github.com/tidwall/gjson"
+
+	"github.com/ice-bergtech/kr8/pkg/kr8_bundle"
+	"github.com/ice-bergtech/kr8/pkg/kr8_types"
+)
+
+// Builds the bundle metadata for a generated cluster.
+// Input hashes cover the cluster params, the base `lib` directory, and each component in compList.
+// The output digests are added when the bundle is written, and cover every file of the bundle.
+func BuildBundleMetadata(
+	kr8Spec kr8_types.Kr8ClusterSpec,
+	compList []string,
+	config string,
+	baseDir string,
+	kr8Version string,
+) (kr8_bundle.Metadata, error) {
+	libraryHash, err := kr8_bundle.HashDir(filepath.Join(baseDir, "lib"))
+	if err != nil {
+		return kr8_bundle.Metadata{}, err
+	}
+	components := make(map[string]kr8_bundle.ComponentInputs, len(compList))
+	for _, componentName := range compList {
+		filesHash, err := kr8_bundle.HashDir(filepath.Join(baseDir, GetComponentPath(config, componentName)))
+		if err != nil {
+			return kr8_bundle.Metadata{}, err
+		}
+		components[componentName] = kr8_bundle.ComponentInputs{
+			Params: kr8_bundle.HashString(gjson.Get(config, componentName).Raw),
+			Files:  filesHash,
+		}
+	}
+
+	return kr8_bundle.Metadata{
+		Kr8Version: kr8Version,
+		Cluster:    kr8Spec.Name,
+		Inputs: kr8_bundle.Inputs{
+			Cluster:    kr8_bundle.HashString(gjson.Get(config, "_kr8_spec").Raw + gjson.Get(config, "_cluster").Raw),
+			Library:    libraryHash,
+			Components: components,
+		},
+		Outputs: nil,
+	}, nil
+}
@@ -0,0 +1,183 @@
+package generate
+
+import (
+	"os"
+	"slices"
+	"strconv"
+
+	"github.com/rs/zerolog"
+
+	jnetvm "github.com/ice-bergtech/kr8/pkg/jnetvm"
+	"github.com/ice-bergtech/kr8/pkg/kr8_check"
+	"github.com/ice-bergtech/kr8/pkg/kr8_types"
+	types "github.com/ice-bergtech/kr8/pkg/types"
+	util "github.com/ice-bergtech/kr8/pkg/util"
+)
+
+// Checks if any cluster-level checks are configured in the cluster spec.
+// Duplicate resource detection is enabled unless `duplicate_resources` is set to `ignore`.
+// Deprecated API detection is enabled when `kubernetes_version` is set.
+func ClusterChecksEnabled(kr8Spec kr8_types.Kr8ClusterSpec) bool {
+	return (kr8Spec.SchemaValidation != nil && kr8Spec.SchemaValidation.Enabled) ||
+		len(kr8Spec.Policies) > 0 ||
+		kr8Spec.KubernetesVersion != "" ||
+		kr8Spec.DuplicateResources != "ignore"
+}
+
+// Runs the configured checks against the generated output of a cluster and returns the findings.
+// Output is loaded from the cluster output directory, so components skipped by the cache are included.
+// Only objects from components in compList are checked, but all generated objects are used
+// to find duplicate resources and CRD schemas.
+// config is the rendered cluster configuration passed to policies.
+func CheckClusterOutput(
+	kr8Spec kr8_types.Kr8ClusterSpec,
+	compList []string,
+	config string,
+	vmConfig types.VMConfig,
+) ([]kr8_check.Finding, error) {
+	if !ClusterChecksEnabled(kr8Spec) {
+		return []kr8_check.Finding{}, nil
+	}
+
+	allObjects, loadFindings, err := loadGeneratedObjects(kr8Spec)
+	if err != nil {
+		return nil, util.ErrorIfCheck("error loading generated objects", err)
+	}
+	findings := []kr8_check.Finding{}
+	for _, finding := range loadFindings {
+		if slices.Contains(compList, finding.Component) {
+			findings = append(findings, finding)
+		}
+	}
+	selected := make([]kr8_check.Object, 0, len(allObjects))
+	for _, obj := range allObjects {
+		if slices.Contains(compList, obj.Component) {
+			selected = append(selected, obj)
+		}
+	}
+
+	if kr8Spec.DuplicateResources != "ignore" {
+		severity := kr8_check.ParseSeverity(kr8Spec.DuplicateResources, kr8_check.SeverityWarn)
+		findings = append(findings, kr8_check.FindDuplicates(allObjects, compList, severity)...)
+	}
+
+	if kr8Spec.KubernetesVersion != "" {
+		deprecationFindings, err := kr8_check.FindDeprecatedAPIs(selected, kr8Spec.KubernetesVersion, kr8_check.APIDeprecations)
+		if err != nil {
+			return nil, err
+		}
+		findings = append(findings, deprecationFindings...)
+	}
+
+	if kr8Spec.SchemaValidation != nil && kr8Spec.SchemaValidation.Enabled {
+		validator, crdFindings := kr8_check.NewSchemaValidator(kr8_check.SchemaOptions{
+			SchemaDir:         kr8Spec.SchemaValidation.SchemaDir,
+			KubernetesVersion: kr8Spec.KubernetesVersion,
+			Strict:            kr8Spec.SchemaValidation.Strict,
+			IgnoreMissing:     kr8Spec.SchemaValidation.IgnoreMissing,
+		}, allObjects)
+		findings = append(findings, crdFindings...)
+		findings = append(findings, validator.ValidateObjects(selected)...)
+	}
+
+	if len(kr8Spec.Policies) > 0 {
+		jvm, err := jnetvm.JsonnetVM(vmConfig)
+		if err != nil {
+			return nil, err
+		}
+		policyFindings, err := kr8_check.EvaluatePolicies(jvm, vmConfig.BaseDir, kr8Spec.Policies, selected, config)
+		if err != nil {
+			return nil, err
+		}
+		findings = append(findings, policyFindings...)
+	}
+	kr8_check.SetCluster(findings, kr8Spec.Name)
+	kr8_check.SortFindings(findings)
+
+	return findings, nil
+}
+
+// Runs checks against the generated output of a cluster once all components are generated.
+// Findings are logged, see [CheckClusterOutput].
+// Returns an error if any finding has a deny severity.
+func RunClusterChecks(
+	kr8Spec kr8_types.Kr8ClusterSpec,
+	compList []string,
+	config string,
+	vmConfig types.VMConfig,
+	logger zerolog.Logger,
+) error {
+	if !ClusterChecksEnabled(kr8Spec) {
+		return nil
+	}
+
+	findings, err := CheckClusterOutput(kr8Spec, compList, config, vmConfig)
+	if err := util.LogErrorIfCheck("error checking generated output", err, logger); err != nil {
+		return err
+	}
+	if denied := kr8_check.LogFindings(findings, logger); denied > 0 {
+		return types.Kr8Error{Message: "generated output failed checks", Value: strconv.Itoa(denied) + " denied findings"}
+	}
+	logger.Debug().Int("findings", len(findings)).Msg("Cluster checks complete")
+
+	return nil
+}
+
+// Checks the existing generated output of a cluster without generating it.
+// The cluster configuration is compiled to find the components, checks and parameters to use.
+func CheckGeneratedCluster(
+	clusterConfig *GenerateProcessRootConfig,
+	logger zerolog.Logger,
+) (*kr8_types.Kr8ClusterSpec, []kr8_check.Finding, error) {
+	kr8Spec, clusterComponents, err := CompileClusterConfiguration(
+		clusterConfig.ClusterName,
+		clusterConfig.ClusterDir,
+		clusterConfig.Kr8Opts,
+		clusterConfig.VmConfig,
+		clusterConfig.GenerateDir,
+		clusterConfig.Lint,
+		logger,
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !ClusterChecksEnabled(*kr8Spec) {
+		return kr8Spec, []kr8_check.Finding{}, nil
+	}
+	if _, err := os.Stat(kr8Spec.ClusterOutputDir); err != nil {
+		return nil, nil, types.Kr8Error{Message: "cluster output not found, run generate first", Value: kr8Spec.ClusterOutputDir}
+	}
+
+	if kr8Spec.OutputLayout != "" {
+		index, err := LoadOutputIndex(kr8Spec.ClusterOutputDir)
+		if err != nil {
+			return nil, nil, err
+		}
+		kr8Spec.OutputFiles = map[string][]string{}
+		if index != nil {
+			kr8Spec.OutputFiles = index.Files
+		}
+	}
+
+	compList, err := SelectClusterComponents(
+		kr8Spec.Name, clusterComponents, clusterConfig.Filters,
+		clusterConfig.VmConfig, clusterConfig.ClusterParamsFile, clusterConfig.Lint,
+	)
+	if err := util.LogErrorIfCheck("error selecting components", err, logger); err != nil {
+		return nil, nil, err
+	}
+	config, err := jnetvm.JsonnetRenderClusterParams(
+		clusterConfig.VmConfig,
+		kr8Spec.Name,
+		compList,
+		clusterConfig.ClusterParamsFile,
+		false,
+		clusterConfig.Lint,
+	)
+	if err := util.LogErrorIfCheck("error rendering cluster params", err, logger); err != nil {
+		return nil, nil, err
+	}
+	findings, err := CheckClusterOutput(*kr8Spec, compList, config, clusterConfig.VmConfig)
+
+	return kr8Spec, findings, err
+}
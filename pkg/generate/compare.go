@@ -0,0 +1,121 @@
+package generate
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+
+	"github.com/tidwall/gjson"
+
+	"github.com/ice-bergtech/kr8/pkg/jnetvm"
+	types "github.com/ice-bergtech/kr8/pkg/types"
+	util "github.com/ice-bergtech/kr8/pkg/util"
+)
+
+// Values of parameters across clusters.
+type ParamComparison struct {
+	// Compared parameter paths
+	Params []string `json:"params"`
+	// Most common value of each parameter. Parameters most commonly unset are left out
+	Common map[string]json.RawMessage `json:"common"`
+	// Values in each cluster, sorted by cluster name
+	Clusters []ClusterParamValues `json:"clusters"`
+}
+
+// Values of parameters in a cluster.
+type ClusterParamValues struct {
+	// Name of the cluster
+	Name string `json:"name"`
+	// Value of each parameter. Unset parameters are left out
+	Values map[string]json.RawMessage `json:"values"`
+	// Parameters whose value differs from the most common value
+	Differs []string `json:"differs"`
+}
+
+// Renders parameters in each cluster and compares their values.
+// If component is set, params are paths within the component's params,
+// and clusters without the component are left out. Otherwise params are paths within the cluster params.
+func CompareClusterParams(
+	clusters []string,
+	component string,
+	params []string,
+	vmConfig types.VMConfig,
+	lint bool,
+) (*ParamComparison, error) {
+	var componentNames []string
+	prefix := ""
+	if component != "" {
+		componentNames = []string{component}
+		prefix = gjson.Escape(component) + "."
+	}
+
+	sorted := append([]string{}, clusters...)
+	sort.Strings(sorted)
+	comparison := &ParamComparison{
+		Params:   params,
+		Common:   map[string]json.RawMessage{},
+		Clusters: []ClusterParamValues{},
+	}
+	for _, cluster := range sorted {
+		config, err := jnetvm.JsonnetRenderClusterParams(vmConfig, cluster, componentNames, "", true, lint)
+		if err != nil {
+			return nil, util.ErrorIfCheck("error rendering params of cluster "+cluster, err)
+		}
+		if component != "" && !gjson.Get(config, "_components."+gjson.Escape(component)).Exists() {
+			continue
+		}
+		values := ClusterParamValues{Name: cluster, Values: map[string]json.RawMessage{}, Differs: []string{}}
+		for _, param := range params {
+			result := gjson.Get(config, prefix+param)
+			if !result.Exists() {
+				continue
+			}
+			var buffer bytes.Buffer
+			if err := json.Compact(&buffer, []byte(result.Raw)); err != nil {
+				return nil, util.ErrorIfCheck("error formatting param "+param, err)
+			}
+			values.Values[param] = buffer.Bytes()
+		}
+		comparison.Clusters = append(comparison.Clusters, values)
+	}
+
+	for _, param := range params {
+		common, set := mostCommonValue(comparison.Clusters, param)
+		if set {
+			comparison.Common[param] = common
+		}
+		for idx, cluster := range comparison.Clusters {
+			value, ok := cluster.Values[param]
+			if ok != set || string(value) != string(common) {
+				comparison.Clusters[idx].Differs = append(comparison.Clusters[idx].Differs, param)
+			}
+		}
+	}
+
+	return comparison, nil
+}
+
+// Finds the most common value of a parameter, and if it's most commonly set.
+// Ties go to the value of the first cluster, in name order.
+func mostCommonValue(clusters []ClusterParamValues, param string) (json.RawMessage, bool) {
+	counts := map[string]int{}
+	best, bestCount := "", 0
+	bestSet := false
+	for _, cluster := range clusters {
+		value, ok := cluster.Values[param]
+		// Unset is counted separately from every value
+		key := "unset"
+		if ok {
+			key = "=" + string(value)
+		}
+		counts[key]++
+		if counts[key] > bestCount {
+			best, bestCount, bestSet = string(value), counts[key], ok
+		}
+	}
+	if !bestSet {
+		return nil, false
+	}
+
+	return json.RawMessage(best), true
+}
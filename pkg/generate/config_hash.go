@@ -0,0 +1,371 @@
+package generate
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	goyaml "github.com/ghodss/yaml"
+	"github.com/rs/zerolog"
+	"github.com/tidwall/gjson"
+	"k8s.io/apimachinery/pkg/util/yaml"
+
+	"github.com/ice-bergtech/kr8/pkg/kr8_check"
+	"github.com/ice-bergtech/kr8/pkg/kr8_types"
+	util "github.com/ice-bergtech/kr8/pkg/util"
+)
+
+// Prefix of the annotations added to pod templates for each referenced ConfigMap or Secret.
+const ConfigHashAnnotationPrefix = "checksum/"
+
+// A ConfigMap or Secret that pod templates can reference.
+type configSource struct {
+	component string
+	namespace string
+	hash      string
+}
+
+// Identifies a ConfigMap or Secret by kind and name.
+type configSourceKey struct {
+	kind string
+	name string
+}
+
+// Adds `checksum/<name>` annotations to the pod templates of generated workloads.
+// Each annotation holds a hash of the data of a ConfigMap or Secret the pod template references,
+// so workloads roll when the generated config changes.
+// Only components with a `config_hash` scope of `component` or `cluster` are updated.
+// Files are rewritten only if an annotation changed.
+func ApplyConfigHashes(
+	kr8Spec kr8_types.Kr8ClusterSpec,
+	compList []string,
+	config string,
+	logger zerolog.Logger,
+) error {
+	scopes := map[string]string{}
+	for _, component := range compList {
+		scope := gjson.Get(config, component+".kr8_spec.config_hash").String()
+		if scope == "" {
+			scope = kr8Spec.ConfigHash
+		}
+		if scope == kr8_types.ConfigHashComponent || scope == kr8_types.ConfigHashCluster {
+			scopes[component] = scope
+		}
+	}
+	if len(scopes) == 0 {
+		return nil
+	}
+
+	objects, _, err := loadGeneratedObjects(kr8Spec)
+	if err != nil {
+		return err
+	}
+	sources, err := collectConfigSources(objects, config)
+	if err != nil {
+		return err
+	}
+
+	components := make([]string, 0, len(scopes))
+	for component := range scopes {
+		components = append(components, component)
+	}
+	sort.Strings(components)
+	for _, component := range components {
+		files, err := componentOutputFiles(kr8Spec, component)
+		if err != nil {
+			return err
+		}
+		hasher := configHasher{
+			sources:   sources,
+			component: component,
+			namespace: gjson.Get(config, component+".namespace").String(),
+			cluster:   scopes[component] == kr8_types.ConfigHashCluster,
+		}
+		for _, file := range files {
+			ext := filepath.Ext(file)
+			if ext != ".yaml" && ext != ".yml" {
+				continue
+			}
+			updated, err := hasher.updateFile(file)
+			if err != nil {
+				return util.ErrorIfCheck("error adding config hashes to "+file, err)
+			}
+			if updated {
+				logger.Debug().Str("component", component).Msg("Updated config hashes in " + file)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Computes content hashes for the generated ConfigMaps and Secrets of a cluster.
+// Objects without a namespace are placed in their component's `namespace` parameter.
+func collectConfigSources(objects []kr8_check.Object, config string) (map[configSourceKey][]configSource, error) {
+	sources := map[configSourceKey][]configSource{}
+	for _, object := range objects {
+		if object.Kind() != "ConfigMap" && object.Kind() != "Secret" {
+			continue
+		}
+		content := map[string]any{}
+		for _, field := range []string{"data", "binaryData", "stringData"} {
+			if value, ok := object.Data[field]; ok {
+				content[field] = value
+			}
+		}
+		// json.Marshal sorts map keys, so the hash is stable
+		contentJSON, err := json.Marshal(content)
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(contentJSON)
+		namespace := object.Namespace()
+		if namespace == "" {
+			namespace = gjson.Get(config, object.Component+".namespace").String()
+		}
+		key := configSourceKey{kind: object.Kind(), name: object.Name()}
+		sources[key] = append(sources[key], configSource{
+			component: object.Component,
+			namespace: namespace,
+			hash:      hex.EncodeToString(sum[:]),
+		})
+	}
+
+	return sources, nil
+}
+
+// Adds config hash annotations to the workloads of a single component.
+type configHasher struct {
+	sources map[configSourceKey][]configSource
+	// Component being updated
+	component string
+	// Default namespace of the component
+	namespace string
+	// If true, sources from any component of the cluster are matched
+	cluster bool
+}
+
+// Adds config hash annotations to the objects in a generated yaml file.
+// Returns true if the file was rewritten.
+func (h configHasher) updateFile(file string) (bool, error) {
+	data, err := os.ReadFile(filepath.Clean(file))
+	if err != nil {
+		return false, err
+	}
+	reader := yaml.NewYAMLReader(bufio.NewReader(bytes.NewReader(data)))
+	docs := []any{}
+	updated := false
+	for {
+		doc, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			// Not a yaml stream, such as a rendered template
+			return false, nil //nolint:nilerr
+		}
+		var value any
+		if err := goyaml.Unmarshal(doc, &value); err != nil {
+			return false, nil //nolint:nilerr
+		}
+		if value == nil {
+			continue
+		}
+		if h.updateValue(value) {
+			updated = true
+		}
+		docs = append(docs, value)
+	}
+	if !updated {
+		return false, nil
+	}
+
+	// Written the same way as jsonnet output, see [ProcessJsonnetToYaml]
+	var outStr strings.Builder
+	for idx, doc := range docs {
+		if idx > 0 {
+			outStr.WriteString("---\n")
+		}
+		buf, err := goyaml.Marshal(doc)
+		if err != nil {
+			return false, err
+		}
+		outStr.WriteString(string(buf) + "\n")
+	}
+
+	return true, os.WriteFile(file, []byte(outStr.String()), 0600)
+}
+
+// Adds config hash annotations to an object, or the items of a list.
+// Returns true if any annotation changed.
+func (h configHasher) updateValue(value any) bool {
+	updated := false
+	switch val := value.(type) {
+	case []any:
+		for _, item := range val {
+			updated = h.updateValue(item) || updated
+		}
+	case map[string]any:
+		if items, ok := val["items"].([]any); ok {
+			for _, item := range items {
+				updated = h.updateValue(item) || updated
+			}
+		}
+		template := podTemplate(val)
+		if template == nil {
+			return updated
+		}
+		namespace := h.namespace
+		if metadata, ok := val["metadata"].(map[string]any); ok {
+			if ns, ok := metadata["namespace"].(string); ok && ns != "" {
+				namespace = ns
+			}
+		}
+		hashes := h.referencedHashes(template, namespace)
+		if len(hashes) == 0 {
+			return updated
+		}
+		metadata, ok := template["metadata"].(map[string]any)
+		if !ok {
+			metadata = map[string]any{}
+			template["metadata"] = metadata
+		}
+		annotations, ok := metadata["annotations"].(map[string]any)
+		if !ok {
+			annotations = map[string]any{}
+			metadata["annotations"] = annotations
+		}
+		for name, hash := range hashes {
+			key := ConfigHashAnnotationPrefix + name
+			if annotations[key] != hash {
+				annotations[key] = hash
+				updated = true
+			}
+		}
+	}
+
+	return updated
+}
+
+// Returns the pod template of a workload, or nil if the object has none.
+// Handles `spec.template`, used by Deployments, StatefulSets, DaemonSets and Jobs,
+// and `spec.jobTemplate.spec.template`, used by CronJobs.
+func podTemplate(object map[string]any) map[string]any {
+	spec, _ := object["spec"].(map[string]any)
+	if jobTemplate, ok := spec["jobTemplate"].(map[string]any); ok {
+		spec, _ = jobTemplate["spec"].(map[string]any)
+	}
+	template, _ := spec["template"].(map[string]any)
+	if _, ok := template["spec"].(map[string]any); !ok {
+		return nil
+	}
+
+	return template
+}
+
+// Returns the hashes of the ConfigMaps and Secrets referenced by a pod template, keyed by name.
+// If a ConfigMap and Secret share a name, their hashes are combined.
+func (h configHasher) referencedHashes(template map[string]any, namespace string) map[string]string {
+	refs := map[configSourceKey]bool{}
+	podSpec, _ := template["spec"].(map[string]any)
+	for _, volume := range asMaps(podSpec["volumes"]) {
+		addRef(refs, "ConfigMap", volume, "configMap", "name")
+		addRef(refs, "Secret", volume, "secret", "secretName")
+		if projected, ok := volume["projected"].(map[string]any); ok {
+			for _, source := range asMaps(projected["sources"]) {
+				addRef(refs, "ConfigMap", source, "configMap", "name")
+				addRef(refs, "Secret", source, "secret", "name")
+			}
+		}
+	}
+	for _, field := range []string{"initContainers", "containers", "ephemeralContainers"} {
+		for _, container := range asMaps(podSpec[field]) {
+			for _, envFrom := range asMaps(container["envFrom"]) {
+				addRef(refs, "ConfigMap", envFrom, "configMapRef", "name")
+				addRef(refs, "Secret", envFrom, "secretRef", "name")
+			}
+			for _, env := range asMaps(container["env"]) {
+				valueFrom, _ := env["valueFrom"].(map[string]any)
+				addRef(refs, "ConfigMap", valueFrom, "configMapKeyRef", "name")
+				addRef(refs, "Secret", valueFrom, "secretKeyRef", "name")
+			}
+		}
+	}
+
+	hashes := map[string][]string{}
+	for _, kind := range []string{"ConfigMap", "Secret"} {
+		for ref := range refs {
+			if ref.kind != kind {
+				continue
+			}
+			if hash, ok := h.lookup(ref, namespace); ok {
+				hashes[ref.name] = append(hashes[ref.name], hash)
+			}
+		}
+	}
+	result := make(map[string]string, len(hashes))
+	for name, sums := range hashes {
+		if len(sums) == 1 {
+			result[name] = sums[0]
+
+			continue
+		}
+		sum := sha256.Sum256([]byte(strings.Join(sums, "")))
+		result[name] = hex.EncodeToString(sum[:])
+	}
+
+	return result
+}
+
+// Finds the hash of a referenced ConfigMap or Secret in the same namespace.
+// Sources from the component being updated are preferred.
+func (h configHasher) lookup(ref configSourceKey, namespace string) (string, bool) {
+	var found *configSource
+	for idx, source := range h.sources[ref] {
+		if source.namespace != namespace {
+			continue
+		}
+		if source.component == h.component {
+			return source.hash, true
+		}
+		if h.cluster && found == nil {
+			found = &h.sources[ref][idx]
+		}
+	}
+	if found == nil {
+		return "", false
+	}
+
+	return found.hash, true
+}
+
+// Records a reference to a ConfigMap or Secret named in `parent[field][nameField]`.
+func addRef(refs map[configSourceKey]bool, kind string, parent map[string]any, field string, nameField string) {
+	ref, ok := parent[field].(map[string]any)
+	if !ok {
+		return
+	}
+	if name, ok := ref[nameField].(string); ok && name != "" {
+		refs[configSourceKey{kind: kind, name: name}] = true
+	}
+}
+
+// Returns the objects in a list value, skipping anything that is not an object.
+func asMaps(value any) []map[string]any {
+	list, _ := value.([]any)
+	result := make([]map[string]any, 0, len(list))
+	for _, item := range list {
+		if obj, ok := item.(map[string]any); ok {
+			result = append(result, obj)
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,139 @@
+package generate
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"github.com/rs/zerolog"
+	"github.com/tidwall/gjson"
+
+	"github.com/ice-bergtech/kr8/pkg/jnetvm"
+	"github.com/ice-bergtech/kr8/pkg/kr8_check"
+	"github.com/ice-bergtech/kr8/pkg/kr8_diff"
+	"github.com/ice-bergtech/kr8/pkg/kr8_types"
+	types "github.com/ice-bergtech/kr8/pkg/types"
+	util "github.com/ice-bergtech/kr8/pkg/util"
+)
+
+// Compares the rendered params of two clusters.
+// Returns a section for `_cluster`, `_components` and each component's merged params, in that order.
+// Sections without differences are left out.
+func DiffClusterParams(
+	clusterA string,
+	clusterB string,
+	vmConfig types.VMConfig,
+	lint bool,
+) ([]kr8_diff.Section, error) {
+	configA, err := jnetvm.JsonnetRenderClusterParams(vmConfig, clusterA, nil, "", true, lint)
+	if err != nil {
+		return nil, util.ErrorIfCheck("error rendering params of cluster "+clusterA, err)
+	}
+	configB, err := jnetvm.JsonnetRenderClusterParams(vmConfig, clusterB, nil, "", true, lint)
+	if err != nil {
+		return nil, util.ErrorIfCheck("error rendering params of cluster "+clusterB, err)
+	}
+	paramsA, paramsB := gjson.Parse(configA), gjson.Parse(configB)
+
+	components := map[string]bool{}
+	for name := range paramsA.Get("_components").Map() {
+		components[name] = true
+	}
+	for name := range paramsB.Get("_components").Map() {
+		components[name] = true
+	}
+	names := make([]string, 0, len(components))
+	for name := range components {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	sections := []kr8_diff.Section{}
+	for _, name := range append([]string{"_cluster", "_components"}, names...) {
+		changes := kr8_diff.Diff(paramsA.Get(gjson.Escape(name)), paramsB.Get(gjson.Escape(name)))
+		if len(changes) > 0 {
+			sections = append(sections, kr8_diff.Section{Name: name, Changes: changes})
+		}
+	}
+
+	return sections, nil
+}
+
+// Compares the generated objects of two clusters, component by component.
+// Objects are matched by resource identity, so they may be in different files.
+// substitutions are applied to the first cluster's objects, to normalize differences such as the cluster name.
+// Returns a section for each object that differs, named after its component and identity, sorted by name.
+func DiffClusterOutput(
+	clusterOutputDirA string,
+	clusterOutputDirB string,
+	substitutions []kr8_diff.Substitution,
+	logger zerolog.Logger,
+) ([]kr8_diff.Section, error) {
+	objectsA, err := loadDiffObjects(clusterOutputDirA, substitutions, logger)
+	if err != nil {
+		return nil, err
+	}
+	objectsB, err := loadDiffObjects(clusterOutputDirB, nil, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(objectsA)+len(objectsB))
+	for key := range objectsA {
+		keys = append(keys, key)
+	}
+	for key := range objectsB {
+		if _, ok := objectsA[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	sections := []kr8_diff.Section{}
+	for _, key := range keys {
+		changes := kr8_diff.Diff(gjson.ParseBytes(objectsA[key]), gjson.ParseBytes(objectsB[key]))
+		if len(changes) > 0 {
+			sections = append(sections, kr8_diff.Section{Name: key, Changes: changes})
+		}
+	}
+
+	return sections, nil
+}
+
+// Loads the generated objects of a cluster as json, keyed by component and resource identity.
+// Files that can't be parsed are logged and skipped.
+func loadDiffObjects(
+	clusterOutputDir string,
+	substitutions []kr8_diff.Substitution,
+	logger zerolog.Logger,
+) (map[string][]byte, error) {
+	//nolint:exhaustruct
+	kr8Spec := kr8_types.Kr8ClusterSpec{ClusterOutputDir: clusterOutputDir}
+	index, err := LoadOutputIndex(clusterOutputDir)
+	if err != nil {
+		return nil, err
+	}
+	if index != nil {
+		kr8Spec.OutputFiles = index.Files
+	}
+	objects, findings, err := loadGeneratedObjects(kr8Spec)
+	if err != nil {
+		return nil, util.ErrorIfCheck("error loading generated objects", err)
+	}
+	for _, finding := range findings {
+		logger.Warn().Str("component", finding.Component).Str("file", finding.File).Msg(finding.Message)
+	}
+
+	result := make(map[string][]byte, len(objects))
+	for _, obj := range objects {
+		data, _ := kr8_diff.Substitute(obj.Data, substitutions).(map[string]any)
+		obj.Data = data
+		encoded, err := json.Marshal(obj.Data)
+		if err != nil {
+			return nil, util.ErrorIfCheck("error encoding object "+obj.String(), err)
+		}
+		result[obj.Component+": "+strings.TrimPrefix(kr8_check.ResourceIdentity(obj), "/")] = encoded
+	}
+
+	return result, nil
+}
@@ -40,6 +40,15 @@ func processIncludesFile(
 		outputDir = filepath.Join(componentOutputDir, incInfo.DestDir)
 		logger.Debug().Msg("includes destdir override: " + outputDir)
 	}
+	outputFile := filepath.Join(outputDir, filepath.Base(incInfo.DestName+"."+incInfo.DestExt))
+	if kr8Spec.OutputLayout != "" {
+		outputPath, err := IncludeOutputPath(kr8Spec, config, componentName, incInfo)
+		if err != nil {
+			return err
+		}
+		outputFile = filepath.Join(kr8Spec.ClusterOutputDir, filepath.FromSlash(outputPath))
+		outputDir = filepath.Dir(outputFile)
+	}
 	if _, err := os.Stat(outputDir); os.IsNotExist(err) {
 		err = os.MkdirAll(outputDir, 0750)
 		if err := util.ErrorIfCheck("error creating alternate directory", err); err != nil {
@@ -47,7 +56,6 @@ func processIncludesFile(
 		}
 	}
 	inputFile := filepath.Join(kr8Opts.BaseDir, componentPath, incInfo.File)
-	outputFile := filepath.Join(outputDir, filepath.Base(incInfo.DestName+"."+incInfo.DestExt))
 	// remember output filename for purging files
 	outputFileMap[filepath.Base(incInfo.DestName+"."+incInfo.DestExt)] = true
 
@@ -90,10 +98,15 @@ func ProcessFile(
 		Msg("Process file: " + inputFile + " -> " + outputFile)
 
 	file_extension := filepath.Ext(incInfo.File)
+	// Make the include metadata available to postprocessors
+	incInfoJSON, err := json.Marshal(incInfo)
+	if err != nil {
+		return "", err
+	}
+	jvm.ExtCode("kr8_include", string(incInfoJSON))
 
 	var input string
 	var outStr string
-	var err error
 	switch file_extension {
 	case ".jsonnet":
 		// file is processed as an ExtCode input, so that we can postprocess it
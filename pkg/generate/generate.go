@@ -13,9 +13,12 @@
 package generate
 
 import (
+	"crypto/ed25519"
+	"encoding/json"
 	"os"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
@@ -30,7 +33,9 @@ import (
 	"golang.org/x/exp/maps"
 
 	jnetvm "github.com/ice-bergtech/kr8/pkg/jnetvm"
+	"github.com/ice-bergtech/kr8/pkg/kr8_bundle"
 	"github.com/ice-bergtech/kr8/pkg/kr8_cache"
+	"github.com/ice-bergtech/kr8/pkg/kr8_sign"
 	"github.com/ice-bergtech/kr8/pkg/kr8_types"
 	types "github.com/ice-bergtech/kr8/pkg/types"
 	util "github.com/ice-bergtech/kr8/pkg/util"
@@ -45,7 +50,7 @@ type SafeString struct {
 }
 
 // Given a base directory, generates cluster-level configuration for each cluster found.
-// Gets list of clusters from `util.GetClusterFilenames(clusterDir)`.
+// Gets list of clusters from `util.GetClusterFilenames(clusterDir)`, including matrix clusters.
 func GetClusterParams(
 	clusterDir string,
 	vmConfig types.VMConfig,
@@ -54,7 +59,7 @@ func GetClusterParams(
 ) (map[string]string, error) {
 	// get list of all clusters, render cluster level params for all of them
 	allClusterParams := make(map[string]string)
-	allClusters, err := util.GetClusterFilenames(clusterDir)
+	allClusters, err := util.GetClusterFilenames(clusterDir, jnetvm.ClusterMatrixLoader(vmConfig))
 	if err := util.LogErrorIfCheck("Error getting list of clusters", err, logger); err != nil {
 		return nil, err
 	}
@@ -102,6 +107,29 @@ func CalculateClusterComponentList(
 	return compList
 }
 
+// Selects the components of a cluster to process.
+// Components are filtered by name with the component filter, then by label with the component selector.
+// The component selector matches labels from the params of every component, so they are rendered if it is set.
+func SelectClusterComponents(
+	clusterName string,
+	clusterComponents map[string]gjson.Result,
+	filters util.PathFilterOptions,
+	vmConfig types.VMConfig,
+	clusterParamsFile string,
+	lint bool,
+) ([]string, error) {
+	compList := CalculateClusterComponentList(clusterComponents, filters)
+	if filters.ComponentSelector == "" {
+		return compList, nil
+	}
+	config, err := jnetvm.JsonnetRenderClusterParams(vmConfig, clusterName, nil, clusterParamsFile, false, lint)
+	if err != nil {
+		return nil, util.ErrorIfCheck("error rendering cluster params", err)
+	}
+
+	return kr8_types.SelectComponents(compList, config, filters.ComponentSelector)
+}
+
 // Root function for processing a kr8+ component.
 // Processes a component through a jsonnet VM to generate output files.
 func GenProcessComponent(
@@ -125,10 +153,11 @@ func GenProcessComponent(
 		return false, nil, err
 	}
 	cacheValid, currentCacheState, err := CheckComponentCache(
-		cache, compSpec, config,
+		cache, compSpec, kr8Spec.ComponentOrder, config,
 		componentName, kr8Opts.BaseDir, logger,
 	)
-	if kr8Spec.EnableCache && !compSpec.DisableCache {
+	// Components using the output of other components are always generated
+	if kr8Spec.EnableCache && !compSpec.DisableCache && !compSpec.Kr8_outputs {
 		if err != nil {
 			logger.Error().Err(err).Msg("issue checking/creating component cache")
 		}
@@ -153,8 +182,8 @@ func GenProcessComponent(
 	}
 
 	componentOutputDir := filepath.Join(kr8Spec.GenerateDir, kr8Spec.Name, componentName)
-	// create component dir if needed
-	if _, err := os.Stat(componentOutputDir); os.IsNotExist(err) {
+	// create component dir if needed, files placed with an output layout create their own directories
+	if _, err := os.Stat(componentOutputDir); os.IsNotExist(err) && kr8Spec.OutputLayout == "" {
 		err := os.MkdirAll(componentOutputDir, 0750)
 		if err := util.LogErrorIfCheck("Error creating component directory", err, logger); err != nil {
 			return false, nil, err
@@ -170,6 +199,11 @@ func GenProcessComponent(
 		return false, nil, err
 	}
 
+	// Files placed with an output layout are cleaned up when the cluster's output is planned
+	if kr8Spec.OutputLayout != "" {
+		return true, currentCacheState, nil
+	}
+
 	return true, currentCacheState, ProcessComponentFinalizer(compSpec, componentOutputDir, outputFileMap)
 }
 
@@ -192,11 +226,13 @@ func ProcessComponentFinalizer(
 }
 
 // Compares a component's current state to a cache entry.
+// The resolved component order is part of the state, as components can read it and be annotated with their wave.
 // Returns an up-to-date cache entry for the component.
 // If the cache pointer is nil or cache invalid, a fresh cache entry will be generated to return.
 func CheckComponentCache(
 	cache *kr8_cache.DeploymentCache,
 	compSpec kr8_types.Kr8ComponentSpec,
+	componentOrder *kr8_types.Kr8ComponentOrder,
 	config string,
 	componentName string,
 	baseDir string,
@@ -210,6 +246,10 @@ func CheckComponentCache(
 
 		return false, nil, err
 	}
+	config, err = componentCacheConfig(config, componentName, compSpec, componentOrder)
+	if err != nil {
+		return false, nil, err
+	}
 	// check if the component matches the cache
 	if cache != nil {
 		return cache.CheckClusterComponentCache(
@@ -229,6 +269,47 @@ func CheckComponentCache(
 	return false, newCache, nil
 }
 
+// Returns the params a component's cache entry depends on.
+// Components only see their own params and `_components`, unless they include all params,
+// so other components, such as other instances of the same component, don't invalidate their cache entry.
+// Cluster-level params are checked by the cluster cache.
+// The component order is added as `_kr8_component_order`,
+// as it is derived from the `depends_on` of every component.
+func componentCacheConfig(
+	config string,
+	componentName string,
+	compSpec kr8_types.Kr8ComponentSpec,
+	componentOrder *kr8_types.Kr8ComponentOrder,
+) (string, error) {
+	fields := []string{}
+	addField := func(key string, raw string) {
+		name, _ := json.Marshal(key)
+		fields = append(fields, string(name)+":"+raw)
+	}
+	if compSpec.Kr8_allParams {
+		gjson.Parse(config).ForEach(func(key, value gjson.Result) bool {
+			addField(key.String(), value.Raw)
+
+			return true
+		})
+	} else {
+		for _, key := range []string{"_kr8_spec", "_cluster", "_components", componentName} {
+			if value := gjson.Get(config, gjson.Escape(key)); value.Exists() {
+				addField(key, value.Raw)
+			}
+		}
+	}
+	if componentOrder != nil {
+		order, err := json.Marshal(componentOrder)
+		if err != nil {
+			return "", util.ErrorIfCheck("error encoding component order", err)
+		}
+		addField("_kr8_component_order", string(order))
+	}
+
+	return "{" + strings.Join(fields, ",") + "}", nil
+}
+
 func GetComponentFiles(compSpec kr8_types.Kr8ComponentSpec) []string {
 	numIncludes := len(compSpec.Includes)
 	numExtFiles := len(compSpec.ExtFiles)
@@ -271,7 +352,7 @@ func SetupComponentVM(
 	logger zerolog.Logger,
 ) (*jsonnet.VM, string, error) {
 	// Initialize a default jsonnet VM for components to build on top of
-	jvm, err := SetupBaseComponentJvm(vmConfig, config, kr8Spec)
+	jvm, err := SetupBaseComponentJvm(vmConfig, config, kr8Spec, componentName, compSpec)
 	if err != nil {
 		kErr := types.Kr8Error{Message: "error initializing component jsonnet VM", Value: err}
 
@@ -304,6 +385,13 @@ func SetupComponentVM(
 		}
 	}
 
+	// check if the rendered output of dependencies should be included
+	if compSpec.Kr8_outputs {
+		if err := loadComponentOutputsIntoVM(kr8Spec, compSpec, jvm, logger); err != nil {
+			return nil, "", util.LogErrorIfCheck("error loading component outputs", err, logger)
+		}
+	}
+
 	// Load files referenced by the component
 	compPath := GetComponentPath(config, componentName)
 	// jPathResults always includes base lib. Add jPaths from spec if set
@@ -357,7 +445,7 @@ func GetClusterComponentParamsThreadSafe(
 ) error {
 	allConfig.mu.Lock()
 	if allConfig.config == "" {
-		if filters.Components == "" {
+		if filters.Components == "" && filters.ComponentSelector == "" {
 			allConfig.config = config
 		} else {
 			var err error
@@ -398,18 +486,7 @@ func GenerateIncludesFiles(
 ) (map[string]bool, error) {
 	outputFileMap := make(map[string]bool)
 	for _, include := range includesFiles {
-		if include.DestName == "" {
-			if kr8Spec.GenerateShortNames {
-				sBase := filepath.Base(include.File)
-				include.DestName = sBase[0 : len(sBase)-len(filepath.Ext(include.File))]
-			} else {
-				// replaces slashes with _ in multi-dir paths and replace extension with yaml
-				include.DestName = strings.ReplaceAll(
-					include.File[0:len(include.File)-len(filepath.Ext(include.File))],
-					"/", "_",
-				)
-			}
-		}
+		include = includeDestination(kr8Spec, include)
 		err := processIncludesFile(
 			jvm, config,
 			kr8Spec, kr8Opts,
@@ -436,6 +513,15 @@ type GenerateProcessRootConfig struct {
 	VmConfig          types.VMConfig
 	Noop              bool
 	Lint              bool
+	// If set, a signed digest manifest of the cluster output is written with this key
+	SignKey ed25519.PrivateKey
+	// If set, the cluster output is packaged into a bundle
+	Bundle *kr8_bundle.Options
+	// Version of kr8+, recorded in bundle metadata
+	Kr8Version string
+	// If set, ArgoCD Applications configured to be written outside the cluster output directory
+	// are written to a subdirectory of this directory named after the cluster instead
+	ArgoCDOutputDir string
 }
 
 // The root function for generating a cluster.
@@ -464,6 +550,12 @@ func GenProcessCluster(
 	if err != nil {
 		return err
 	}
+	if clusterConfig.ArgoCDOutputDir != "" && kr8Spec.ArgoCD != nil {
+		relDir, err := filepath.Rel(kr8Spec.ClusterOutputDir, kr8Spec.ArgoCD.OutputDir)
+		if err != nil || !filepath.IsLocal(relDir) {
+			kr8Spec.ArgoCD.OutputDir = filepath.Join(clusterConfig.ArgoCDOutputDir, kr8Spec.Name)
+		}
+	}
 
 	var cacheCur *kr8_cache.DeploymentCache
 	cacheFile := ""
@@ -491,13 +583,73 @@ func GenProcessCluster(
 		return err
 	}
 
+	// Record the files generated with the output layout.
+	if kr8Spec.OutputLayout != "" {
+		index := OutputIndex{Layout: kr8Spec.OutputLayout, Files: kr8Spec.OutputFiles}
+		if err := index.Write(kr8Spec.ClusterOutputDir); err != nil {
+			return util.LogErrorIfCheck("error writing output index", err, logger)
+		}
+	}
+
+	// Add config hash annotations once all components are rendered.
+	// Hashes are updated for every component of the cluster, so workloads of components
+	// that weren't generated pick up config changes of the ones that were.
+	hashComponents, hashConfig := compList, config
+	if kr8Spec.ComponentOrder != nil && len(compList) < len(kr8Spec.ComponentOrder.Order) {
+		hashComponents = kr8Spec.ComponentOrder.Order
+		hashConfig, err = jnetvm.JsonnetRenderClusterParams(
+			clusterConfig.VmConfig, kr8Spec.Name, nil, clusterConfig.ClusterParamsFile, false, clusterConfig.Lint,
+		)
+		if err := util.LogErrorIfCheck("error rendering cluster params", err, logger); err != nil {
+			return err
+		}
+	}
+	if err := ApplyConfigHashes(*kr8Spec, hashComponents, hashConfig, logger); err != nil {
+		return err
+	}
+
+	// Write ArgoCD Applications for the generated components.
+	if err := EmitArgoCDApplications(*kr8Spec, compList, config, logger); err != nil {
+		return err
+	}
+
+	// Check the generated output before it is cached or signed.
+	if err := RunClusterChecks(*kr8Spec, compList, config, clusterConfig.VmConfig, logger); err != nil {
+		return err
+	}
+
 	// If caching is enabled, generate the cache file for the cluster.
 	if kr8Spec.EnableCache {
-		return kr8_cache.InitDeploymentCache(
+		err := kr8_cache.InitDeploymentCache(
 			config,
 			clusterConfig.BaseDir,
 			componentCacheResult,
 		).WriteCache(cacheFile, kr8Spec.CompressCache)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Sign the cluster output once all files are written.
+	if clusterConfig.SignKey != nil {
+		err := kr8_sign.SignClusterOutput(kr8Spec.Name, kr8Spec.ClusterOutputDir, clusterConfig.SignKey)
+		if err := util.LogErrorIfCheck("error signing cluster output", err, logger); err != nil {
+			return err
+		}
+		logger.Info().Str("file", kr8_sign.ManifestFile).Msg("Signed cluster output")
+	}
+
+	// Package the cluster output once it is complete.
+	if clusterConfig.Bundle != nil {
+		metadata, err := BuildBundleMetadata(*kr8Spec, compList, config, clusterConfig.BaseDir, clusterConfig.Kr8Version)
+		if err != nil {
+			return err
+		}
+		bundlePath, err := kr8_bundle.WriteBundle(*clusterConfig.Bundle, kr8Spec.ClusterOutputDir, metadata)
+		if err := util.LogErrorIfCheck("error bundling cluster output", err, logger); err != nil {
+			return err
+		}
+		logger.Info().Str("bundle", bundlePath).Msg("Bundled cluster output")
 	}
 
 	return nil
@@ -532,7 +684,10 @@ func GatherClusterConfig(
 	}
 
 	// Determine list of components to process
-	compList := CalculateClusterComponentList(clusterComponents, filters)
+	compList, err := SelectClusterComponents(kr8Spec.Name, clusterComponents, filters, vmConfig, clusterParamsFile, lint)
+	if err := util.LogErrorIfCheck("error selecting components", err, logger); err != nil {
+		return nil, nil, "", err
+	}
 
 	if noop {
 		return kr8Spec, compList, "", nil
@@ -544,7 +699,18 @@ func GatherClusterConfig(
 		return nil, nil, "", err
 	}
 
-	CleanupOldComponentDirs(existingComponents, clusterComponents, kr8Spec, logger)
+	// Remove the output of a previous layout if the output layout changed
+	index, err := LoadOutputIndex(kr8Spec.ClusterOutputDir)
+	if err := util.LogErrorIfCheck("error loading output index", err, logger); err != nil {
+		return nil, nil, "", err
+	}
+	index, err = ResetOutputLayout(kr8Spec, index, existingComponents, len(compList) < len(clusterComponents), logger)
+	if err := util.LogErrorIfCheck("error resetting output layout", err, logger); err != nil {
+		return nil, nil, "", err
+	}
+	if kr8Spec.OutputLayout == "" {
+		CleanupOldComponentDirs(existingComponents, clusterComponents, kr8Spec, logger)
+	}
 
 	// Use Jsonnet to render cluster-level configurations for components
 	config, err := jnetvm.JsonnetRenderClusterParams(
@@ -559,6 +725,29 @@ func GatherClusterConfig(
 		return nil, nil, "", err
 	}
 
+	// Resolve the order of all cluster components, not only the ones being generated
+	orderConfig := config
+	if len(compList) < len(clusterComponents) {
+		orderConfig, err = jnetvm.JsonnetRenderClusterParams(
+			vmConfig, kr8Spec.Name, nil, clusterParamsFile, false, lint,
+		)
+		if err := util.LogErrorIfCheck("error rendering cluster params", err, logger); err != nil {
+			return nil, nil, "", err
+		}
+	}
+	kr8Spec.ComponentOrder, err = kr8_types.ResolveComponentOrder(orderConfig)
+	if err := util.LogErrorIfCheck("error resolving component dependencies", err, logger); err != nil {
+		return nil, nil, "", err
+	}
+
+	// Plan where each file is generated, and remove files that are no longer generated
+	if kr8Spec.OutputLayout != "" {
+		kr8Spec.OutputFiles, err = PlanOutputFiles(*kr8Spec, index, clusterComponents, compList, config, logger)
+		if err := util.LogErrorIfCheck("error planning output layout", err, logger); err != nil {
+			return nil, nil, "", err
+		}
+	}
+
 	return kr8Spec, compList, config, nil
 }
 
@@ -586,7 +775,7 @@ func LoadClusterCache(
 }
 
 // Go through each item in existingComponents and remove the file if it isn't in clusterComponents.
-// Skips removing `.kr8_cache` files.
+// Skips removing kr8+ bookkeeping files such as `.kr8_cache` and the signed manifest.
 func CleanupOldComponentDirs(
 	existingComponents []string,
 	clusterComponents map[string]gjson.Result,
@@ -595,8 +784,12 @@ func CleanupOldComponentDirs(
 ) {
 	for _, component := range existingComponents {
 		if _, found := clusterComponents[component]; !found {
-			// Skip deleting cache files
-			if component == ".kr8_cache" {
+			// Skip deleting cache and manifest files
+			if kr8_sign.IsBookkeepingFile(component) {
+				continue
+			}
+			// Skip deleting generated ArgoCD Applications
+			if kr8Spec.ArgoCD != nil && filepath.Join(kr8Spec.ClusterOutputDir, component) == kr8Spec.ArgoCD.OutputDir {
 				continue
 			}
 			delComp := filepath.Join(kr8Spec.ClusterOutputDir, component)
@@ -618,13 +811,11 @@ func CompileClusterConfiguration(
 	lint bool,
 	logger zerolog.Logger,
 ) (*kr8_types.Kr8ClusterSpec, map[string]gjson.Result, error) {
-	// First determine the path to the cluster.jsonnet file.
-	clusterPath, err := util.GetClusterPath(clusterDir, clusterName)
+	// Gather list of configurations that apply to the cluster, including its profiles
+	params, err := jnetvm.ClusterParamsFilenames(vmConfig, clusterDir, clusterName)
 	if err != nil {
 		return nil, nil, err
 	}
-	// Gather list of configurations that apply to the cluster
-	params := util.GetClusterParamsFilenames(clusterDir, clusterPath)
 
 	// Compile the cluster kr8+ configuration
 	renderedKr8Spec, err := jnetvm.JsonnetRenderFiles(vmConfig, params, "._kr8_spec", false, "", "kr8_spec", lint)
@@ -643,18 +834,17 @@ func CompileClusterConfiguration(
 		return nil, nil, err
 	}
 
-	// Compile the cluster component references
-	renderedCompSpec, err := jnetvm.JsonnetRenderFiles(
-		vmConfig, params,
-		"._components", true,
-		"", clusterName+": ._components",
-		lint,
-	)
+	// Compile the cluster component references, with component instances expanded
+	componentMap, err := jnetvm.RenderClusterComponents(vmConfig, params, clusterName+": ._components", lint)
 	if err := util.LogErrorIfCheck("error rendering cluster components list", err, logger); err != nil {
 		return nil, nil, err
 	}
+	renderedCompSpec, err := json.Marshal(componentMap)
+	if err := util.LogErrorIfCheck("error encoding cluster components list", err, logger); err != nil {
+		return nil, nil, err
+	}
 	// Package into a map
-	clusterComponents := gjson.Parse(renderedCompSpec).Map()
+	clusterComponents := gjson.ParseBytes(renderedCompSpec).Map()
 
 	return &kr8Spec, clusterComponents, nil
 }
@@ -683,34 +873,37 @@ func RenderComponents(
 	var allConfig SafeString
 	var waitGroup sync.WaitGroup
 
-	for _, componentName := range compList {
-		waitGroup.Add(1)
-		cName := componentName
-		_ = pool.Submit(func() {
-			defer waitGroup.Done()
-			// Create a new logger for the component to use
-			subLogger := logger.With().Str("component", componentName).Logger()
-			success, cacheResult, err := GenProcessComponent(
-				vmConfig, cName,
-				kr8Spec, kr8Opts,
-				config, &allConfig,
-				filters, clusterParamsFile,
-				cacheObj, lint, subLogger,
-			)
-			if err != nil {
-				subLogger.Error().
-					Err(err).
-					Msg("Failed to process component")
-			}
-			// Record cache results if component generate was successful.
-			if success && cacheResult != nil {
-				cacheResultChannel <- map[string]kr8_cache.ComponentCache{
-					componentName: *cacheResult,
+	// Components are rendered in dependency waves, so dependencies are rendered first
+	for _, wave := range GroupComponentsByWave(compList, kr8Spec.ComponentOrder) {
+		for _, componentName := range wave {
+			waitGroup.Add(1)
+			cName := componentName
+			_ = pool.Submit(func() {
+				defer waitGroup.Done()
+				// Create a new logger for the component to use
+				subLogger := logger.With().Str("component", componentName).Logger()
+				success, cacheResult, err := GenProcessComponent(
+					vmConfig, cName,
+					kr8Spec, kr8Opts,
+					config, &allConfig,
+					filters, clusterParamsFile,
+					cacheObj, lint, subLogger,
+				)
+				if err != nil {
+					subLogger.Error().
+						Err(err).
+						Msg("Failed to process component")
 				}
-			}
-		})
+				// Record cache results if component generate was successful.
+				if success && cacheResult != nil {
+					cacheResultChannel <- map[string]kr8_cache.ComponentCache{
+						componentName: *cacheResult,
+					}
+				}
+			})
+		}
+		waitGroup.Wait()
 	}
-	waitGroup.Wait()
 	close(cacheResultChannel)
 
 	// Pre-allocate our cache object and fill it from the channel.
@@ -724,6 +917,25 @@ func RenderComponents(
 	return result, nil
 }
 
+// Groups a list of components by their dependency wave.
+// Waves are returned in order, each keeping the order of compList.
+// If order is nil, all components are returned in a single wave.
+func GroupComponentsByWave(compList []string, order *kr8_types.Kr8ComponentOrder) [][]string {
+	if order == nil {
+		return [][]string{compList}
+	}
+	waves := [][]string{}
+	for _, componentName := range compList {
+		wave := order.Waves[componentName]
+		for len(waves) <= wave {
+			waves = append(waves, []string{})
+		}
+		waves[wave] = append(waves[wave], componentName)
+	}
+
+	return slices.DeleteFunc(waves, func(wave []string) bool { return len(wave) == 0 })
+}
+
 // For provided config, validates the cache object matches.
 // If the cache is valid, it is returned.
 // If cache is not valid, an empty deployment cache returned.
@@ -1,12 +1,19 @@
 package generate_test
 
 import (
+	"encoding/json"
+	"os"
+	"path/filepath"
 	"reflect"
+	"sort"
+	"strconv"
 	"testing"
 
 	jsonnet "github.com/google/go-jsonnet"
 	"github.com/ice-bergtech/kr8/pkg/generate"
 	"github.com/ice-bergtech/kr8/pkg/kr8_cache"
+	"github.com/ice-bergtech/kr8/pkg/kr8_check"
+	"github.com/ice-bergtech/kr8/pkg/kr8_diff"
 	"github.com/ice-bergtech/kr8/pkg/kr8_types"
 	types "github.com/ice-bergtech/kr8/pkg/types"
 	util "github.com/ice-bergtech/kr8/pkg/util"
@@ -162,45 +169,94 @@ func TestProcessComponentFinalizer(t *testing.T) {
 }
 
 func TestCheckComponentCache(t *testing.T) {
+	baseDir := t.TempDir()
+	for _, component := range []string{"web", "db"} {
+		dir := filepath.Join(baseDir, "components", component)
+		if err := os.MkdirAll(dir, 0750); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, component+".jsonnet"), []byte("{}"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	config := func(webReplicas int, dbReplicas int) string {
+		return `{"_kr8_spec": {"generate_dir": "generated"}, "_cluster": {"cluster_name": "dev"},` +
+			`"_components": {"web": {"path": "components/web"}, "db": {"path": "components/db"}},` +
+			`"web": {"replicas": ` + strconv.Itoa(webReplicas) + `}, "db": {"replicas": ` + strconv.Itoa(dbReplicas) + `}}`
+	}
+	order := &kr8_types.Kr8ComponentOrder{
+		Order:     []string{"db", "web"},
+		Waves:     map[string]int{"db": 0, "web": 1},
+		DependsOn: map[string][]string{"web": {"db"}},
+	}
+	reordered := &kr8_types.Kr8ComponentOrder{
+		Order:     []string{"db", "web"},
+		Waves:     map[string]int{"db": 0, "web": 0},
+		DependsOn: map[string][]string{},
+	}
+
 	tests := []struct {
-		name string // description of this test case
-		// Named input parameters for target function.
-		cache         *kr8_cache.DeploymentCache
-		compSpec      kr8_types.Kr8ComponentSpec
-		config        string
-		componentName string
-		baseDir       string
-		logger        zerolog.Logger
-		want          bool
-		want2         *kr8_cache.ComponentCache
-		wantErr       bool
+		name     string
+		compSpec kr8_types.Kr8ComponentSpec
+		order    *kr8_types.Kr8ComponentOrder
+		config   string
+		want     bool
 	}{
-		// TODO: Add test cases.
+		{
+			name:     "unchanged",
+			compSpec: kr8_types.Kr8ComponentSpec{},
+			order:    order,
+			config:   config(1, 1),
+			want:     true,
+		},
+		{
+			name:     "params of another component changed",
+			compSpec: kr8_types.Kr8ComponentSpec{},
+			order:    order,
+			config:   config(1, 2),
+			want:     true,
+		},
+		{
+			name:     "own params changed",
+			compSpec: kr8_types.Kr8ComponentSpec{},
+			order:    order,
+			config:   config(2, 1),
+			want:     false,
+		},
+		{
+			name:     "component order changed",
+			compSpec: kr8_types.Kr8ComponentSpec{},
+			order:    reordered,
+			config:   config(1, 1),
+			want:     false,
+		},
+		{
+			name:     "params of another component changed with all params",
+			compSpec: kr8_types.Kr8ComponentSpec{Kr8_allParams: true},
+			order:    order,
+			config:   config(1, 2),
+			want:     false,
+		},
 	}
 	for _, testCase := range tests {
 		t.Run(testCase.name, func(t *testing.T) {
-			got, got2, gotErr := generate.CheckComponentCache(
-				testCase.cache,
-				testCase.compSpec,
-				testCase.config,
-				testCase.componentName,
-				testCase.baseDir,
-				testCase.logger,
+			_, entry, err := generate.CheckComponentCache(
+				nil, testCase.compSpec, order, config(1, 1), "web", baseDir, zerolog.Nop(),
 			)
-			// TODO: update the condition below to compare got with tt.want.
-			if gotErr != nil {
-				if !testCase.wantErr {
-					t.Errorf("ProcessComponentFinalizer() failed: %v", gotErr)
-				}
+			if err != nil {
+				t.Fatalf("CheckComponentCache() error = %v", err)
+			}
+			cache := kr8_cache.InitDeploymentCache(config(1, 1), baseDir, map[string]kr8_cache.ComponentCache{"web": *entry})
 
-				return
+			got, _, err := generate.CheckComponentCache(
+				cache, testCase.compSpec, testCase.order, testCase.config, "web", baseDir, zerolog.Nop(),
+			)
+			if err != nil {
+				t.Fatalf("CheckComponentCache() error = %v", err)
 			}
 			if got != testCase.want {
 				t.Errorf("CheckComponentCache() = %v, want %v", got, testCase.want)
 			}
-			if !reflect.DeepEqual(got2, testCase.want2) {
-				t.Errorf("CheckComponentCache() = %v, want %v", got2, testCase.want2)
-			}
 		})
 	}
 }
@@ -432,6 +488,89 @@ func TestGenProcessCluster(t *testing.T) {
 	}
 }
 
+func TestGenProcessClusterConfigHashes(t *testing.T) {
+	baseDir := t.TempDir()
+	writeFiles := func(files map[string]string) {
+		t.Helper()
+		for name, content := range files {
+			path := filepath.Join(baseDir, name)
+			if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	writeFiles(map[string]string{
+		"clusters/dev/cluster.jsonnet": `{
+  _kr8_spec: { generate_dir: 'generated', config_hash: 'cluster', cache_enable: true },
+  _components: { app: { path: 'components/app' }, settings: { path: 'components/settings' } },
+}`,
+		"components/app/params.jsonnet": `{ namespace: 'web', kr8_spec: { includes: ['app.jsonnet'] } }`,
+		"components/app/app.jsonnet": `[{
+  apiVersion: 'apps/v1', kind: 'Deployment', metadata: { name: 'app' },
+  spec: { template: { spec: { containers: [{ name: 'app', envFrom: [{ configMapRef: { name: 'settings' } }] }] } } },
+}]`,
+		"components/settings/params.jsonnet": `{ namespace: 'web', kr8_spec: { includes: ['settings.jsonnet'] } }`,
+		"components/settings/settings.jsonnet": `[{
+  apiVersion: 'v1', kind: 'ConfigMap', metadata: { name: 'settings' }, data: { level: 'info' },
+}]`,
+	})
+	pool, err := ants.NewPool(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Release()
+	generateCluster := func(components string) {
+		t.Helper()
+		//nolint:exhaustruct
+		err := generate.GenProcessCluster(&generate.GenerateProcessRootConfig{
+			ClusterName: "dev",
+			ClusterDir:  filepath.Join(baseDir, "clusters"),
+			BaseDir:     baseDir,
+			Kr8Opts: types.Kr8Opts{
+				BaseDir:      baseDir,
+				ComponentDir: filepath.Join(baseDir, "components"),
+				ClusterDir:   filepath.Join(baseDir, "clusters"),
+			},
+			//nolint:exhaustruct
+			Filters: util.PathFilterOptions{Components: components},
+			//nolint:exhaustruct
+			VmConfig: types.VMConfig{BaseDir: baseDir},
+		}, pool, zerolog.Nop())
+		if err != nil {
+			t.Fatalf("GenProcessCluster() error = %v", err)
+		}
+	}
+	appChecksum := func() string {
+		t.Helper()
+		objects, _, err := kr8_check.LoadComponentObjects(filepath.Join(baseDir, "generated", "dev"), "app")
+		if err != nil || len(objects) != 1 {
+			t.Fatalf("LoadComponentObjects() = %v, %v", objects, err)
+		}
+
+		return gjson.Get(mustJSON(t, objects[0].Data), `spec.template.metadata.annotations.checksum/settings`).String()
+	}
+
+	generateCluster("")
+	before := appChecksum()
+	if before == "" {
+		t.Fatal("app has no checksum/settings annotation")
+	}
+
+	// Only the ConfigMap's component is generated, the workload still rolls
+	writeFiles(map[string]string{
+		"components/settings/settings.jsonnet": `[{
+  apiVersion: 'v1', kind: 'ConfigMap', metadata: { name: 'settings' }, data: { level: 'debug' },
+}]`,
+	})
+	generateCluster("settings")
+	if after := appChecksum(); after == before || after == "" {
+		t.Errorf("checksum/settings = %q after the ConfigMap changed, was %q", after, before)
+	}
+}
+
 func TestGatherClusterConfig(t *testing.T) {
 	tests := []struct {
 		name string // description of this test case
@@ -637,3 +776,1164 @@ func TestRenderComponents(t *testing.T) {
 		})
 	}
 }
+
+func TestBuildPostProcessor(t *testing.T) {
+	enabled := true
+	disabled := false
+	input := `[
+		{apiVersion: 'apps/v1', kind: 'Deployment', metadata: {name: 'web', labels: {team: 'web'}}},
+		{apiVersion: 'v1', kind: 'Service', metadata: {name: 'web', namespace: 'other'}},
+		{apiVersion: 'rbac.authorization.k8s.io/v1', kind: 'ClusterRole', metadata: {name: 'web'}},
+		{apiVersion: 'example.com/v1', kind: 'Widget', metadata: {name: 'w'}},
+		{apiVersion: 'v1', kind: 'List', items: [{apiVersion: 'v1', kind: 'ConfigMap', metadata: {name: 'cm'}}]},
+	]`
+	componentDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(componentDir, "process.libsonnet"),
+		[]byte("{ rename(input, ctx): [o { metadata+: { name: ctx.component + '-' + super.name } } for o in input] }"),
+		0600,
+	); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(componentDir, `team's\process.libsonnet`),
+		[]byte(`{ "it's"(input):: [input[3]] }`),
+		0600,
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		kr8Spec   kr8_types.Kr8ClusterSpec
+		compSpec  kr8_types.Kr8ComponentSpec
+		namespace string
+		want      string
+	}{
+		{
+			name:      "no injection",
+			kr8Spec:   kr8_types.Kr8ClusterSpec{PostProcessor: "function(input) [input[0]]"},
+			compSpec:  kr8_types.Kr8ComponentSpec{},
+			namespace: "app",
+			want:      `[{"apiVersion":"apps/v1","kind":"Deployment","metadata":{"labels":{"team":"web"},"name":"web"}}]`,
+		},
+		{
+			name: "labels merged from cluster and component",
+			kr8Spec: kr8_types.Kr8ClusterSpec{
+				PostProcessor: "function(input) [input[0]]",
+				CommonLabels:  map[string]string{"managed-by": "kr8", "team": "platform", "tier": "base"},
+			},
+			compSpec:  kr8_types.Kr8ComponentSpec{CommonLabels: map[string]string{"tier": "app"}},
+			namespace: "app",
+			want: `[{"apiVersion":"apps/v1","kind":"Deployment","metadata":` +
+				`{"labels":{"managed-by":"kr8","team":"web","tier":"app"},"name":"web"}}]`,
+		},
+		{
+			name: "namespace injected into namespaced objects",
+			kr8Spec: kr8_types.Kr8ClusterSpec{
+				InjectNamespace:    true,
+				CommonAnnotations:  map[string]string{"owner": "platform"},
+				ClusterScopedKinds: []string{"Widget"},
+			},
+			compSpec:  kr8_types.Kr8ComponentSpec{},
+			namespace: "app",
+			want: `[` +
+				`{"apiVersion":"apps/v1","kind":"Deployment","metadata":{"annotations":{"owner":"platform"},` +
+				`"labels":{"team":"web"},"name":"web","namespace":"app"}},` +
+				`{"apiVersion":"v1","kind":"Service","metadata":{"annotations":{"owner":"platform"},"name":"web","namespace":"other"}},` +
+				`{"apiVersion":"rbac.authorization.k8s.io/v1","kind":"ClusterRole","metadata":{"annotations":{"owner":"platform"},"name":"web"}},` +
+				`{"apiVersion":"example.com/v1","kind":"Widget","metadata":{"annotations":{"owner":"platform"},"name":"w"}},` +
+				`{"apiVersion":"v1","items":[{"apiVersion":"v1","kind":"ConfigMap","metadata":` +
+				`{"annotations":{"owner":"platform"},"name":"cm","namespace":"app"}}],"kind":"List"}]`,
+		},
+		{
+			name:      "component enables namespace injection",
+			kr8Spec:   kr8_types.Kr8ClusterSpec{PostProcessor: "function(input) [input[3]]"},
+			compSpec:  kr8_types.Kr8ComponentSpec{InjectNamespace: &enabled},
+			namespace: "app",
+			want:      `[{"apiVersion":"example.com/v1","kind":"Widget","metadata":{"name":"w","namespace":"app"}}]`,
+		},
+		{
+			name:      "component disables namespace injection",
+			kr8Spec:   kr8_types.Kr8ClusterSpec{PostProcessor: "function(input) [input[3]]", InjectNamespace: true},
+			compSpec:  kr8_types.Kr8ComponentSpec{InjectNamespace: &disabled},
+			namespace: "app",
+			want:      `[{"apiVersion":"example.com/v1","kind":"Widget","metadata":{"name":"w"}}]`,
+		},
+		{
+			name:    "component postprocessors chained after cluster postprocessor",
+			kr8Spec: kr8_types.Kr8ClusterSpec{Name: "dev", PostProcessor: "function(input) input[2:4]"},
+			compSpec: kr8_types.Kr8ComponentSpec{
+				CommonLabels: map[string]string{"cluster": "dev"},
+				PostProcessors: []kr8_types.Kr8PostProcessorSpec{
+					{Code: "function(input) [input[1]]"},
+					{File: "process.libsonnet", Function: "rename"},
+					{Code: "function(input, ctx) [o { metadata+: { annotations: { file: ctx.include.file } } } for o in input]"},
+				},
+			},
+			namespace: "app",
+			want: `[{"apiVersion":"example.com/v1","kind":"Widget","metadata":` +
+				`{"annotations":{"file":"widget.jsonnet"},"labels":{"cluster":"dev"},"name":"web-w"}}]`,
+		},
+		{
+			name:    "quotes in postprocessor file and function names",
+			kr8Spec: kr8_types.Kr8ClusterSpec{},
+			compSpec: kr8_types.Kr8ComponentSpec{
+				PostProcessors: []kr8_types.Kr8PostProcessorSpec{{File: `team's\process.libsonnet`, Function: "it's"}},
+			},
+			namespace: "app",
+			want:      `[{"apiVersion":"example.com/v1","kind":"Widget","metadata":{"name":"w"}}]`,
+		},
+		{
+			name: "sync wave annotation",
+			kr8Spec: kr8_types.Kr8ClusterSpec{
+				PostProcessor: "function(input) [input[3]]",
+				SyncWaves:     true,
+				ComponentOrder: &kr8_types.Kr8ComponentOrder{
+					Order:     []string{"db", "web"},
+					Waves:     map[string]int{"db": 0, "web": 1},
+					DependsOn: map[string][]string{"web": {"db"}},
+				},
+			},
+			compSpec:  kr8_types.Kr8ComponentSpec{},
+			namespace: "app",
+			want: `[{"apiVersion":"example.com/v1","kind":"Widget","metadata":` +
+				`{"annotations":{"argocd.argoproj.io/sync-wave":"1"},"name":"w"}}]`,
+		},
+	}
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			process, err := generate.BuildPostProcessor(
+				testCase.kr8Spec, testCase.compSpec, "web", testCase.namespace, componentDir)
+			if err != nil {
+				t.Fatalf("BuildPostProcessor() failed: %v", err)
+			}
+			jvm := jsonnet.MakeVM()
+			jvm.ExtCode("process", process)
+			jvm.ExtCode("input", input)
+			jvm.ExtCode("kr8_include", `{file: 'widget.jsonnet'}`)
+			got, err := jvm.EvaluateAnonymousSnippet("test", "std.manifestJsonMinified(std.extVar('process')(std.extVar('input')))")
+			if err != nil {
+				t.Fatalf("evaluating postprocessor failed: %v", err)
+			}
+			var gotStr string
+			if err := json.Unmarshal([]byte(got), &gotStr); err != nil {
+				t.Fatal(err)
+			}
+			if gotStr != testCase.want {
+				t.Errorf("BuildPostProcessor() output = \n%s\n-want-\n%s", gotStr, testCase.want)
+			}
+		})
+	}
+}
+
+func TestApplyConfigHashes(t *testing.T) {
+	configs := `apiVersion: v1
+kind: ConfigMap
+metadata: {name: app-config}
+data: {a: b}
+---
+apiVersion: v1
+kind: Secret
+metadata: {name: app-secret, namespace: app}
+stringData: {password: hunter2}
+`
+	workloads := `apiVersion: apps/v1
+kind: Deployment
+metadata: {name: web}
+spec:
+  template:
+    spec:
+      containers:
+      - name: web
+        envFrom: [{configMapRef: {name: app-config}}]
+        env: [{name: PASSWORD, valueFrom: {secretKeyRef: {name: app-secret, key: password}}}]
+      volumes: [{name: external, configMap: {name: external-config}}]
+---
+apiVersion: batch/v1
+kind: CronJob
+metadata: {name: backup, namespace: other}
+spec:
+  jobTemplate:
+    spec:
+      template:
+        spec:
+          containers: [{name: backup, envFrom: [{configMapRef: {name: app-config}}]}]
+`
+	config := `{"web": {"namespace": "app"}, "config": {"namespace": "app"}}`
+
+	tests := []struct {
+		name       string
+		scope      string
+		layout     map[string]string
+		wantHashes map[string][]string
+	}{
+		{
+			name:       "disabled",
+			scope:      "",
+			layout:     map[string]string{"web/web.yaml": configs + "---\n" + workloads},
+			wantHashes: map[string][]string{"web": nil, "backup": nil},
+		},
+		{
+			name:   "component scope",
+			scope:  kr8_types.ConfigHashComponent,
+			layout: map[string]string{"web/web.yaml": configs + "---\n" + workloads},
+			wantHashes: map[string][]string{
+				"web":    {"checksum/app-config", "checksum/app-secret"},
+				"backup": nil,
+			},
+		},
+		{
+			name:  "component scope ignores other components",
+			scope: kr8_types.ConfigHashComponent,
+			layout: map[string]string{
+				"config/config.yaml": configs,
+				"web/web.yaml":       workloads,
+			},
+			wantHashes: map[string][]string{"web": nil, "backup": nil},
+		},
+		{
+			name:  "cluster scope",
+			scope: kr8_types.ConfigHashCluster,
+			layout: map[string]string{
+				"config/config.yaml": configs,
+				"web/web.yaml":       workloads,
+			},
+			wantHashes: map[string][]string{
+				"web":    {"checksum/app-config", "checksum/app-secret"},
+				"backup": nil,
+			},
+		},
+	}
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			outputDir := t.TempDir()
+			for file, content := range testCase.layout {
+				if err := os.MkdirAll(filepath.Join(outputDir, filepath.Dir(file)), 0750); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(filepath.Join(outputDir, file), []byte(content), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			kr8Spec := kr8_types.Kr8ClusterSpec{ClusterOutputDir: outputDir, ConfigHash: testCase.scope}
+			compList := []string{"config", "web"}
+			if err := generate.ApplyConfigHashes(kr8Spec, compList, config, zerolog.Nop()); err != nil {
+				t.Fatalf("ApplyConfigHashes() failed: %v", err)
+			}
+			objects, _, err := kr8_check.LoadComponentObjects(outputDir, "web")
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, object := range objects {
+				want, ok := testCase.wantHashes[object.Name()]
+				if !ok {
+					continue
+				}
+				annotations := gjson.Get(mustJSON(t, object.Data), "spec.template.metadata.annotations").Map()
+				if object.Kind() == "CronJob" {
+					annotations = gjson.Get(mustJSON(t, object.Data), "spec.jobTemplate.spec.template.metadata.annotations").Map()
+				}
+				got := []string{}
+				for key := range annotations {
+					got = append(got, key)
+				}
+				sort.Strings(got)
+				if len(got) != len(want) || (len(want) > 0 && !reflect.DeepEqual(got, want)) {
+					t.Errorf("ApplyConfigHashes() %s annotations = %v, want %v", object.Name(), got, want)
+				}
+			}
+
+			// Applying again must not change the output
+			before, err := os.ReadFile(filepath.Join(outputDir, "web/web.yaml"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := generate.ApplyConfigHashes(kr8Spec, compList, config, zerolog.Nop()); err != nil {
+				t.Fatalf("ApplyConfigHashes() failed: %v", err)
+			}
+			after, err := os.ReadFile(filepath.Join(outputDir, "web/web.yaml"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(before) != string(after) {
+				t.Errorf("ApplyConfigHashes() is not idempotent:\n%s\n-then-\n%s", before, after)
+			}
+		})
+	}
+}
+
+func mustJSON(t *testing.T, value any) string {
+	t.Helper()
+	data, err := json.Marshal(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(data)
+}
+
+func TestGroupComponentsByWave(t *testing.T) {
+	order := &kr8_types.Kr8ComponentOrder{
+		Order:     []string{"crds", "dns", "cert-manager", "app"},
+		Waves:     map[string]int{"crds": 0, "dns": 0, "cert-manager": 1, "app": 2},
+		DependsOn: map[string][]string{"cert-manager": {"crds"}, "app": {"cert-manager"}},
+	}
+
+	tests := []struct {
+		name     string
+		compList []string
+		order    *kr8_types.Kr8ComponentOrder
+		want     [][]string
+	}{
+		{
+			name:     "no order",
+			compList: []string{"app", "crds"},
+			order:    nil,
+			want:     [][]string{{"app", "crds"}},
+		},
+		{
+			name:     "all components",
+			compList: []string{"app", "cert-manager", "crds", "dns"},
+			order:    order,
+			want:     [][]string{{"crds", "dns"}, {"cert-manager"}, {"app"}},
+		},
+		{
+			name:     "filtered components skip empty waves",
+			compList: []string{"app", "crds"},
+			order:    order,
+			want:     [][]string{{"crds"}, {"app"}},
+		},
+	}
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			if got := generate.GroupComponentsByWave(testCase.compList, testCase.order); !reflect.DeepEqual(got, testCase.want) {
+				t.Errorf("GroupComponentsByWave() = %v, want %v", got, testCase.want)
+			}
+		})
+	}
+}
+
+func TestBuildArgoCDApplication(t *testing.T) {
+	config := `{
+		"web": {"namespace": "frontend", "release_name": "web-release"},
+		"db": {"namespace": "data"}
+	}`
+	order := &kr8_types.Kr8ComponentOrder{
+		Order:     []string{"db", "web"},
+		Waves:     map[string]int{"db": 0, "web": 1},
+		DependsOn: map[string][]string{"web": {"db"}},
+	}
+
+	tests := []struct {
+		name        string
+		spec        string
+		component   string
+		override    string
+		outputFiles map[string][]string
+		want        string
+		wantErr     bool
+	}{
+		{
+			name:        "defaults",
+			spec:        `{"generate_dir": "generated", "argocd": {"repo_url": "https://git.example.com/deploy.git"}}`,
+			component:   "db",
+			override:    `{}`,
+			outputFiles: nil,
+			want: `apiVersion: argoproj.io/v1alpha1
+kind: Application
+metadata:
+  annotations:
+    argocd.argoproj.io/sync-wave: "0"
+  name: dev-db
+  namespace: argocd
+spec:
+  destination:
+    namespace: data
+    server: https://kubernetes.default.svc
+  project: default
+  source:
+    path: generated/dev/db
+    repoURL: https://git.example.com/deploy.git
+    targetRevision: HEAD
+`,
+			wantErr: false,
+		},
+		{
+			name: "component overrides",
+			spec: `{"generate_dir": "generated", "argocd": {
+				"repo_url": "https://git.example.com/deploy.git",
+				"path": "{{ .Cluster }}/{{ .Component }}",
+				"name": "{{ .ReleaseName }}",
+				"sync_policy": {"automated": {"prune": true}}
+			}}`,
+			component:   "web",
+			override:    `{"argocd": {"project": "web", "destination_name": "edge", "target_revision": "main"}}`,
+			outputFiles: nil,
+			want: `apiVersion: argoproj.io/v1alpha1
+kind: Application
+metadata:
+  annotations:
+    argocd.argoproj.io/sync-wave: "1"
+  name: web-release
+  namespace: argocd
+spec:
+  destination:
+    name: edge
+    namespace: frontend
+  project: web
+  source:
+    path: dev/web
+    repoURL: https://git.example.com/deploy.git
+    targetRevision: main
+  syncPolicy:
+    automated:
+      prune: true
+`,
+			wantErr: false,
+		},
+		{
+			name:      "output layout directory",
+			spec:      `{"generate_dir": "generated", "argocd": {"repo_url": "https://git.example.com/deploy.git"}}`,
+			component: "db",
+			override:  `{"argocd": {"name": "{{ .Component }}"}}`,
+			outputFiles: map[string][]string{
+				"db":  {"data/db/statefulset.yaml", "data/db/config/configmap.yaml"},
+				"web": {"frontend/web/deployment.yaml"},
+			},
+			want: `apiVersion: argoproj.io/v1alpha1
+kind: Application
+metadata:
+  annotations:
+    argocd.argoproj.io/sync-wave: "0"
+  name: db
+  namespace: argocd
+spec:
+  destination:
+    namespace: data
+    server: https://kubernetes.default.svc
+  project: default
+  source:
+    path: generated/dev/data/db
+    repoURL: https://git.example.com/deploy.git
+    targetRevision: HEAD
+`,
+			wantErr: false,
+		},
+		{
+			name:      "output layout directory shared with another component",
+			spec:      `{"generate_dir": "generated", "argocd": {"repo_url": "https://git.example.com/deploy.git"}}`,
+			component: "db",
+			override:  `{}`,
+			outputFiles: map[string][]string{
+				"db":  {"data/db.yaml"},
+				"web": {"data/web.yaml"},
+			},
+			want:    "",
+			wantErr: true,
+		},
+		{
+			name:        "output layout with a custom path",
+			spec:        `{"generate_dir": "generated", "argocd": {"repo_url": "https://git.example.com/deploy.git"}}`,
+			component:   "db",
+			override:    `{"argocd": {"path": "{{ .Cluster }}/{{ .Namespace }}", "name": "{{ .Component }}"}}`,
+			outputFiles: map[string][]string{"db": {"data/db.yaml"}, "web": {"data/web.yaml"}},
+			want: `apiVersion: argoproj.io/v1alpha1
+kind: Application
+metadata:
+  annotations:
+    argocd.argoproj.io/sync-wave: "0"
+  name: db
+  namespace: argocd
+spec:
+  destination:
+    namespace: data
+    server: https://kubernetes.default.svc
+  project: default
+  source:
+    path: dev/data
+    repoURL: https://git.example.com/deploy.git
+    targetRevision: HEAD
+`,
+			wantErr: false,
+		},
+		{
+			name:        "missing repo url",
+			spec:        `{"generate_dir": "generated", "argocd": {}}`,
+			component:   "db",
+			override:    `{}`,
+			outputFiles: nil,
+			want:        "",
+			wantErr:     true,
+		},
+	}
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			kr8Spec, err := kr8_types.CreateClusterSpec(
+				"dev",
+				gjson.Parse(testCase.spec),
+				types.Kr8Opts{BaseDir: "/repo", ComponentDir: "", ClusterDir: ""},
+				"",
+				zerolog.Nop(),
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			kr8Spec.ComponentOrder = order
+			kr8Spec.OutputFiles = testCase.outputFiles
+			argocd := kr8Spec.ArgoCD.Merge(kr8_types.ExtractArgoCD(gjson.Parse(testCase.override)))
+			got, err := generate.BuildArgoCDApplication(kr8Spec, argocd, testCase.component, config)
+			if (err != nil) != testCase.wantErr {
+				t.Fatalf("BuildArgoCDApplication() error = %v, wantErr %v", err, testCase.wantErr)
+			}
+			if got != testCase.want {
+				t.Errorf("BuildArgoCDApplication() = \n%s\n-want-\n%s", got, testCase.want)
+			}
+		})
+	}
+}
+
+func TestEmitArgoCDApplications(t *testing.T) {
+	baseDir := t.TempDir()
+	config := `{
+		"web": {"namespace": "frontend"},
+		"db": {"namespace": "data"}
+	}`
+	emit := func(clusterName string, order []string, config string) {
+		t.Helper()
+		kr8Spec, err := kr8_types.CreateClusterSpec(
+			clusterName,
+			gjson.Parse(`{"generate_dir": "generated", "argocd": {
+				"repo_url": "https://git.example.com/deploy.git", "output_dir": "apps"
+			}}`),
+			types.Kr8Opts{BaseDir: baseDir, ComponentDir: "", ClusterDir: ""},
+			"",
+			zerolog.Nop(),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.MkdirAll(kr8Spec.ClusterOutputDir, 0750); err != nil {
+			t.Fatal(err)
+		}
+		//nolint:exhaustruct
+		kr8Spec.ComponentOrder = &kr8_types.Kr8ComponentOrder{Order: order}
+		if err := generate.EmitArgoCDApplications(kr8Spec, order, config, zerolog.Nop()); err != nil {
+			t.Fatalf("EmitArgoCDApplications() error = %v", err)
+		}
+	}
+	listApplications := func() []string {
+		t.Helper()
+		files, err := util.BuildDirFileList(filepath.Join(baseDir, "apps"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, file := range files {
+			files[i], _ = filepath.Rel(baseDir, file)
+		}
+		sort.Strings(files)
+
+		return files
+	}
+
+	if err := os.MkdirAll(filepath.Join(baseDir, "apps", "dev"), 0750); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(baseDir, "apps", "dev", "manual.yaml"), []byte("{}"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	emit("dev", []string{"db", "web"}, config)
+	emit("prod", []string{"db", "web"}, config)
+	want := []string{"apps/dev/db.yaml", "apps/dev/manual.yaml", "apps/dev/web.yaml", "apps/prod/db.yaml", "apps/prod/web.yaml"}
+	if got := listApplications(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Applications = %v, want %v", got, want)
+	}
+
+	// Removing and disabling components only deletes the cluster's own Applications
+	emit("dev", []string{"web"}, config)
+	emit("prod", []string{"db", "web"}, `{
+		"web": {"namespace": "frontend", "kr8_spec": {"argocd": {"disabled": true}}},
+		"db": {"namespace": "data"}
+	}`)
+	want = []string{"apps/dev/manual.yaml", "apps/dev/web.yaml", "apps/prod/db.yaml"}
+	if got := listApplications(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Applications = %v, want %v", got, want)
+	}
+}
+
+func TestPlanOutputFiles(t *testing.T) {
+	config := `{
+		"_cluster": {"environment": "staging"},
+		"web": {"namespace": "apps", "kr8_spec": {"includes": ["web.jsonnet", {"file": "svc.jsonnet", "dest_dir": "svc", "dest_ext": "yaml"}]}},
+		"db": {"namespace": "data", "kr8_spec": {"includes": ["db.jsonnet"]}}
+	}`
+	clusterComponents := map[string]gjson.Result{"web": {}, "db": {}, "cache": {}}
+
+	tests := []struct {
+		name     string
+		layout   string
+		index    *generate.OutputIndex
+		compList []string
+		want     map[string][]string
+		removed  []string
+		wantErr  bool
+	}{
+		{
+			name:     "namespace layout",
+			layout:   "{{ .cluster_params.environment }}/{{ .namespace }}/{{ .dest_dir }}/{{ .file }}",
+			index:    nil,
+			compList: []string{"db", "web"},
+			want: map[string][]string{
+				"db":  {"staging/data/db.yaml"},
+				"web": {"staging/apps/web.yaml", "staging/apps/svc/svc.yaml"},
+			},
+			removed: nil,
+			wantErr: false,
+		},
+		{
+			name:   "previous files are kept for filtered components and removed for the others",
+			layout: "{{ .namespace }}/{{ .file }}",
+			index: &generate.OutputIndex{
+				Layout: "{{ .namespace }}/{{ .file }}",
+				Files: map[string][]string{
+					"web":     {"apps/web.yaml", "apps/old.yaml"},
+					"cache":   {"cache/cache.yaml"},
+					"removed": {"removed/removed.yaml"},
+				},
+			},
+			compList: []string{"web"},
+			want: map[string][]string{
+				"web":   {"apps/web.yaml", "apps/svc.yaml"},
+				"cache": {"cache/cache.yaml"},
+			},
+			removed: []string{"apps/old.yaml", "removed/removed.yaml"},
+			wantErr: false,
+		},
+		{
+			name:   "previous file outside the cluster output directory",
+			layout: "{{ .namespace }}/{{ .file }}",
+			index: &generate.OutputIndex{
+				Layout: "{{ .namespace }}/{{ .file }}",
+				Files:  map[string][]string{"web": {"apps/web.yaml", "../../outside.yaml"}},
+			},
+			compList: []string{"web"},
+			want:     nil,
+			removed:  nil,
+			wantErr:  true,
+		},
+		{
+			name:     "same path for components",
+			layout:   "all.yaml",
+			index:    nil,
+			compList: []string{"db", "web"},
+			want:     nil,
+			removed:  nil,
+			wantErr:  true,
+		},
+		{
+			name:     "file used as directory",
+			layout:   "{{ if eq .component \"db\" }}apps{{ else }}apps/{{ .file }}{{ end }}",
+			index:    nil,
+			compList: []string{"db", "web"},
+			want:     nil,
+			removed:  nil,
+			wantErr:  true,
+		},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			kr8Spec := kr8_types.Kr8ClusterSpec{
+				Name:             "dev",
+				ClusterOutputDir: t.TempDir(),
+				OutputLayout:     testCase.layout,
+			}
+			for _, relPath := range testCase.removed {
+				file := filepath.Join(kr8Spec.ClusterOutputDir, relPath)
+				if err := os.MkdirAll(filepath.Dir(file), 0750); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(file, []byte("{}"), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			got, err := generate.PlanOutputFiles(
+				kr8Spec, testCase.index, clusterComponents, testCase.compList, config, zerolog.Nop(),
+			)
+			if (err != nil) != testCase.wantErr {
+				t.Fatalf("PlanOutputFiles() error = %v, wantErr %v", err, testCase.wantErr)
+			}
+			if testCase.wantErr {
+				return
+			}
+			if !reflect.DeepEqual(got, testCase.want) {
+				t.Errorf("PlanOutputFiles() = %v, want %v", got, testCase.want)
+			}
+			for _, relPath := range testCase.removed {
+				if _, err := os.Stat(filepath.Join(kr8Spec.ClusterOutputDir, relPath)); !os.IsNotExist(err) {
+					t.Errorf("PlanOutputFiles() did not remove %s", relPath)
+				}
+				if _, err := os.Stat(filepath.Dir(filepath.Join(kr8Spec.ClusterOutputDir, relPath))); !os.IsNotExist(err) {
+					t.Errorf("PlanOutputFiles() did not remove the empty directory of %s", relPath)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadOutputIndex(t *testing.T) {
+	tests := []struct {
+		name    string
+		index   string
+		want    *generate.OutputIndex
+		wantErr bool
+	}{
+		{
+			name:    "files in the cluster output directory",
+			index:   `{"layout": "{{ .file }}", "files": {"web": ["web.yaml"]}}`,
+			want:    &generate.OutputIndex{Layout: "{{ .file }}", Files: map[string][]string{"web": {"web.yaml"}}},
+			wantErr: false,
+		},
+		{
+			name:    "file outside the cluster output directory",
+			index:   `{"layout": "{{ .file }}", "files": {"web": ["../../x"]}}`,
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name:    "absolute file",
+			index:   `{"layout": "{{ .file }}", "files": {"web": ["/etc/x"]}}`,
+			want:    nil,
+			wantErr: true,
+		},
+	}
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			clusterOutputDir := t.TempDir()
+			indexFile := filepath.Join(clusterOutputDir, generate.OutputIndexFile)
+			if err := os.WriteFile(indexFile, []byte(testCase.index), 0600); err != nil {
+				t.Fatal(err)
+			}
+			got, err := generate.LoadOutputIndex(clusterOutputDir)
+			if (err != nil) != testCase.wantErr {
+				t.Fatalf("LoadOutputIndex() error = %v, wantErr %v", err, testCase.wantErr)
+			}
+			if !reflect.DeepEqual(got, testCase.want) {
+				t.Errorf("LoadOutputIndex() = %v, want %v", got, testCase.want)
+			}
+		})
+	}
+}
+
+func TestChangedComponents(t *testing.T) {
+	generateDir := t.TempDir()
+	index := generate.OutputIndex{
+		Layout: "{{ .namespace }}/{{ .file }}",
+		Files:  map[string][]string{"web": {"apps/web.yaml"}, "db": {"data/db.yaml"}},
+	}
+	if err := os.MkdirAll(filepath.Join(generateDir, "prod"), 0750); err != nil {
+		t.Fatal(err)
+	}
+	if err := index.Write(filepath.Join(generateDir, "prod")); err != nil {
+		t.Fatal(err)
+	}
+
+	got := generate.ChangedComponents(generateDir, []string{
+		"dev/.kr8_cache",
+		"dev/web/web.yaml",
+		"dev/web/svc/svc.yaml",
+		"dev/_argocd/db.yaml",
+		"prod/data/db.yaml",
+		"staging/.kr8_manifest.json",
+	})
+	want := map[string][]string{
+		"dev":     {"web", "db"},
+		"prod":    {"db"},
+		"staging": {},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ChangedComponents() = %v, want %v", got, want)
+	}
+}
+
+func TestFindAffected(t *testing.T) {
+	baseDir := t.TempDir()
+	files := map[string]string{
+		"clusters/params.jsonnet": `{
+  _kr8_spec: { generate_dir: 'generated' },
+  _components: { web: { path: 'components/web' }, db: { path: 'components/db' } },
+}`,
+		"clusters/dev/cluster.jsonnet":        `{ _components+: { report: { path: 'components/report' } } }`,
+		"clusters/prod/params.jsonnet":        `{ _cluster+: { tier: 'prod' } }`,
+		"clusters/prod/prod1/cluster.jsonnet": `{}`,
+		"components/web/params.jsonnet":       `{ kr8_spec: { includes: ['web.jsonnet'] } }`,
+		"components/web/web.jsonnet":          `(import 'labels.libsonnet') + {}`,
+		"components/db/params.jsonnet": `{
+  kr8_spec: { includes: ['db.jsonnet'], extfiles: { schema: '../shared/schema.sql' }, jpaths: ['vendor'] },
+}`,
+		"components/db/db.jsonnet":                `import 'settings.libsonnet'`,
+		"components/db/vendor/settings.libsonnet": `import 'shared.libsonnet'`,
+		"components/shared/schema.sql":            `CREATE TABLE t ();`,
+		"components/report/params.jsonnet": `{
+  kr8_spec: { includes: [], enable_kr8_outputs: true, depends_on: ['db'] },
+}`,
+		"lib/labels.libsonnet": `{}`,
+		"lib/shared.libsonnet": `{}`,
+	}
+	for name, content := range files {
+		path := filepath.Join(baseDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	kr8Opts := types.Kr8Opts{
+		BaseDir:      baseDir,
+		ComponentDir: filepath.Join(baseDir, "components"),
+		ClusterDir:   filepath.Join(baseDir, "clusters"),
+	}
+	//nolint:exhaustruct
+	vmConfig := types.VMConfig{BaseDir: baseDir}
+
+	tests := []struct {
+		name    string
+		changed []string
+		want    []generate.AffectedCluster
+	}{
+		{
+			name:    "unrelated file",
+			changed: []string{"README.md"},
+			want:    []generate.AffectedCluster{},
+		},
+		{
+			name:    "component file",
+			changed: []string{"components/web/web.jsonnet"},
+			want: []generate.AffectedCluster{
+				{Name: "dev", AllComponents: false, Components: []string{"web"}},
+				{Name: "prod1", AllComponents: false, Components: []string{"web"}},
+			},
+		},
+		{
+			name:    "library imported by an include",
+			changed: []string{"lib/labels.libsonnet"},
+			want: []generate.AffectedCluster{
+				{Name: "dev", AllComponents: false, Components: []string{"web"}},
+				{Name: "prod1", AllComponents: false, Components: []string{"web"}},
+			},
+		},
+		{
+			name:    "extfile propagates to dependent outputs",
+			changed: []string{"components/shared/schema.sql"},
+			want: []generate.AffectedCluster{
+				{Name: "dev", AllComponents: false, Components: []string{"db", "report"}},
+				{Name: "prod1", AllComponents: false, Components: []string{"db"}},
+			},
+		},
+		{
+			name:    "library imported through component jpaths",
+			changed: []string{"lib/shared.libsonnet"},
+			want: []generate.AffectedCluster{
+				{Name: "dev", AllComponents: false, Components: []string{"db", "report"}},
+				{Name: "prod1", AllComponents: false, Components: []string{"db"}},
+			},
+		},
+		{
+			name:    "cluster params chain",
+			changed: []string{"clusters/prod/params.jsonnet"},
+			want: []generate.AffectedCluster{
+				{Name: "prod1", AllComponents: true, Components: []string{"db", "web"}},
+			},
+		},
+	}
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			changed := make([]string, len(testCase.changed))
+			for i, file := range testCase.changed {
+				changed[i] = filepath.Join(baseDir, file)
+			}
+			got, err := generate.FindAffected(changed, kr8Opts, vmConfig, false, zerolog.Nop())
+			if err != nil {
+				t.Fatalf("FindAffected() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, testCase.want) {
+				t.Errorf("FindAffected() = %v, want %v", got, testCase.want)
+			}
+		})
+	}
+}
+
+func TestAffectedFilter(t *testing.T) {
+	affected := []generate.AffectedCluster{
+		{Name: "dev", AllComponents: false, Components: []string{"web"}},
+		{Name: "prod.eu", AllComponents: true, Components: []string{"db", "web"}},
+	}
+	tests := []struct {
+		name     string
+		affected []generate.AffectedCluster
+		clusters bool
+		want     string
+	}{
+		{name: "clusters", affected: affected, clusters: true, want: `^dev$,^prod\.eu$`},
+		{name: "components", affected: affected, clusters: false, want: "db,web"},
+		{name: "no clusters affected", affected: []generate.AffectedCluster{}, clusters: true, want: "^$"},
+		{
+			name:     "no components affected",
+			affected: []generate.AffectedCluster{{Name: "dev", AllComponents: false, Components: []string{}}},
+			clusters: false,
+			want:     "^$",
+		},
+	}
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			if got := generate.AffectedFilter(testCase.affected, testCase.clusters); got != testCase.want {
+				t.Errorf("AffectedFilter() = %q, want %q", got, testCase.want)
+			}
+		})
+	}
+}
+
+func TestCompareClusterParams(t *testing.T) {
+	baseDir := t.TempDir()
+	files := map[string]string{
+		"clusters/params.jsonnet": `{
+  _cluster: { tier: 'standard' },
+  _components: { web: { path: 'components/web' } },
+}`,
+		"clusters/a/cluster.jsonnet":    `{}`,
+		"clusters/b/cluster.jsonnet":    `{ web+: { replicas: 3 } }`,
+		"clusters/c/cluster.jsonnet":    `{ _cluster+: { tier: 'large' }, web+: { image: 'web:2' } }`,
+		"clusters/d/cluster.jsonnet":    `{ _components: { cm: { path: 'components/cm' } } }`,
+		"components/cm/params.jsonnet":  `{ namespace: 'cm' }`,
+		"components/web/params.jsonnet": `{ replicas: 1, image: 'web:1' }`,
+	}
+	for name, content := range files {
+		path := filepath.Join(baseDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	//nolint:exhaustruct
+	vmConfig := types.VMConfig{BaseDir: baseDir}
+
+	tests := []struct {
+		name      string
+		component string
+		params    []string
+		want      *generate.ParamComparison
+	}{
+		{
+			name:      "component params",
+			component: "web",
+			params:    []string{"replicas", "image", "missing"},
+			want: &generate.ParamComparison{
+				Params: []string{"replicas", "image", "missing"},
+				Common: map[string]json.RawMessage{"replicas": json.RawMessage(`1`), "image": json.RawMessage(`"web:1"`)},
+				Clusters: []generate.ClusterParamValues{
+					{
+						Name:    "a",
+						Values:  map[string]json.RawMessage{"replicas": json.RawMessage(`1`), "image": json.RawMessage(`"web:1"`)},
+						Differs: []string{},
+					},
+					{
+						Name:    "b",
+						Values:  map[string]json.RawMessage{"replicas": json.RawMessage(`3`), "image": json.RawMessage(`"web:1"`)},
+						Differs: []string{"replicas"},
+					},
+					{
+						Name:    "c",
+						Values:  map[string]json.RawMessage{"replicas": json.RawMessage(`1`), "image": json.RawMessage(`"web:2"`)},
+						Differs: []string{"image"},
+					},
+				},
+			},
+		},
+		{
+			name:      "cluster params",
+			component: "",
+			params:    []string{"_cluster.tier"},
+			want: &generate.ParamComparison{
+				Params: []string{"_cluster.tier"},
+				Common: map[string]json.RawMessage{"_cluster.tier": json.RawMessage(`"standard"`)},
+				Clusters: []generate.ClusterParamValues{
+					{Name: "a", Values: map[string]json.RawMessage{"_cluster.tier": json.RawMessage(`"standard"`)}, Differs: []string{}},
+					{Name: "b", Values: map[string]json.RawMessage{"_cluster.tier": json.RawMessage(`"standard"`)}, Differs: []string{}},
+					{Name: "c", Values: map[string]json.RawMessage{"_cluster.tier": json.RawMessage(`"large"`)}, Differs: []string{"_cluster.tier"}},
+					{Name: "d", Values: map[string]json.RawMessage{"_cluster.tier": json.RawMessage(`"standard"`)}, Differs: []string{}},
+				},
+			},
+		},
+	}
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			got, err := generate.CompareClusterParams(
+				[]string{"d", "c", "b", "a"}, testCase.component, testCase.params, vmConfig, false,
+			)
+			if err != nil {
+				t.Fatalf("CompareClusterParams() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, testCase.want) {
+				t.Errorf("CompareClusterParams() = %+v, want %+v", got, testCase.want)
+			}
+		})
+	}
+}
+
+func TestDiffClusterParams(t *testing.T) {
+	baseDir := t.TempDir()
+	files := map[string]string{
+		"clusters/params.jsonnet": `{
+  _cluster: { tier: 'standard' },
+  _components: { web: { path: 'components/web' } },
+}`,
+		"clusters/staging/cluster.jsonnet": `{ _cluster+: { name: 'staging' } }`,
+		"clusters/prod/cluster.jsonnet": `{
+  _cluster+: { name: 'prod' },
+  _components+: { db: { path: 'components/db' } },
+  web+: { replicas: 3 },
+}`,
+		"components/web/params.jsonnet": `{ replicas: 1 }`,
+		"components/db/params.jsonnet":  `{ size: 'large' }`,
+	}
+	for name, content := range files {
+		path := filepath.Join(baseDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	//nolint:exhaustruct
+	vmConfig := types.VMConfig{BaseDir: baseDir}
+
+	got, err := generate.DiffClusterParams("staging", "prod", vmConfig, false)
+	if err != nil {
+		t.Fatalf("DiffClusterParams() error = %v", err)
+	}
+	want := []kr8_diff.Section{
+		{Name: "_cluster", Changes: []kr8_diff.Change{
+			{Path: "name", Type: kr8_diff.ChangeModified, Old: json.RawMessage(`"staging"`), New: json.RawMessage(`"prod"`)},
+		}},
+		{Name: "_components", Changes: []kr8_diff.Change{
+			{Path: "db", Type: kr8_diff.ChangeAdded, Old: nil, New: json.RawMessage(`{"path":"components/db"}`)},
+		}},
+		{Name: "db", Changes: []kr8_diff.Change{
+			{Path: "", Type: kr8_diff.ChangeAdded, Old: nil, New: json.RawMessage(`{"size":"large"}`)},
+		}},
+		{Name: "web", Changes: []kr8_diff.Change{
+			{Path: "replicas", Type: kr8_diff.ChangeModified, Old: json.RawMessage(`1`), New: json.RawMessage(`3`)},
+		}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("DiffClusterParams() = %+v, want %+v", got, want)
+	}
+}
+
+func TestDiffClusterOutput(t *testing.T) {
+	baseDir := t.TempDir()
+	files := map[string]string{
+		"staging/web/deployment.yaml": `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  namespace: web
+  labels: { cluster: staging }
+spec:
+  replicas: 1
+`,
+		"staging/web/config.yaml": `apiVersion: v1
+kind: ConfigMap
+metadata: { name: web-staging, namespace: web }
+data: { host: web.staging.example.com }
+`,
+		"staging/web/old.yaml": `apiVersion: v1
+kind: Service
+metadata: { name: web-old, namespace: web }
+`,
+		// Objects are matched by identity, not by file
+		"prod/web/all.yaml": `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  namespace: web
+  labels: { cluster: prod }
+spec:
+  replicas: 3
+---
+apiVersion: v1
+kind: ConfigMap
+metadata: { name: web-prod, namespace: web }
+data: { host: web.prod.example.com }
+`,
+	}
+	for name, content := range files {
+		path := filepath.Join(baseDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := generate.DiffClusterOutput(
+		filepath.Join(baseDir, "staging"),
+		filepath.Join(baseDir, "prod"),
+		[]kr8_diff.Substitution{{Old: "staging", New: "prod"}},
+		zerolog.Nop(),
+	)
+	if err != nil {
+		t.Fatalf("DiffClusterOutput() error = %v", err)
+	}
+	want := []kr8_diff.Section{
+		{Name: "web: Service/web/web-old", Changes: []kr8_diff.Change{
+			{
+				Path: "", Type: kr8_diff.ChangeRemoved, New: nil,
+				Old: json.RawMessage(`{"apiVersion":"v1","kind":"Service","metadata":{"name":"web-old","namespace":"web"}}`),
+			},
+		}},
+		{Name: "web: apps/Deployment/web/web", Changes: []kr8_diff.Change{
+			{Path: "spec.replicas", Type: kr8_diff.ChangeModified, Old: json.RawMessage(`1`), New: json.RawMessage(`3`)},
+		}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("DiffClusterOutput() = %+v, want %+v", got, want)
+	}
+}
+
+func TestClusterChecksEnabled(t *testing.T) {
+	tests := []struct {
+		name string
+		spec string
+		want bool
+	}{
+		{name: "duplicate resources warned by default", spec: `{}`, want: true},
+		{name: "duplicate resources ignored", spec: `{"duplicate_resources": "ignore"}`, want: false},
+		{name: "duplicate resources denied", spec: `{"duplicate_resources": "deny"}`, want: true},
+		{
+			name: "kubernetes version",
+			spec: `{"duplicate_resources": "ignore", "kubernetes_version": "1.29.0"}`,
+			want: true,
+		},
+	}
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			kr8Spec, err := kr8_types.CreateClusterSpec(
+				"dev",
+				gjson.Parse(testCase.spec),
+				types.Kr8Opts{BaseDir: "/repo", ComponentDir: "", ClusterDir: ""},
+				"",
+				zerolog.Nop(),
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := generate.ClusterChecksEnabled(kr8Spec); got != testCase.want {
+				t.Errorf("ClusterChecksEnabled() = %v, want %v", got, testCase.want)
+			}
+		})
+	}
+}
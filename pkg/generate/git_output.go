@@ -0,0 +1,52 @@
+package generate
+
+import (
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/ice-bergtech/kr8/pkg/kr8_sign"
+	"github.com/ice-bergtech/kr8/pkg/kr8_types"
+)
+
+// Maps changed paths in the generate directory to the clusters and components they belong to.
+// Paths are relative to the generate directory, starting with the cluster name.
+// Files generated with an `output_layout` are mapped with the cluster's output index,
+// and ArgoCD Applications in the default output directory are mapped to their component.
+// Changes that can't be mapped to a component, such as kr8+ bookkeeping files, only mark the cluster as changed.
+func ChangedComponents(generateDir string, changed []string) map[string][]string {
+	affected := map[string][]string{}
+	indexes := map[string]*OutputIndex{}
+	for _, changedPath := range changed {
+		cluster, relPath, found := strings.Cut(changedPath, "/")
+		if !found {
+			continue
+		}
+		if _, ok := affected[cluster]; !ok {
+			affected[cluster] = []string{}
+		}
+		if _, ok := indexes[cluster]; !ok {
+			indexes[cluster], _ = LoadOutputIndex(filepath.Join(generateDir, cluster))
+		}
+
+		component := ""
+		switch {
+		case kr8_sign.IsBookkeepingFile(relPath):
+		case strings.HasPrefix(relPath, kr8_types.ArgoCDOutputDir+"/"):
+			component = strings.TrimSuffix(strings.TrimPrefix(relPath, kr8_types.ArgoCDOutputDir+"/"), ".yaml")
+		case indexes[cluster] != nil:
+			for indexComponent, files := range indexes[cluster].Files {
+				if slices.Contains(files, relPath) {
+					component = indexComponent
+				}
+			}
+		default:
+			component, _, _ = strings.Cut(relPath, "/")
+		}
+		if component != "" && !slices.Contains(affected[cluster], component) {
+			affected[cluster] = append(affected[cluster], component)
+		}
+	}
+
+	return affected
+}
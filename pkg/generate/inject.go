@@ -0,0 +1,218 @@
+package generate
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/ice-bergtech/kr8/pkg/kr8_types"
+	util "github.com/ice-bergtech/kr8/pkg/util"
+)
+
+// Built-in list of Kubernetes kinds that are not namespaced.
+// Objects of these kinds never have a namespace injected.
+//
+//nolint:gochecknoglobals
+var ClusterScopedKinds = []string{
+	"APIService",
+	"CertificateSigningRequest",
+	"ClusterRole",
+	"ClusterRoleBinding",
+	"ComponentStatus",
+	"CSIDriver",
+	"CSINode",
+	"CustomResourceDefinition",
+	"FlowSchema",
+	"IngressClass",
+	"MutatingAdmissionPolicy",
+	"MutatingAdmissionPolicyBinding",
+	"MutatingWebhookConfiguration",
+	"Namespace",
+	"Node",
+	"PersistentVolume",
+	"PodSecurityPolicy",
+	"PriorityClass",
+	"PriorityLevelConfiguration",
+	"RuntimeClass",
+	"SelfSubjectAccessReview",
+	"SelfSubjectReview",
+	"SelfSubjectRulesReview",
+	"StorageClass",
+	"SubjectAccessReview",
+	"TokenReview",
+	"ValidatingAdmissionPolicy",
+	"ValidatingAdmissionPolicyBinding",
+	"ValidatingWebhookConfiguration",
+	"VolumeAttachment",
+	"VolumeAttributesClass",
+}
+
+// Annotation holding the ArgoCD sync wave of a component's objects.
+const SyncWaveAnnotation = "argocd.argoproj.io/sync-wave"
+
+// Settings for the injection stage, passed to jsonnet as json.
+type injectOptions struct {
+	Labels        map[string]string `json:"labels"`
+	Annotations   map[string]string `json:"annotations"`
+	Namespace     *string           `json:"namespace"`
+	ClusterScoped map[string]bool   `json:"clusterScoped"`
+}
+
+// Jsonnet function that stamps labels, annotations and the default namespace onto each output object.
+// Values already set on an object take precedence. `List` kinds have their items stamped instead.
+const injectSnippet = `
+local inject = %s;
+local orEmpty(value) = if value == null then {} else value;
+local stamp(obj) =
+  if !std.isObject(obj) || !std.objectHas(obj, 'kind') then obj
+  else if std.endsWith(obj.kind, 'List') && std.objectHas(obj, 'items') then obj { items: std.map(stamp, obj.items) }
+  else
+    local metadata = orEmpty(std.get(obj, 'metadata', {}));
+    obj {
+      metadata+: {
+        [if std.length(inject.labels) > 0 then 'labels']: inject.labels + orEmpty(std.get(metadata, 'labels', {})),
+        [if std.length(inject.annotations) > 0 then 'annotations']:
+          inject.annotations + orEmpty(std.get(metadata, 'annotations', {})),
+        [if inject.namespace != null && !std.objectHas(inject.clusterScoped, obj.kind) &&
+            std.get(metadata, 'namespace', null) == null then 'namespace']: inject.namespace,
+      },
+    };
+function(output) if std.isArray(output) then std.map(stamp, output) else output
+`
+
+// Jsonnet snippet that chains postprocessor stages.
+// Stages that accept two parameters are called with a context object.
+const processChainSnippet = `
+local kr8_context = %s + { include: std.extVar('kr8_include') };
+local kr8_call(stage, input) = if std.length(stage) >= 2 then stage(input, kr8_context) else stage(input);
+local kr8_stages = [
+%s
+];
+function(input) std.foldl(function(output, stage) kr8_call(stage, output), kr8_stages, input)
+`
+
+// Context passed to postprocessors that accept a second parameter.
+// The include being processed is added from the `kr8_include` ext var.
+type processContext struct {
+	Cluster   string `json:"cluster"`
+	Component string `json:"component"`
+}
+
+// Builds the jsonnet postprocessor for a component.
+// Stages run in order:
+//
+//  1. the cluster `postprocessor`
+//  2. the component `postprocessors`, in the order listed
+//  3. injection of common labels, annotations, sync waves and the default namespace
+//
+// If the component has no postprocessors and no injection is configured,
+// the cluster postprocessor is returned unchanged.
+// componentDir is used to resolve postprocessor files relative to the component.
+func BuildPostProcessor(
+	kr8Spec kr8_types.Kr8ClusterSpec,
+	compSpec kr8_types.Kr8ComponentSpec,
+	componentName string,
+	namespace string,
+	componentDir string,
+) (string, error) {
+	userProcess := kr8Spec.PostProcessor
+	if userProcess == "" {
+		// Default PostProcessor passes input to output
+		userProcess = "function(input) input"
+	}
+
+	injectStage, err := buildInjectStage(kr8Spec, compSpec, componentName, namespace)
+	if err != nil {
+		return "", err
+	}
+	if len(compSpec.PostProcessors) == 0 && injectStage == "" {
+		return userProcess, nil
+	}
+
+	stages := make([]string, 0, len(compSpec.PostProcessors)+2) //nolint:mnd
+	stages = append(stages, userProcess)
+	for _, postProcessor := range compSpec.PostProcessors {
+		stages = append(stages, postProcessorCode(postProcessor, componentDir))
+	}
+	if injectStage != "" {
+		stages = append(stages, injectStage)
+	}
+	contextJSON, err := json.Marshal(processContext{Cluster: kr8Spec.Name, Component: componentName})
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf(processChainSnippet, string(contextJSON), "  ("+strings.Join(stages, "),\n  (")+")"), nil
+}
+
+// Converts a component postprocessor spec into jsonnet code that evaluates to the function.
+func postProcessorCode(postProcessor kr8_types.Kr8PostProcessorSpec, componentDir string) string {
+	if postProcessor.Code != "" {
+		return postProcessor.Code
+	}
+	file := postProcessor.File
+	if !filepath.IsAbs(file) {
+		if _, err := os.Stat(filepath.Join(componentDir, file)); err == nil {
+			file, _ = filepath.Abs(filepath.Join(componentDir, file))
+		}
+	}
+	code := "import " + util.QuoteJsonnet(file)
+	if postProcessor.Function != "" {
+		code = "(" + code + ")[" + util.QuoteJsonnet(postProcessor.Function) + "]"
+	}
+
+	return code
+}
+
+// Builds the jsonnet stage that injects labels, annotations and the default namespace.
+// If sync waves are enabled, the component's wave is added as an annotation.
+// Returns an empty string if no injection is configured.
+func buildInjectStage(
+	kr8Spec kr8_types.Kr8ClusterSpec,
+	compSpec kr8_types.Kr8ComponentSpec,
+	componentName string,
+	namespace string,
+) (string, error) {
+	options := injectOptions{
+		Labels:        map[string]string{},
+		Annotations:   map[string]string{},
+		Namespace:     nil,
+		ClusterScoped: map[string]bool{},
+	}
+	if kr8Spec.SyncWaves && kr8Spec.ComponentOrder != nil {
+		if wave, ok := kr8Spec.ComponentOrder.Waves[componentName]; ok {
+			options.Annotations[SyncWaveAnnotation] = strconv.Itoa(wave)
+		}
+	}
+	maps.Copy(options.Labels, kr8Spec.CommonLabels)
+	maps.Copy(options.Labels, compSpec.CommonLabels)
+	maps.Copy(options.Annotations, kr8Spec.CommonAnnotations)
+	maps.Copy(options.Annotations, compSpec.CommonAnnotations)
+	injectNamespace := kr8Spec.InjectNamespace
+	if compSpec.InjectNamespace != nil {
+		injectNamespace = *compSpec.InjectNamespace
+	}
+	if injectNamespace && namespace != "" {
+		options.Namespace = &namespace
+	}
+	if len(options.Labels) == 0 && len(options.Annotations) == 0 && options.Namespace == nil {
+		return "", nil
+	}
+
+	for _, kind := range ClusterScopedKinds {
+		options.ClusterScoped[kind] = true
+	}
+	for _, kind := range kr8Spec.ClusterScopedKinds {
+		options.ClusterScoped[kind] = true
+	}
+	optionsJSON, err := json.Marshal(options)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf(injectSnippet, string(optionsJSON)), nil
+}
@@ -0,0 +1,381 @@
+package generate
+
+import (
+	"encoding/json"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/rs/zerolog"
+	"github.com/tidwall/gjson"
+
+	"github.com/ice-bergtech/kr8/pkg/kr8_check"
+	"github.com/ice-bergtech/kr8/pkg/kr8_sign"
+	"github.com/ice-bergtech/kr8/pkg/kr8_types"
+	types "github.com/ice-bergtech/kr8/pkg/types"
+	util "github.com/ice-bergtech/kr8/pkg/util"
+)
+
+// Name of the bookkeeping file listing the files generated with an `output_layout`.
+const OutputIndexFile = ".kr8_files.json"
+
+// Index of the files generated for a cluster with an `output_layout`.
+// Stored in the cluster output directory, and used to clean up files that are no longer generated.
+type OutputIndex struct {
+	// Layout the files were generated with
+	Layout string `json:"layout"`
+	// Files generated for each component, relative to the cluster output directory
+	Files map[string][]string `json:"files"`
+}
+
+// Loads the output index of a cluster.
+// Returns nil if the cluster has no output index.
+// Every file listed must be within the cluster output directory.
+func LoadOutputIndex(clusterOutputDir string) (*OutputIndex, error) {
+	data, err := os.ReadFile(filepath.Join(clusterOutputDir, OutputIndexFile))
+	if os.IsNotExist(err) {
+		return nil, nil //nolint:nilnil
+	} else if err != nil {
+		return nil, util.ErrorIfCheck("error reading output index", err)
+	}
+	var index OutputIndex
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, util.ErrorIfCheck("error parsing output index", err)
+	}
+	for _, files := range index.Files {
+		for _, relPath := range files {
+			if !filepath.IsLocal(filepath.FromSlash(relPath)) {
+				return nil, types.Kr8Error{Message: "output index lists a file outside the cluster output directory", Value: relPath}
+			}
+		}
+	}
+
+	return &index, nil
+}
+
+// Writes the output index of a cluster.
+func (i OutputIndex) Write(clusterOutputDir string) error {
+	data, err := json.MarshalIndent(i, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(clusterOutputDir, OutputIndexFile), data, 0600)
+}
+
+// Fills in the default destination name of an include.
+func includeDestination(
+	kr8Spec kr8_types.Kr8ClusterSpec,
+	include kr8_types.Kr8ComponentSpecIncludeObject,
+) kr8_types.Kr8ComponentSpecIncludeObject {
+	if include.DestName != "" {
+		return include
+	}
+	if kr8Spec.GenerateShortNames {
+		sBase := filepath.Base(include.File)
+		include.DestName = sBase[0 : len(sBase)-len(filepath.Ext(include.File))]
+	} else {
+		// replaces slashes with _ in multi-dir paths and replace extension with yaml
+		include.DestName = strings.ReplaceAll(
+			include.File[0:len(include.File)-len(filepath.Ext(include.File))],
+			"/", "_",
+		)
+	}
+
+	return include
+}
+
+// Renders the path of an include's output file with the cluster's `output_layout`.
+// The path is relative to the cluster output directory.
+func IncludeOutputPath(
+	kr8Spec kr8_types.Kr8ClusterSpec,
+	config string,
+	componentName string,
+	include kr8_types.Kr8ComponentSpecIncludeObject,
+) (string, error) {
+	fileName := filepath.Base(include.DestName + "." + include.DestExt)
+	clusterParams, _ := gjson.Get(config, "_cluster").Value().(map[string]any)
+	if clusterParams == nil {
+		clusterParams = map[string]any{}
+	}
+	data := map[string]any{
+		"cluster":        kr8Spec.Name,
+		"component":      componentName,
+		"namespace":      gjson.Get(config, componentName+".namespace").String(),
+		"release_name":   gjson.Get(config, componentName+".release_name").String(),
+		"include":        include.File,
+		"dest_dir":       include.DestDir,
+		"dest_name":      strings.TrimSuffix(fileName, "."+include.DestExt),
+		"dest_ext":       include.DestExt,
+		"file":           fileName,
+		"cluster_params": clusterParams,
+	}
+	outputPath, err := kr8_types.RenderOutputLayout(kr8Spec.OutputLayout, data)
+	if err != nil {
+		return "", types.Kr8Error{
+			Message: "error rendering output path for " + componentName + " include " + include.File,
+			Value:   err,
+		}
+	}
+
+	return outputPath, nil
+}
+
+// Removes the output of a previous layout when a cluster's `output_layout` changes.
+// Files listed in the output index are removed, or every component directory if the cluster had no index.
+// The cache is disabled for the run so every component is generated with the new layout.
+// Changing the layout while only generating some components is an error, as the other components would be lost.
+// Returns the output index to plan from, nil if the previous output was removed.
+func ResetOutputLayout(
+	kr8Spec *kr8_types.Kr8ClusterSpec,
+	index *OutputIndex,
+	existingComponents []string,
+	filtered bool,
+	logger zerolog.Logger,
+) (*OutputIndex, error) {
+	previousLayout := ""
+	if index != nil {
+		previousLayout = index.Layout
+	}
+	if previousLayout == kr8Spec.OutputLayout {
+		return index, nil
+	}
+
+	// Output generated with the default layout is moved only when switching to a custom layout.
+	previous := []string{}
+	if index != nil {
+		for _, files := range index.Files {
+			previous = append(previous, files...)
+		}
+	} else {
+		for _, entry := range existingComponents {
+			if !kr8_sign.IsBookkeepingFile(entry) && !isArgoCDOutput(*kr8Spec, entry) {
+				previous = append(previous, entry)
+			}
+		}
+	}
+	if len(previous) > 0 && filtered {
+		return nil, types.Kr8Error{
+			Message: "`output_layout` changed, generate all components of the cluster to move the existing output",
+			Value:   kr8Spec.Name,
+		}
+	}
+
+	if len(previous) > 0 {
+		logger.Info().Str("layout", kr8Spec.OutputLayout).Msg("Output layout changed, regenerating all components")
+		kr8Spec.EnableCache = false
+	}
+	for _, relPath := range previous {
+		if err := removeOutputFile(kr8Spec.ClusterOutputDir, relPath); err != nil {
+			return nil, err
+		}
+	}
+	if index != nil && kr8Spec.OutputLayout == "" {
+		if err := os.Remove(filepath.Join(kr8Spec.ClusterOutputDir, OutputIndexFile)); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+
+	return nil, nil //nolint:nilnil
+}
+
+// Plans the files generated for each component with the cluster's `output_layout`.
+// Components that aren't being generated keep the files listed in the previous output index.
+// Two includes writing to the same path are an error.
+// Files of the previous index that are no longer generated are removed,
+// unless the component sets `disable_output_clean`.
+func PlanOutputFiles(
+	kr8Spec kr8_types.Kr8ClusterSpec,
+	index *OutputIndex,
+	clusterComponents map[string]gjson.Result,
+	compList []string,
+	config string,
+	logger zerolog.Logger,
+) (map[string][]string, error) {
+	files := make(map[string][]string, len(clusterComponents))
+	if index != nil {
+		for component, componentFiles := range index.Files {
+			if _, found := clusterComponents[component]; found && !slices.Contains(compList, component) {
+				files[component] = componentFiles
+			}
+		}
+	}
+	for _, componentName := range compList {
+		compSpec, err := kr8_types.CreateComponentSpec(gjson.Get(config, componentName+".kr8_spec"), logger)
+		if err != nil {
+			return nil, types.Kr8Error{Message: "error creating component spec for " + componentName, Value: err}
+		}
+		files[componentName] = []string{}
+		for _, include := range compSpec.Includes {
+			outputPath, err := IncludeOutputPath(kr8Spec, config, componentName, includeDestination(kr8Spec, include))
+			if err != nil {
+				return nil, err
+			}
+			if isArgoCDOutput(kr8Spec, outputPath) {
+				return nil, types.Kr8Error{Message: "`output_layout` path is in the ArgoCD output directory", Value: outputPath}
+			}
+			files[componentName] = append(files[componentName], outputPath)
+		}
+	}
+	if err := checkOutputCollisions(files); err != nil {
+		return nil, err
+	}
+
+	if index == nil {
+		return files, nil
+	}
+	for component, previousFiles := range index.Files {
+		if _, found := clusterComponents[component]; found && !slices.Contains(compList, component) {
+			continue
+		}
+		if gjson.Get(config, component+".kr8_spec.disable_output_clean").Bool() {
+			continue
+		}
+		for _, relPath := range previousFiles {
+			if slices.Contains(files[component], relPath) {
+				continue
+			}
+			logger.Info().Str("component", component).Str("file", relPath).Msg("Deleting file no longer generated")
+			if err := removeOutputFile(kr8Spec.ClusterOutputDir, relPath); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return files, nil
+}
+
+// Checks that no two generated files have the same path,
+// and that no file path is also used as a directory.
+func checkOutputCollisions(files map[string][]string) error {
+	owners := map[string]string{}
+	components := make([]string, 0, len(files))
+	for component := range files {
+		components = append(components, component)
+	}
+	sort.Strings(components)
+	for _, component := range components {
+		for _, relPath := range files[component] {
+			if owner, found := owners[relPath]; found {
+				return types.Kr8Error{
+					Message: "`output_layout` renders the same path for components " + owner + " and " + component,
+					Value:   relPath,
+				}
+			}
+			owners[relPath] = component
+		}
+	}
+	for relPath, owner := range owners {
+		for dir := path.Dir(relPath); dir != "."; dir = path.Dir(dir) {
+			if other, found := owners[dir]; found {
+				return types.Kr8Error{
+					Message: "`output_layout` renders a file path of component " + other +
+						" that is a directory for component " + owner,
+					Value: dir,
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// Checks if a path relative to the cluster output directory is in the ArgoCD output directory.
+func isArgoCDOutput(kr8Spec kr8_types.Kr8ClusterSpec, relPath string) bool {
+	if kr8Spec.ArgoCD == nil {
+		return false
+	}
+	argoDir, err := filepath.Rel(kr8Spec.ClusterOutputDir, kr8Spec.ArgoCD.OutputDir)
+	if err != nil || strings.HasPrefix(argoDir, "..") {
+		return false
+	}
+	relPath = filepath.FromSlash(relPath)
+
+	return relPath == argoDir || strings.HasPrefix(relPath, argoDir+string(filepath.Separator))
+}
+
+// Removes a generated file, and any parent directories left empty within the cluster output directory.
+// Paths outside the cluster output directory are an error.
+func removeOutputFile(clusterOutputDir string, relPath string) error {
+	if !filepath.IsLocal(filepath.FromSlash(relPath)) {
+		return types.Kr8Error{Message: "refusing to remove a file outside the cluster output directory", Value: relPath}
+	}
+	file := filepath.Join(clusterOutputDir, filepath.FromSlash(relPath))
+	if err := os.RemoveAll(file); err != nil {
+		return util.ErrorIfCheck("error removing "+relPath, err)
+	}
+	for dir := filepath.Dir(file); dir != clusterOutputDir && strings.HasPrefix(dir, clusterOutputDir); dir = filepath.Dir(dir) {
+		entries, err := os.ReadDir(dir)
+		if err != nil || len(entries) > 0 {
+			break
+		}
+		if err := os.Remove(dir); err != nil {
+			break
+		}
+	}
+
+	return nil
+}
+
+// Lists the generated files of a component.
+// Uses the planned output files if the cluster has an `output_layout`, otherwise the component output directory.
+// Files that don't exist are skipped.
+func componentOutputFiles(kr8Spec kr8_types.Kr8ClusterSpec, component string) ([]string, error) {
+	if kr8Spec.OutputFiles == nil {
+		componentDir := filepath.Join(kr8Spec.ClusterOutputDir, component)
+		if _, err := os.Stat(componentDir); os.IsNotExist(err) {
+			return []string{}, nil
+		}
+
+		return util.BuildDirFileList(componentDir)
+	}
+	files := []string{}
+	for _, relPath := range kr8Spec.OutputFiles[component] {
+		file := filepath.Join(kr8Spec.ClusterOutputDir, filepath.FromSlash(relPath))
+		if _, err := os.Stat(file); err == nil {
+			files = append(files, file)
+		}
+	}
+
+	return files, nil
+}
+
+// Loads the Kubernetes objects generated for a component, following the cluster's `output_layout`.
+func loadComponentObjects(
+	kr8Spec kr8_types.Kr8ClusterSpec,
+	component string,
+) ([]kr8_check.Object, []kr8_check.Finding, error) {
+	files, err := componentOutputFiles(kr8Spec, component)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return kr8_check.LoadFileObjects(kr8Spec.ClusterOutputDir, component, files)
+}
+
+// Loads the Kubernetes objects generated for a cluster, following the cluster's `output_layout`.
+func loadGeneratedObjects(kr8Spec kr8_types.Kr8ClusterSpec) ([]kr8_check.Object, []kr8_check.Finding, error) {
+	if kr8Spec.OutputFiles == nil {
+		return kr8_check.LoadGeneratedObjects(kr8Spec.ClusterOutputDir)
+	}
+	components := make([]string, 0, len(kr8Spec.OutputFiles))
+	for component := range kr8Spec.OutputFiles {
+		components = append(components, component)
+	}
+	sort.Strings(components)
+	objects := []kr8_check.Object{}
+	findings := []kr8_check.Finding{}
+	for _, component := range components {
+		compObjects, compFindings, err := loadComponentObjects(kr8Spec, component)
+		if err != nil {
+			return nil, nil, err
+		}
+		objects = append(objects, compObjects...)
+		findings = append(findings, compFindings...)
+	}
+
+	return objects, findings, nil
+}
@@ -1,14 +1,17 @@
 package generate
 
 import (
+	"encoding/json"
 	"os"
 	"path/filepath"
 	"strings"
 
 	jsonnet "github.com/google/go-jsonnet"
 	"github.com/rs/zerolog"
+	"github.com/tidwall/gjson"
 
 	jnetvm "github.com/ice-bergtech/kr8/pkg/jnetvm"
+	"github.com/ice-bergtech/kr8/pkg/kr8_check"
 	"github.com/ice-bergtech/kr8/pkg/kr8_types"
 	types "github.com/ice-bergtech/kr8/pkg/types"
 	util "github.com/ice-bergtech/kr8/pkg/util"
@@ -34,10 +37,15 @@ func SetupJvmForComponent(
 // It sets up post-processing, and prunes parameters as required.
 // It's faster to create this VM for each component, rather than re-use.
 // Default postprocessor just copies input to output.
+// The dependency order of the cluster's components is available under the `kr8_component_order` extVar.
+// Component postprocessors and injection of common labels, annotations and namespaces
+// are chained after the cluster postprocessor, see [BuildPostProcessor].
 func SetupBaseComponentJvm(
 	vmconfig types.VMConfig,
 	config string,
 	kr8Spec kr8_types.Kr8ClusterSpec,
+	componentName string,
+	compSpec kr8_types.Kr8ComponentSpec,
 ) (*jsonnet.VM, error) {
 	jvm, err := jnetvm.JsonnetVM(vmconfig)
 	if err != nil {
@@ -45,12 +53,27 @@ func SetupBaseComponentJvm(
 	}
 	jvm.ExtCode("kr8_cluster", "std.prune("+config+"._cluster)")
 
-	if kr8Spec.PostProcessor != "" {
-		jvm.ExtCode("process", kr8Spec.PostProcessor)
-	} else {
-		// Default PostProcessor passes input to output
-		jvm.ExtCode("process", "function(input) input")
+	process, err := BuildPostProcessor(
+		kr8Spec, compSpec, componentName,
+		gjson.Get(config, componentName+".namespace").String(),
+		filepath.Join(vmconfig.BaseDir, GetComponentPath(config, componentName)),
+	)
+	if err != nil {
+		return nil, err
 	}
+	jvm.ExtCode("process", process)
+	// Metadata of the include being processed, set for each include file
+	jvm.ExtCode("kr8_include", "{}")
+
+	componentOrder := []string{}
+	if kr8Spec.ComponentOrder != nil {
+		componentOrder = kr8Spec.ComponentOrder.Order
+	}
+	orderJSON, err := json.Marshal(componentOrder)
+	if err != nil {
+		return nil, err
+	}
+	jvm.ExtCode("kr8_component_order", string(orderJSON))
 
 	return jvm, nil
 }
@@ -111,3 +134,44 @@ func loadExtFilesIntoVM(
 
 	return nil
 }
+
+// Loads the rendered objects of a component's dependencies into the `kr8_outputs` extVar.
+// Objects are read from each dependency's output directory, keyed by component name.
+// A dependency that has not been generated has an empty list.
+func loadComponentOutputsIntoVM(
+	kr8Spec kr8_types.Kr8ClusterSpec,
+	compSpec kr8_types.Kr8ComponentSpec,
+	jvm *jsonnet.VM,
+	logger zerolog.Logger,
+) error {
+	outputs := make(map[string][]map[string]any, len(compSpec.DependsOn))
+	for _, dependency := range compSpec.DependsOn {
+		outputs[dependency] = []map[string]any{}
+		files, err := componentOutputFiles(kr8Spec, dependency)
+		if err != nil {
+			return err
+		}
+		if len(files) == 0 {
+			logger.Warn().Str("dependency", dependency).Msg("dependency has no generated output")
+
+			continue
+		}
+		objects, findings, err := kr8_check.LoadFileObjects(kr8Spec.ClusterOutputDir, dependency, files)
+		if err != nil {
+			return err
+		}
+		for _, finding := range findings {
+			logger.Warn().Str("dependency", dependency).Str("file", finding.File).Msg(finding.Message)
+		}
+		for _, object := range objects {
+			outputs[dependency] = append(outputs[dependency], object.Data)
+		}
+	}
+	outputsJSON, err := json.Marshal(outputs)
+	if err != nil {
+		return err
+	}
+	jvm.ExtCode("kr8_outputs", string(outputsJSON))
+
+	return nil
+}
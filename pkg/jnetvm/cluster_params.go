@@ -0,0 +1,433 @@
+package jnetvm
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	jsonnet "github.com/google/go-jsonnet"
+	"github.com/tidwall/gjson"
+
+	"github.com/ice-bergtech/kr8/pkg/kr8_types"
+	types "github.com/ice-bergtech/kr8/pkg/types"
+	util "github.com/ice-bergtech/kr8/pkg/util"
+)
+
+// Directory in the base directory containing cluster profiles.
+const ProfileDir = "profiles"
+
+// Lists the files merged to build a cluster's params, in merge order.
+// The `params.jsonnet` files of the cluster's parent directories come first, from searchDir down,
+// followed by the profiles listed in `_kr8_spec.profiles`, then the cluster's `cluster.jsonnet`.
+func ClusterParamsFilenames(vmConfig types.VMConfig, searchDir string, clusterName string) ([]string, error) {
+	clusterPath, err := util.GetClusterPath(searchDir, clusterName, ClusterMatrixLoader(vmConfig))
+	if err != nil {
+		return nil, err
+	}
+	params := util.GetClusterParamsFilenames(searchDir, clusterPath)
+
+	profiles, err := clusterProfiles(vmConfig, params)
+	if err != nil {
+		return nil, util.ErrorIfCheck("error reading profiles of cluster "+clusterName, err)
+	}
+	if len(profiles) == 0 {
+		return params, nil
+	}
+
+	files := make([]string, 0, len(params)+len(profiles))
+	files = append(files, params[:len(params)-1]...)
+	for _, name := range profiles {
+		profilePath, err := ProfilePath(vmConfig.BaseDir, name)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, profilePath)
+	}
+
+	return append(files, params[len(params)-1]), nil
+}
+
+// Returns a loader listing the clusters of a `clusters.jsonnet` matrix, the fields of the object it evaluates to.
+// Hidden fields are left out, so they can hold shared values.
+func ClusterMatrixLoader(vmConfig types.VMConfig) util.ClusterMatrixLoader {
+	return func(file string) ([]string, error) {
+		jvm, err := JsonnetVM(vmConfig)
+		if err != nil {
+			return nil, err
+		}
+		out, err := jvm.EvaluateAnonymousSnippet(
+			"clusters",
+			fmt.Sprintf(`local clusters = import '%s';
+if std.isObject(clusters) then std.objectFields(clusters)
+else error 'must evaluate to an object of cluster name to cluster object'`, file),
+		)
+		if err != nil {
+			return nil, err
+		}
+		var names []string
+		if err := json.Unmarshal([]byte(out), &names); err != nil {
+			return nil, err
+		}
+
+		return names, nil
+	}
+}
+
+// Returns the jsonnet importing a params chain entry.
+// Matrix clusters import their field of the matrix file.
+func importParamsFile(entry string) string {
+	file, cluster := util.SplitClusterParamsEntry(entry)
+	if cluster == "" {
+		return fmt.Sprintf("(import '%s')", file)
+	}
+	name, _ := json.Marshal(cluster)
+
+	return fmt.Sprintf("(import '%s')[%s]", file, name)
+}
+
+// Returns the path of a profile file in the base directory.
+// Profile names may contain subdirectories, such as `cloud/aws`, but must stay within the profile directory.
+func ProfilePath(baseDir string, name string) (string, error) {
+	if name == "" || !filepath.IsLocal(name) {
+		return "", types.Kr8Error{Message: "invalid profile name", Value: name}
+	}
+	profilePath := filepath.Join(baseDir, ProfileDir, name+".jsonnet")
+	if _, err := os.Stat(profilePath); err != nil {
+		return "", types.Kr8Error{Message: "profile not found: " + name, Value: profilePath}
+	}
+
+	return profilePath, nil
+}
+
+// Reads `_kr8_spec.profiles` from a cluster's params files.
+// Only the directory params and cluster file are read, so profiles can't list further profiles.
+func clusterProfiles(vmConfig types.VMConfig, params []string) ([]string, error) {
+	imports := make([]string, len(params))
+	for idx, file := range params {
+		imports[idx] = importParamsFile(file)
+	}
+	jvm, err := JsonnetVM(vmConfig)
+	if err != nil {
+		return nil, err
+	}
+	out, err := jvm.EvaluateAnonymousSnippet(
+		"profiles",
+		"std.get(std.get("+strings.Join(imports, "+")+", '_kr8_spec', {}), 'profiles', [])",
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var profiles []string
+	if err := json.Unmarshal([]byte(out), &profiles); err != nil {
+		return nil, types.Kr8Error{Message: "`_kr8_spec.profiles` must be a list of profile names", Value: err}
+	}
+	seen := make(map[string]bool, len(profiles))
+	for _, name := range profiles {
+		if seen[name] {
+			return nil, types.Kr8Error{Message: "profile listed more than once", Value: name}
+		}
+		seen[name] = true
+	}
+
+	return profiles, nil
+}
+
+// A parameter of a cluster's params, with the file that set it.
+type ParamTrace struct {
+	// gjson path of the parameter
+	Path string `json:"path"`
+	// Value of the parameter, as compact json
+	Value string `json:"value"`
+	// File that set the parameter's value, relative to the base directory
+	Source string `json:"source"`
+	// Values set by earlier files and overridden, in merge order
+	Overridden []ParamValue `json:"overridden,omitempty"`
+}
+
+// A value of a parameter, with the file that set it.
+type ParamValue struct {
+	// Value of the parameter, as compact json.
+	// Empty if the value can't be evaluated without later files
+	Value string `json:"value"`
+	// File that set the value, relative to the base directory
+	Source string `json:"source"`
+}
+
+// A leaf of rendered params: a value that isn't a non-empty object.
+type paramLeaf struct {
+	keys  []string
+	path  string
+	value string
+}
+
+// A layer of the params merge: the component defaults, or a params file.
+type traceLayer struct {
+	// Jsonnet code of the layer
+	code string
+	// File of the layer. Empty for component defaults, which come from each component's `params.jsonnet`
+	file string
+}
+
+// Traces where each parameter of a cluster's params was set.
+// The layers of the params are the component defaults, the params of each component instance,
+// each params file of the cluster, its profiles, its cluster file
+// and clusterParams, an optional file merged last as with `--clusterparams`.
+// If there are component instances or sources, the `_components` entries they change are the last layer.
+// The source of a parameter is the last layer that sets it,
+// and the values after each earlier layer that set it are listed as overridden.
+// An empty componentNames list merges the defaults of all components.
+// Returns the parameters sorted by path.
+func TraceClusterParams(
+	vmConfig types.VMConfig,
+	clusterName string,
+	componentNames []string,
+	clusterParams string,
+) ([]ParamTrace, error) {
+	var files []string
+	if clusterName != "" {
+		var err error
+		files, err = ClusterParamsFilenames(vmConfig, vmConfig.BaseDir, clusterName)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if clusterParams != "" {
+		files = append(files, clusterParams)
+	}
+	if len(files) == 0 {
+		return nil, types.Kr8Error{Message: "Please specify a --cluster name and/or --clusterparams", Value: ""}
+	}
+
+	componentMap, err := RenderClusterComponents(vmConfig, files, "clusterparams", false)
+	if err != nil {
+		return nil, err
+	}
+	// Instance params are traced as layers of their own
+	defaultsMap := make(map[string]kr8_types.Kr8ClusterComponentRef, len(componentMap))
+	instanceLayers := []traceLayer{}
+	for name, ref := range componentMap {
+		if len(ref.Params) > 0 && (len(componentNames) == 0 || slices.Contains(componentNames, name)) {
+			instanceLayers = append(instanceLayers, traceLayer{
+				code: fmt.Sprintf("{ %s+: %s }", util.QuoteJsonnet(name), ref.Params),
+				file: "_components." + ref.InstanceOf + ".instances." + name,
+			})
+		}
+		ref.Params = nil
+		defaultsMap[name] = ref
+	}
+	sort.Slice(instanceLayers, func(a, b int) bool { return instanceLayers[a].file < instanceLayers[b].file })
+	componentDefaults, err := MergeComponentDefaults(defaultsMap, componentNames, vmConfig)
+	if err != nil {
+		return nil, util.ErrorIfCheck("failed to merge component defaults", err)
+	}
+	patch, err := componentsPatch(componentMap)
+	if err != nil {
+		return nil, err
+	}
+
+	layers := make([]traceLayer, 0, len(files)+len(instanceLayers)+2) //nolint:mnd
+	layers = append(layers, traceLayer{code: componentDefaults, file: ""})
+	layers = append(layers, instanceLayers...)
+	for _, file := range files {
+		layers = append(layers, traceLayer{code: importParamsFile(file), file: file})
+	}
+	if patch != "" {
+		layers = append(layers, traceLayer{code: strings.TrimPrefix(patch, "+ "), file: "expanded _components"})
+	}
+
+	jvm, err := JsonnetVM(vmConfig)
+	if err != nil {
+		return nil, err
+	}
+	rendered, err := jvm.EvaluateAnonymousSnippet("component params", joinLayers(layers))
+	if err := util.ErrorIfCheck("Error evaluating jsonnet snippet", err); err != nil {
+		return nil, err
+	}
+	leaves, err := flattenParams(gjson.Parse(rendered), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	history := make(map[string][]ParamValue, len(leaves))
+	for idx, layer := range layers {
+		set := layerSets(jvm, layer.code, leaves)
+		if len(set) == 0 {
+			continue
+		}
+		var values map[string]string
+		if idx == len(layers)-1 {
+			values = make(map[string]string, len(leaves))
+			for _, leaf := range leaves {
+				values[leaf.path] = leaf.value
+			}
+		} else {
+			values, err = layerValues(jvm, joinLayers(layers[:idx+1]), set)
+			if err != nil {
+				return nil, err
+			}
+		}
+		for _, leaf := range set {
+			source := layer.file
+			if source == "" {
+				source = filepath.Join(vmConfig.BaseDir, componentMap[leaf.keys[0]].Path, "params.jsonnet")
+			}
+			if rel, err := filepath.Rel(vmConfig.BaseDir, source); err == nil {
+				source = rel
+			}
+			history[leaf.path] = append(history[leaf.path], ParamValue{Value: values[leaf.path], Source: source})
+		}
+	}
+
+	trace := make([]ParamTrace, 0, len(leaves))
+	for _, leaf := range leaves {
+		param := ParamTrace{Path: leaf.path, Value: leaf.value, Source: "", Overridden: nil}
+		if values := history[leaf.path]; len(values) > 0 {
+			last := len(values) - 1
+			param.Source = values[last].Source
+			if last > 0 {
+				param.Overridden = values[:last]
+			}
+		}
+		trace = append(trace, param)
+	}
+	sort.Slice(trace, func(a, b int) bool { return trace[a].Path < trace[b].Path })
+
+	return trace, nil
+}
+
+// Merges the code of params layers.
+func joinLayers(layers []traceLayer) string {
+	codes := make([]string, len(layers))
+	for idx, layer := range layers {
+		codes[idx] = layer.code
+	}
+
+	return strings.Join(codes, "+")
+}
+
+// Jsonnet function checking if an object has a path, without evaluating the value at the path.
+const hasPathFunc = "local has(o, keys) = std.isObject(o) && std.objectHas(o, keys[0]) " +
+	"&& (std.length(keys) == 1 || has(o[keys[0]], keys[1:]));"
+
+// Finds the leaves a layer sets on its own, whether or not the value depends on other layers.
+// If the layer can't be checked for every leaf at once, each leaf is checked separately,
+// and leaves that fail to check are treated as not set.
+func layerSets(jvm *jsonnet.VM, code string, leaves []paramLeaf) []paramLeaf {
+	keys := make([][]string, len(leaves))
+	for idx, leaf := range leaves {
+		keys[idx] = leaf.keys
+	}
+	keysJSON, err := json.Marshal(keys)
+	if err != nil {
+		return nil
+	}
+
+	set := []paramLeaf{}
+	out, err := jvm.EvaluateAnonymousSnippet("clusterparams",
+		"local layer = "+code+"; "+hasPathFunc+" [has(layer, keys) for keys in "+string(keysJSON)+"]")
+	if err == nil {
+		for idx, result := range gjson.Parse(out).Array() {
+			if result.Bool() {
+				set = append(set, leaves[idx])
+			}
+		}
+
+		return set
+	}
+
+	for _, leaf := range leaves {
+		leafKeys, err := json.Marshal(leaf.keys)
+		if err != nil {
+			continue
+		}
+		out, err := jvm.EvaluateAnonymousSnippet("clusterparams",
+			"local layer = "+code+"; "+hasPathFunc+" has(layer, "+string(leafKeys)+")")
+		if err == nil && gjson.Parse(out).Bool() {
+			set = append(set, leaf)
+		}
+	}
+
+	return set
+}
+
+// Finds the value of leaves after merging the first layers of the params.
+// If the merged layers don't render on their own, for example because they reference a field set by a later file,
+// each leaf is evaluated separately. Leaves that fail to evaluate are left out.
+func layerValues(jvm *jsonnet.VM, code string, leaves []paramLeaf) (map[string]string, error) {
+	values := map[string]string{}
+	rendered, err := jvm.EvaluateAnonymousSnippet("clusterparams", code)
+	if err == nil {
+		layerLeaves, err := flattenParams(gjson.Parse(rendered), nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		for _, leaf := range layerLeaves {
+			values[leaf.path] = leaf.value
+		}
+
+		return values, nil
+	}
+
+	for _, leaf := range leaves {
+		keys, err := json.Marshal(leaf.keys)
+		if err != nil {
+			return nil, err
+		}
+		out, err := jvm.EvaluateAnonymousSnippet("clusterparams",
+			"std.foldl(function(o, key) o[key], "+string(keys)+", "+code+")")
+		if err != nil {
+			continue
+		}
+		value, err := compactJSON(out)
+		if err != nil {
+			return nil, err
+		}
+		values[leaf.path] = value
+	}
+
+	return values, nil
+}
+
+// Lists the leaves of rendered params.
+// Objects are descended into, while arrays, empty objects and scalar values are leaves.
+func flattenParams(value gjson.Result, keys []string, leaves []paramLeaf) ([]paramLeaf, error) {
+	if value.IsObject() && len(value.Map()) > 0 {
+		var err error
+		value.ForEach(func(key, child gjson.Result) bool {
+			childKeys := append(append([]string{}, keys...), key.String())
+			leaves, err = flattenParams(child, childKeys, leaves)
+
+			return err == nil
+		})
+
+		return leaves, err
+	}
+	if len(keys) == 0 {
+		return leaves, nil
+	}
+	compact, err := compactJSON(value.Raw)
+	if err != nil {
+		return nil, err
+	}
+	escaped := make([]string, len(keys))
+	for idx, key := range keys {
+		escaped[idx] = gjson.Escape(key)
+	}
+
+	return append(leaves, paramLeaf{keys: keys, path: strings.Join(escaped, "."), value: compact}), nil
+}
+
+func compactJSON(raw string) (string, error) {
+	var buffer bytes.Buffer
+	if err := json.Compact(&buffer, []byte(raw)); err != nil {
+		return "", util.ErrorIfCheck("error formatting param value", err)
+	}
+
+	return buffer.String(), nil
+}
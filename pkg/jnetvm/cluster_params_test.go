@@ -0,0 +1,230 @@
+package jnetvm_test
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ice-bergtech/kr8/pkg/jnetvm"
+	types "github.com/ice-bergtech/kr8/pkg/types"
+)
+
+func writeTestFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	baseDir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(baseDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return baseDir
+}
+
+func TestClusterParamsFilenames(t *testing.T) {
+	baseDir := writeTestFiles(t, map[string]string{
+		"clusters/params.jsonnet":              `{ _cluster: { tier: 'base' } }`,
+		"clusters/prod/params.jsonnet":         `{ _kr8_spec+: { profiles: ['gpu'] } }`,
+		"clusters/prod/prod1/cluster.jsonnet":  `{ _kr8_spec+: { profiles+: ['mesh/istio'] } }`,
+		"clusters/dev/dev1/cluster.jsonnet":    `{}`,
+		"clusters/dev/missing/cluster.jsonnet": `{ _kr8_spec: { profiles: ['absent'] } }`,
+		"clusters/dev/escape/cluster.jsonnet":  `{ _kr8_spec: { profiles: ['../clusters/params'] } }`,
+		"clusters/dev/twice/cluster.jsonnet":   `{ _kr8_spec: { profiles: ['gpu', 'gpu'] } }`,
+		"clusters/edge/clusters.jsonnet":       `{ 'edge-01': { _kr8_spec: { profiles: ['gpu'] } }, shared:: {} }`,
+		"profiles/gpu.jsonnet":                 `{}`,
+		"profiles/mesh/istio.jsonnet":          `{}`,
+	})
+	//nolint:exhaustruct
+	vmConfig := types.VMConfig{BaseDir: baseDir}
+	clusterDir := filepath.Join(baseDir, "clusters")
+
+	tests := []struct {
+		name    string
+		cluster string
+		want    []string
+		wantErr string
+	}{
+		{
+			name:    "no profiles",
+			cluster: "dev1",
+			want:    []string{"clusters/params.jsonnet", "clusters/dev/dev1/cluster.jsonnet"},
+		},
+		{
+			name:    "profiles before cluster file",
+			cluster: "prod1",
+			want: []string{
+				"clusters/params.jsonnet",
+				"clusters/prod/params.jsonnet",
+				"profiles/gpu.jsonnet",
+				"profiles/mesh/istio.jsonnet",
+				"clusters/prod/prod1/cluster.jsonnet",
+			},
+		},
+		{
+			name:    "matrix cluster",
+			cluster: "edge-01",
+			want: []string{
+				"clusters/params.jsonnet",
+				"profiles/gpu.jsonnet",
+				"clusters/edge/clusters.jsonnet#edge-01",
+			},
+		},
+		{name: "hidden matrix field", cluster: "shared", wantErr: "could not find cluster"},
+		{name: "missing profile", cluster: "missing", wantErr: "profile not found: absent"},
+		{name: "profile outside profile directory", cluster: "escape", wantErr: "invalid profile name"},
+		{name: "duplicate profile", cluster: "twice", wantErr: "profile listed more than once"},
+	}
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			got, err := jnetvm.ClusterParamsFilenames(vmConfig, clusterDir, testCase.cluster)
+			if testCase.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), testCase.wantErr) {
+					t.Fatalf("ClusterParamsFilenames() error = %v, want %q", err, testCase.wantErr)
+				}
+
+				return
+			}
+			if err != nil {
+				t.Fatalf("ClusterParamsFilenames() error = %v", err)
+			}
+			for idx, file := range got {
+				rel, err := filepath.Rel(baseDir, file)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got[idx] = filepath.ToSlash(rel)
+			}
+			if !reflect.DeepEqual(got, testCase.want) {
+				t.Errorf("ClusterParamsFilenames() = %v, want %v", got, testCase.want)
+			}
+		})
+	}
+}
+
+func TestTraceClusterParams(t *testing.T) {
+	baseDir := writeTestFiles(t, map[string]string{
+		"clusters/params.jsonnet": `{
+  _cluster: { tier: 'base' },
+  _components: { web: { path: 'components/web' } },
+  web+: { host: $._cluster.name + '.example.com' },
+}`,
+		"clusters/prod/prod1/cluster.jsonnet": `{
+  _kr8_spec: { profiles: ['gpu'] },
+  _cluster+: { name: 'prod1', tier: 'prod' },
+}`,
+		"components/web/params.jsonnet": `{ replicas: 1, port: 8080, host: 'localhost' }`,
+		"profiles/gpu.jsonnet":          `{ _cluster+: { tier: 'gpu' }, web+: { replicas: 3 } }`,
+		"override.jsonnet":              `{ web+: { replicas: 2, host: 'web.internal' } }`,
+	})
+	//nolint:exhaustruct
+	vmConfig := types.VMConfig{BaseDir: baseDir}
+
+	const (
+		defaults = "components/web/params.jsonnet"
+		params   = "clusters/params.jsonnet"
+		cluster  = "clusters/prod/prod1/cluster.jsonnet"
+		profile  = "profiles/gpu.jsonnet"
+	)
+	chain := map[string]jnetvm.ParamTrace{
+		"_cluster.name": {Path: "_cluster.name", Value: `"prod1"`, Source: cluster, Overridden: nil},
+		"_cluster.tier": {
+			Path: "_cluster.tier", Value: `"prod"`, Source: cluster,
+			Overridden: []jnetvm.ParamValue{{Value: `"base"`, Source: params}, {Value: `"gpu"`, Source: profile}},
+		},
+		"_components.web.path": {Path: "_components.web.path", Value: `"components/web"`, Source: params, Overridden: nil},
+		"_kr8_spec.profiles":   {Path: "_kr8_spec.profiles", Value: `["gpu"]`, Source: cluster, Overridden: nil},
+		"web.host": {
+			Path: "web.host", Value: `"prod1.example.com"`, Source: params,
+			Overridden: []jnetvm.ParamValue{{Value: `"localhost"`, Source: defaults}},
+		},
+		"web.port": {Path: "web.port", Value: "8080", Source: defaults, Overridden: nil},
+		"web.replicas": {
+			Path: "web.replicas", Value: "3", Source: profile,
+			Overridden: []jnetvm.ParamValue{{Value: "1", Source: defaults}},
+		},
+	}
+	withOverride := map[string]jnetvm.ParamTrace{}
+	for path, param := range chain {
+		withOverride[path] = param
+	}
+	withOverride["web.replicas"] = jnetvm.ParamTrace{
+		Path: "web.replicas", Value: "2", Source: "override.jsonnet",
+		Overridden: []jnetvm.ParamValue{{Value: "1", Source: defaults}, {Value: "3", Source: profile}},
+	}
+	// The value set by params.jsonnet can't be evaluated before the cluster file sets the name
+	withOverride["web.host"] = jnetvm.ParamTrace{
+		Path: "web.host", Value: `"web.internal"`, Source: "override.jsonnet",
+		Overridden: []jnetvm.ParamValue{{Value: `"localhost"`, Source: defaults}, {Value: "", Source: params}},
+	}
+
+	tests := []struct {
+		name          string
+		clusterParams string
+		want          map[string]jnetvm.ParamTrace
+	}{
+		{name: "cluster params chain", clusterParams: "", want: chain},
+		{name: "cluster params override", clusterParams: "override.jsonnet", want: withOverride},
+	}
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			clusterParams := ""
+			if testCase.clusterParams != "" {
+				clusterParams = filepath.Join(baseDir, testCase.clusterParams)
+			}
+			trace, err := jnetvm.TraceClusterParams(vmConfig, "prod1", nil, clusterParams)
+			if err != nil {
+				t.Fatalf("TraceClusterParams() error = %v", err)
+			}
+			got := map[string]jnetvm.ParamTrace{}
+			for _, param := range trace {
+				got[param.Path] = param
+			}
+			if !reflect.DeepEqual(got, testCase.want) {
+				t.Errorf("TraceClusterParams() = %+v, want %+v", got, testCase.want)
+			}
+		})
+	}
+}
+
+func TestTraceClusterParamsInstances(t *testing.T) {
+	baseDir := writeTestFiles(t, map[string]string{
+		"clusters/c1/cluster.jsonnet": `{
+  _components: { tenant: { path: 'components/tenant', instances: { acme: { params: { replicas: 3 } } } } },
+}`,
+		"components/tenant/params.jsonnet": `{ replicas: 1, host: 'default' }`,
+	})
+	//nolint:exhaustruct
+	vmConfig := types.VMConfig{BaseDir: baseDir}
+
+	trace, err := jnetvm.TraceClusterParams(vmConfig, "c1", nil, "")
+	if err != nil {
+		t.Fatalf("TraceClusterParams() error = %v", err)
+	}
+	got := map[string]jnetvm.ParamTrace{}
+	for _, param := range trace {
+		got[param.Path] = param
+	}
+	const defaults = "components/tenant/params.jsonnet"
+	want := map[string]jnetvm.ParamTrace{
+		"_components.acme.instance_of": {
+			Path: "_components.acme.instance_of", Value: `"tenant"`, Source: "expanded _components", Overridden: nil,
+		},
+		"_components.acme.path": {
+			Path: "_components.acme.path", Value: `"components/tenant"`, Source: "expanded _components", Overridden: nil,
+		},
+		"acme.host": {Path: "acme.host", Value: `"default"`, Source: defaults, Overridden: nil},
+		"acme.replicas": {
+			Path: "acme.replicas", Value: "3", Source: "_components.tenant.instances.acme",
+			Overridden: []jnetvm.ParamValue{{Value: "1", Source: defaults}},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("TraceClusterParams() = %+v, want %+v", got, want)
+	}
+}
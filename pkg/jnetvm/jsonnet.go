@@ -26,6 +26,8 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
+	"sort"
 	"strings"
 
 	//nolint:exptostd
@@ -37,6 +39,7 @@ import (
 	"github.com/tidwall/gjson"
 
 	"github.com/ice-bergtech/kr8/pkg/kr8_native_funcs"
+	"github.com/ice-bergtech/kr8/pkg/kr8_source"
 	"github.com/ice-bergtech/kr8/pkg/kr8_types"
 	types "github.com/ice-bergtech/kr8/pkg/types"
 	util "github.com/ice-bergtech/kr8/pkg/util"
@@ -81,6 +84,7 @@ func JsonnetVM(vmConfig types.VMConfig) (*jsonnet.VM, error) {
 
 // Takes a list of jsonnet files and imports each one.
 // Formats the string for jsonnet using "+".
+// param is appended to the merged files, such as `._components` to select a field.
 // source is only used for error messages.
 func JsonnetRenderFiles(
 	vmConfig types.VMConfig,
@@ -96,7 +100,7 @@ func JsonnetRenderFiles(
 
 	// range through the files
 	for idx, s := range files {
-		jsonnetPaths[idx] = fmt.Sprintf("(import '%s')", s)
+		jsonnetPaths[idx] = importParamsFile(s)
 	}
 
 	// Create a JSonnet VM
@@ -205,11 +209,11 @@ func JsonnetRenderClusterParamsOnly(
 ) (string, error) {
 	var params []string
 	if clusterName != "" {
-		clusterPath, err := util.GetClusterPath(vmConfig.BaseDir, clusterName)
+		var err error
+		params, err = ClusterParamsFilenames(vmConfig, vmConfig.BaseDir, clusterName)
 		if err != nil {
 			return "", err
 		}
-		params = util.GetClusterParamsFilenames(vmConfig.BaseDir, clusterPath)
 	}
 	if clusterParams != "" {
 		params = append(params, clusterParams)
@@ -233,27 +237,20 @@ func JsonnetRenderClusterParams(
 	}
 
 	var params []string
-	var componentMap map[string]kr8_types.Kr8ClusterComponentRef
 
 	if clusterName != "" {
-		clusterPath, err := util.GetClusterPath(vmConfig.BaseDir, clusterName)
+		var err error
+		params, err = ClusterParamsFilenames(vmConfig, vmConfig.BaseDir, clusterName)
 		if err != nil {
 			return "", err
 		}
-		params = util.GetClusterParamsFilenames(vmConfig.BaseDir, clusterPath)
 	}
 	if clusterParams != "" {
 		params = append(params, clusterParams)
 	}
 
-	compParams, err := JsonnetRenderFiles(vmConfig, params, "", true, "", "clusterparams", lint)
-	if err := util.ErrorIfCheck("failed to render cluster params", err); err != nil {
-		return "", err
-	}
-
-	compString := gjson.Get(compParams, "_components")
-	err = json.Unmarshal([]byte(compString.String()), &componentMap)
-	if err := util.ErrorIfCheck("failed to parse component map", err); err != nil {
+	componentMap, err := RenderClusterComponents(vmConfig, params, "clusterparams", lint)
+	if err != nil {
 		return "", err
 	}
 
@@ -262,8 +259,146 @@ func JsonnetRenderClusterParams(
 	if err != nil {
 		return "", util.ErrorIfCheck("failed to merge component defaults", err)
 	}
+	patch, err := componentsPatch(componentMap)
+	if err != nil {
+		return "", err
+	}
+
+	return JsonnetRenderFiles(vmConfig, params, patch, prune, componentDefaultsMerged, "component params", lint)
+}
+
+// Renders the `_components` of a cluster's params files, with component instances expanded,
+// and the path of components with a source resolved from the sources lockfile and component cache.
+// Entries that are null or empty are left out, as when pruned.
+// source is only used for error messages.
+func RenderClusterComponents(
+	vmConfig types.VMConfig,
+	files []string,
+	source string,
+	lint bool,
+) (map[string]kr8_types.Kr8ClusterComponentRef, error) {
+	componentMap, err := renderComponentRefs(vmConfig, files, source, lint)
+	if err != nil {
+		return nil, err
+	}
+
+	return componentMap, resolveComponentSources(vmConfig.BaseDir, componentMap)
+}
+
+// Lists the sources of a cluster's components, sorted and without duplicates.
+func ClusterComponentSources(vmConfig types.VMConfig, clusterName string) ([]string, error) {
+	params, err := ClusterParamsFilenames(vmConfig, vmConfig.BaseDir, clusterName)
+	if err != nil {
+		return nil, err
+	}
+	componentMap, err := renderComponentRefs(vmConfig, params, clusterName+": ._components", false)
+	if err != nil {
+		return nil, err
+	}
+	sources := []string{}
+	for _, ref := range componentMap {
+		if ref.Source != "" && !slices.Contains(sources, ref.Source) {
+			sources = append(sources, ref.Source)
+		}
+	}
+	sort.Strings(sources)
 
-	return JsonnetRenderFiles(vmConfig, params, "", prune, componentDefaultsMerged, "component params", lint)
+	return sources, nil
+}
+
+// Sets the path of components with a source to their directory in the component cache.
+// Nothing is fetched, so the sources must have been fetched with `kr8 sources fetch`.
+func resolveComponentSources(baseDir string, componentMap map[string]kr8_types.Kr8ClusterComponentRef) error {
+	var lock *kr8_source.Lock
+	for name, ref := range componentMap {
+		if ref.Source == "" {
+			continue
+		}
+		if ref.Path != "" {
+			return types.Kr8Error{Message: "component " + name + " sets both a path and a source", Value: ref.Source}
+		}
+		if lock == nil {
+			var err error
+			if lock, err = kr8_source.LoadLock(baseDir); err != nil {
+				return err
+			}
+		}
+		source, err := kr8_source.ParseSource(ref.Source)
+		if err != nil {
+			return err
+		}
+		ref.Path, err = lock.ComponentPath(baseDir, source)
+		if err != nil {
+			return util.ErrorIfCheck("error resolving the source of component "+name, err)
+		}
+		componentMap[name] = ref
+	}
+
+	return nil
+}
+
+// Renders the `_components` of a cluster's params files, with component instances expanded.
+func renderComponentRefs(
+	vmConfig types.VMConfig,
+	files []string,
+	source string,
+	lint bool,
+) (map[string]kr8_types.Kr8ClusterComponentRef, error) {
+	// Not pruned, so an empty list of instances is kept
+	rendered, err := JsonnetRenderFiles(vmConfig, files, "._components", false, "", source, lint)
+	if err := util.ErrorIfCheck("failed to render cluster params", err); err != nil {
+		return nil, err
+	}
+	componentMap := map[string]kr8_types.Kr8ClusterComponentRef{}
+	for name, value := range gjson.Parse(rendered).Map() {
+		if value.Type == gjson.Null || (value.IsObject() && len(value.Map()) == 0) {
+			continue
+		}
+		var ref kr8_types.Kr8ClusterComponentRef
+		err := json.Unmarshal([]byte(value.Raw), &ref)
+		if err := util.ErrorIfCheck("failed to parse component map", err); err != nil {
+			return nil, err
+		}
+		componentMap[name] = ref
+	}
+
+	return kr8_types.ExpandComponentInstances(componentMap)
+}
+
+// Returns the jsonnet merged over the cluster params to replace components with their instances in `_components`,
+// and set the path of components with a source.
+// Components with instances are hidden, so they aren't processed.
+// Returns an empty string if there are no instances or sources.
+func componentsPatch(componentMap map[string]kr8_types.Kr8ClusterComponentRef) (string, error) {
+	names := make([]string, 0, len(componentMap))
+	for name, ref := range componentMap {
+		if ref.InstanceOf != "" || ref.Source != "" {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return "", nil
+	}
+	sort.Strings(names)
+
+	var patch strings.Builder
+	patch.WriteString("+ { _components+: {")
+	hidden := map[string]bool{}
+	for _, name := range names {
+		ref := componentMap[name]
+		if ref.InstanceOf != "" && !hidden[ref.InstanceOf] {
+			hidden[ref.InstanceOf] = true
+			fmt.Fprintf(&patch, "%s:: null,", util.QuoteJsonnet(ref.InstanceOf))
+		}
+		entry, err := json.Marshal(ref)
+		if err != nil {
+			return "", util.ErrorIfCheck("failed to encode component "+name, err)
+		}
+		fmt.Fprintf(&patch, "%s: %s,", util.QuoteJsonnet(name), entry)
+	}
+	patch.WriteString("} }")
+
+	return patch.String(), nil
 }
 
 func MergeComponentDefaults(
@@ -287,7 +422,12 @@ func MergeComponentDefaults(
 			if err := util.ErrorIfCheck("Error reading file "+path, err); err != nil {
 				return "", err
 			}
-			fmt.Fprintf(&componentDefaultsMerged, "'%s': %s,", key, string(fileC))
+			if len(value.Params) > 0 {
+				// Instance params are merged over the defaults
+				fmt.Fprintf(&componentDefaultsMerged, "'%s': (%s) + %s,", key, string(fileC), string(value.Params))
+			} else {
+				fmt.Fprintf(&componentDefaultsMerged, "'%s': %s,", key, string(fileC))
+			}
 		}
 	}
 	componentDefaultsMerged.WriteString("}")
@@ -0,0 +1,59 @@
+package jnetvm_test
+
+import (
+	"testing"
+
+	"github.com/tidwall/gjson"
+
+	"github.com/ice-bergtech/kr8/pkg/jnetvm"
+	types "github.com/ice-bergtech/kr8/pkg/types"
+)
+
+func TestJsonnetRenderClusterParamsInstances(t *testing.T) {
+	baseDir := writeTestFiles(t, map[string]string{
+		"clusters/c1/cluster.jsonnet": `{
+  _cluster: { name: 'c1' },
+  _components: {
+    web: { path: 'components/web' },
+    tenant: {
+      path: 'components/tenant',
+      labels: { team: 'apps' },
+      instances: { acme: { params: { replicas: 3 } }, globex: {} },
+    },
+  },
+  globex+: { host: 'globex.example.com' },
+}`,
+		"components/web/params.jsonnet":    `{ replicas: 2 }`,
+		"components/tenant/params.jsonnet": `{ replicas: 1, host: 'default' }`,
+	})
+	//nolint:exhaustruct
+	vmConfig := types.VMConfig{BaseDir: baseDir}
+
+	config, err := jnetvm.JsonnetRenderClusterParams(vmConfig, "c1", nil, "", false, false)
+	if err != nil {
+		t.Fatalf("JsonnetRenderClusterParams() error = %v", err)
+	}
+
+	tests := []struct {
+		name string
+		path string
+		want string
+	}{
+		{name: "components", path: "_components.@keys", want: `["acme","globex","web"]`},
+		{name: "instance path", path: "_components.acme.path", want: `"components/tenant"`},
+		{name: "instance of", path: "_components.globex.instance_of", want: `"tenant"`},
+		{name: "instance labels", path: "_components.acme.labels.team", want: `"apps"`},
+		{name: "instance params", path: "acme.replicas", want: "3"},
+		{name: "defaults", path: "globex.replicas", want: "1"},
+		{name: "cluster params override", path: "globex.host", want: `"globex.example.com"`},
+		{name: "other components", path: "web.replicas", want: "2"},
+		{name: "no params for the instanced component", path: "tenant", want: ""},
+	}
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			if got := gjson.Get(config, testCase.path).Raw; got != testCase.want {
+				t.Errorf("gjson.Get(%q) = %s, want %s", testCase.path, got, testCase.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,316 @@
+// Package kr8_bundle packages generated cluster output into reproducible artifacts.
+//
+// A bundle is either a gzipped tarball of the cluster output directory,
+// or an OCI image layout directory that stores the tarball as a layer along with a metadata config.
+// Bundles built from the same generated tree are byte-for-byte identical:
+// entries are sorted, and timestamps, owners and permissions are fixed.
+// OCI layouts can be pushed to any OCI registry with tools such as `oras` or `crane`, no registry is needed to build them.
+package kr8_bundle
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	specs "github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/ice-bergtech/kr8/pkg/kr8_sign"
+	"github.com/ice-bergtech/kr8/pkg/types"
+	"github.com/ice-bergtech/kr8/pkg/util"
+)
+
+const (
+	// Bundle format writing a gzipped tarball per cluster.
+	FormatTar = "tar"
+	// Bundle format writing an OCI image layout directory per cluster.
+	FormatOCI = "oci"
+	// Media type of the metadata config in an OCI bundle.
+	MediaTypeConfig = "application/vnd.kr8.bundle.config.v1+json"
+	// Artifact type of the OCI bundle manifest.
+	ArtifactType = "application/vnd.kr8.bundle.v1"
+)
+
+// Options for bundling generated cluster output.
+type Options struct {
+	// Directory bundles are written to
+	Dir string
+	// Bundle format, `tar` or `oci`
+	Format string
+}
+
+// Metadata describing how a bundle was generated.
+// Stored as the config of OCI bundles.
+type Metadata struct {
+	// Version of kr8+ that generated the output
+	Kr8Version string `json:"kr8_version"`
+	// Name of the cluster
+	Cluster string `json:"cluster"`
+	// Hashes of the inputs used to generate the output
+	Inputs Inputs `json:"inputs"`
+	// Hex encoded sha256 digests of the files in the bundle, by path relative to the cluster output directory.
+	// Set when the bundle is written, from the files added to the tarball
+	Outputs map[string]string `json:"outputs,omitempty"`
+}
+
+// Hashes of the inputs used to generate a cluster.
+// Hashes are hex encoded sha256 digests.
+type Inputs struct {
+	// Hash of the rendered cluster `_kr8_spec` and `_cluster` params
+	Cluster string `json:"cluster"`
+	// Hash of the files in the base `lib` directory
+	Library string `json:"library"`
+	// Hashes of each generated component
+	Components map[string]ComponentInputs `json:"components"`
+}
+
+// Hashes of the inputs used to generate a component.
+type ComponentInputs struct {
+	// Hash of the rendered component params
+	Params string `json:"params"`
+	// Hash of the files in the component directory
+	Files string `json:"files"`
+}
+
+// Validates bundle options.
+func (o Options) Validate() error {
+	if o.Format != FormatTar && o.Format != FormatOCI {
+		return types.Kr8Error{Message: "bundle format must be `tar` or `oci`", Value: o.Format}
+	}
+
+	return nil
+}
+
+// Writes the bundle for a cluster to the bundle directory.
+// Returns the path of the written tarball or OCI layout directory.
+func WriteBundle(options Options, clusterOutputDir string, metadata Metadata) (string, error) {
+	if err := options.Validate(); err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(options.Dir, 0750); err != nil {
+		return "", util.ErrorIfCheck("error creating bundle directory", err)
+	}
+	if options.Format == FormatOCI {
+		layoutDir := filepath.Join(options.Dir, metadata.Cluster)
+
+		return layoutDir, WriteOCILayout(layoutDir, clusterOutputDir, metadata)
+	}
+
+	var buffer bytes.Buffer
+	if err := WriteTarball(&buffer, clusterOutputDir); err != nil {
+		return "", err
+	}
+	tarFile := filepath.Join(options.Dir, metadata.Cluster+".tar.gz")
+
+	return tarFile, os.WriteFile(tarFile, buffer.Bytes(), 0600)
+}
+
+// Writes a reproducible gzipped tarball of a cluster output directory.
+// Entries are sorted by path, with fixed timestamps, owners and permissions.
+// kr8+ bookkeeping files are skipped, except the signed digest manifest.
+func WriteTarball(writer io.Writer, clusterOutputDir string) error {
+	_, err := writeTarball(writer, clusterOutputDir)
+
+	return err
+}
+
+// Writes the tarball of a cluster output directory.
+// Returns the digests of the files added to the tarball.
+func writeTarball(writer io.Writer, clusterOutputDir string) (map[string]string, error) {
+	files, err := util.BuildDirFileList(clusterOutputDir)
+	if err != nil {
+		return nil, err
+	}
+	relPaths := make([]string, 0, len(files))
+	for _, file := range files {
+		relPath, err := filepath.Rel(clusterOutputDir, file)
+		if err != nil {
+			return nil, err
+		}
+		relPath = filepath.ToSlash(relPath)
+		if kr8_sign.IsBookkeepingFile(relPath) &&
+			relPath != kr8_sign.ManifestFile && relPath != kr8_sign.SignatureFile {
+			continue
+		}
+		relPaths = append(relPaths, relPath)
+	}
+	sort.Strings(relPaths)
+
+	gzipWriter, err := gzip.NewWriterLevel(writer, gzip.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	tarWriter := tar.NewWriter(gzipWriter)
+	dirs := map[string]bool{}
+	digests := make(map[string]string, len(relPaths))
+	for _, relPath := range relPaths {
+		// Add parent directory entries before their first file
+		parts := strings.Split(relPath, "/")
+		for idx := 1; idx < len(parts); idx++ {
+			dir := strings.Join(parts[:idx], "/") + "/"
+			if dirs[dir] {
+				continue
+			}
+			dirs[dir] = true
+			if err := tarWriter.WriteHeader(tarHeader(dir, tar.TypeDir, 0755, 0)); err != nil { //nolint:mnd
+				return nil, err
+			}
+		}
+		data, err := os.ReadFile(filepath.Join(clusterOutputDir, filepath.FromSlash(relPath)))
+		if err != nil {
+			return nil, err
+		}
+		if err := tarWriter.WriteHeader(tarHeader(relPath, tar.TypeReg, 0644, int64(len(data)))); err != nil { //nolint:mnd
+			return nil, err
+		}
+		if _, err := tarWriter.Write(data); err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(data)
+		digests[relPath] = hex.EncodeToString(sum[:])
+	}
+	if err := tarWriter.Close(); err != nil {
+		return nil, err
+	}
+
+	return digests, gzipWriter.Close()
+}
+
+// Builds a tar header with fixed timestamps and ownership.
+func tarHeader(name string, typeflag byte, mode int64, size int64) *tar.Header {
+	//nolint:exhaustruct
+	return &tar.Header{
+		Typeflag: typeflag,
+		Name:     name,
+		Mode:     mode,
+		Size:     size,
+		ModTime:  time.Unix(0, 0).UTC(),
+		Format:   tar.FormatPAX,
+	}
+}
+
+// Writes an OCI image layout directory for a cluster.
+// The layout holds a single manifest with the metadata as config, and the output tarball as its only layer.
+// The metadata outputs are set to the digests of the files in the tarball.
+// The manifest is tagged with the cluster name. Any existing layout in layoutDir is replaced.
+func WriteOCILayout(layoutDir string, clusterOutputDir string, metadata Metadata) error {
+	if err := os.RemoveAll(layoutDir); err != nil {
+		return err
+	}
+	blobDir := filepath.Join(layoutDir, ocispec.ImageBlobsDir, digest.SHA256.String())
+	if err := os.MkdirAll(blobDir, 0750); err != nil {
+		return util.ErrorIfCheck("error creating OCI layout directory", err)
+	}
+	writeBlob := func(mediaType string, data []byte) (ocispec.Descriptor, error) {
+		dgst := digest.FromBytes(data)
+		//nolint:exhaustruct
+		descriptor := ocispec.Descriptor{MediaType: mediaType, Digest: dgst, Size: int64(len(data))}
+
+		return descriptor, os.WriteFile(filepath.Join(blobDir, dgst.Encoded()), data, 0600)
+	}
+
+	var layer bytes.Buffer
+	outputs, err := writeTarball(&layer, clusterOutputDir)
+	if err != nil {
+		return err
+	}
+	metadata.Outputs = outputs
+	layerDescriptor, err := writeBlob(ocispec.MediaTypeImageLayerGzip, layer.Bytes())
+	if err != nil {
+		return err
+	}
+	layerDescriptor.Annotations = map[string]string{ocispec.AnnotationTitle: metadata.Cluster + ".tar.gz"}
+
+	configJSON, err := json.Marshal(metadata)
+	if err != nil {
+		return err
+	}
+	configDescriptor, err := writeBlob(MediaTypeConfig, configJSON)
+	if err != nil {
+		return err
+	}
+
+	//nolint:exhaustruct
+	manifest := ocispec.Manifest{
+		Versioned:    specs.Versioned{SchemaVersion: 2}, //nolint:mnd
+		MediaType:    ocispec.MediaTypeImageManifest,
+		ArtifactType: ArtifactType,
+		Config:       configDescriptor,
+		Layers:       []ocispec.Descriptor{layerDescriptor},
+	}
+	manifestJSON, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	manifestDescriptor, err := writeBlob(ocispec.MediaTypeImageManifest, manifestJSON)
+	if err != nil {
+		return err
+	}
+	manifestDescriptor.ArtifactType = ArtifactType
+	manifestDescriptor.Annotations = map[string]string{ocispec.AnnotationRefName: metadata.Cluster}
+
+	//nolint:exhaustruct
+	index := ocispec.Index{
+		Versioned: specs.Versioned{SchemaVersion: 2}, //nolint:mnd
+		MediaType: ocispec.MediaTypeImageIndex,
+		Manifests: []ocispec.Descriptor{manifestDescriptor},
+	}
+	indexJSON, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(layoutDir, ocispec.ImageIndexFile), indexJSON, 0600); err != nil {
+		return err
+	}
+	layoutJSON, err := json.Marshal(ocispec.ImageLayout{Version: ocispec.ImageLayoutVersion})
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(layoutDir, ocispec.ImageLayoutFile), layoutJSON, 0600)
+}
+
+// Hashes a string, returning a hex encoded sha256 digest.
+func HashString(value string) string {
+	sum := sha256.Sum256([]byte(value))
+
+	return hex.EncodeToString(sum[:])
+}
+
+// Hashes the files in a directory, returning a hex encoded sha256 digest.
+// The hash covers the path relative to dir and contents of each file, so it is independent of where dir is.
+// A missing directory hashes as empty.
+func HashDir(dir string) (string, error) {
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return HashString(""), nil
+	}
+	files, err := util.BuildDirFileList(dir)
+	if err != nil {
+		return "", err
+	}
+	entries := make([]string, 0, len(files))
+	for _, file := range files {
+		relPath, err := filepath.Rel(dir, file)
+		if err != nil {
+			return "", err
+		}
+		fileHash, err := util.HashFile(file)
+		if err != nil {
+			return "", err
+		}
+		entries = append(entries, filepath.ToSlash(relPath)+" "+fileHash)
+	}
+	sort.Strings(entries)
+
+	return HashString(strings.Join(entries, "\n")), nil
+}
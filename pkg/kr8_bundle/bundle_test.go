@@ -0,0 +1,166 @@
+package kr8_bundle_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/ice-bergtech/kr8/pkg/kr8_bundle"
+)
+
+func writeTestTree(t *testing.T, dir string, modTime time.Time) {
+	t.Helper()
+	files := map[string]string{
+		"web/web.yaml":       "kind: Deployment\n",
+		"web/docs/readme.md": "# web\n",
+		"db/db.yaml":         "kind: StatefulSet\n",
+		".kr8_cache":         "{}",
+		".kr8_manifest.json": "{}",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestWriteTarball(t *testing.T) {
+	first := t.TempDir()
+	second := t.TempDir()
+	writeTestTree(t, first, time.Now())
+	writeTestTree(t, second, time.Now().Add(-time.Hour))
+
+	var firstTar, secondTar bytes.Buffer
+	if err := kr8_bundle.WriteTarball(&firstTar, first); err != nil {
+		t.Fatalf("WriteTarball() error = %v", err)
+	}
+	if err := kr8_bundle.WriteTarball(&secondTar, second); err != nil {
+		t.Fatalf("WriteTarball() error = %v", err)
+	}
+	if !bytes.Equal(firstTar.Bytes(), secondTar.Bytes()) {
+		t.Error("WriteTarball() is not reproducible for identical trees")
+	}
+
+	gzipReader, err := gzip.NewReader(&firstTar)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tarReader := tar.NewReader(gzipReader)
+	got := []string{}
+	for {
+		header, err := tarReader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		if !header.ModTime.Equal(time.Unix(0, 0)) {
+			t.Errorf("WriteTarball() %s mtime = %v, want epoch", header.Name, header.ModTime)
+		}
+		got = append(got, header.Name)
+	}
+	want := []string{
+		".kr8_manifest.json",
+		"db/", "db/db.yaml",
+		"web/", "web/docs/", "web/docs/readme.md", "web/web.yaml",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("WriteTarball() entries = %v, want %v", got, want)
+	}
+}
+
+func TestWriteOCILayout(t *testing.T) {
+	outputDir := t.TempDir()
+	writeTestTree(t, outputDir, time.Now())
+	bundleDir := t.TempDir()
+	metadata := kr8_bundle.Metadata{
+		Kr8Version: "v1.0.0",
+		Cluster:    "dev",
+		Inputs: kr8_bundle.Inputs{
+			Cluster:    kr8_bundle.HashString("cluster"),
+			Library:    kr8_bundle.HashString("lib"),
+			Components: map[string]kr8_bundle.ComponentInputs{"web": {Params: "", Files: ""}},
+		},
+		Outputs: nil,
+	}
+	layoutDir, err := kr8_bundle.WriteBundle(
+		kr8_bundle.Options{Dir: bundleDir, Format: kr8_bundle.FormatOCI}, outputDir, metadata,
+	)
+	if err != nil {
+		t.Fatalf("WriteBundle() error = %v", err)
+	}
+
+	readJSON := func(path string, value any) {
+		t.Helper()
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal(data, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	blobPath := func(descriptor ocispec.Descriptor) string {
+		return filepath.Join(layoutDir, "blobs", descriptor.Digest.Algorithm().String(), descriptor.Digest.Encoded())
+	}
+
+	var layout ocispec.ImageLayout
+	readJSON(filepath.Join(layoutDir, ocispec.ImageLayoutFile), &layout)
+	if layout.Version != ocispec.ImageLayoutVersion {
+		t.Errorf("oci-layout version = %s", layout.Version)
+	}
+	var index ocispec.Index
+	readJSON(filepath.Join(layoutDir, ocispec.ImageIndexFile), &index)
+	if len(index.Manifests) != 1 || index.Manifests[0].Annotations[ocispec.AnnotationRefName] != "dev" {
+		t.Fatalf("index.json manifests = %v", index.Manifests)
+	}
+	var manifest ocispec.Manifest
+	readJSON(blobPath(index.Manifests[0]), &manifest)
+	var gotMetadata kr8_bundle.Metadata
+	readJSON(blobPath(manifest.Config), &gotMetadata)
+	// Every file in the tarball is listed, not only component output
+	metadata.Outputs = map[string]string{
+		".kr8_manifest.json": kr8_bundle.HashString("{}"),
+		"db/db.yaml":         kr8_bundle.HashString("kind: StatefulSet\n"),
+		"web/docs/readme.md": kr8_bundle.HashString("# web\n"),
+		"web/web.yaml":       kr8_bundle.HashString("kind: Deployment\n"),
+	}
+	if !reflect.DeepEqual(gotMetadata, metadata) {
+		t.Errorf("config = %v, want %v", gotMetadata, metadata)
+	}
+
+	var tarball bytes.Buffer
+	if err := kr8_bundle.WriteTarball(&tarball, outputDir); err != nil {
+		t.Fatal(err)
+	}
+	layer, err := os.ReadFile(blobPath(manifest.Layers[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(layer, tarball.Bytes()) {
+		t.Error("layer does not match the cluster tarball")
+	}
+}
+
+func TestOptionsValidate(t *testing.T) {
+	if err := (kr8_bundle.Options{Dir: "bundles", Format: "zip"}).Validate(); err == nil {
+		t.Error("Validate() accepted an invalid format")
+	}
+}
@@ -0,0 +1,142 @@
+package kr8_check
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/ice-bergtech/kr8/pkg/types"
+)
+
+// Name of the deprecated API check.
+const CheckDeprecation = "deprecation"
+
+// A Kubernetes API that is deprecated, and removed in a later version.
+type APIDeprecation struct {
+	// API group and version, e.g. `policy/v1beta1`
+	APIVersion string
+	// Kind the entry applies to. Empty applies to every kind in the API version
+	Kind string
+	// Kubernetes minor version the API was deprecated in, e.g. `1.21`
+	DeprecatedIn string
+	// Kubernetes minor version the API was removed in, e.g. `1.25`
+	RemovedIn string
+	// Suggested replacement
+	Replacement string
+}
+
+// Built-in table of deprecated and removed Kubernetes APIs.
+// Based on the Kubernetes deprecated API migration guide.
+//
+//nolint:gochecknoglobals
+var APIDeprecations = []APIDeprecation{
+	// Removed in 1.16
+	{"extensions/v1beta1", "Deployment", "1.9", "1.16", "apps/v1 Deployment"},
+	{"extensions/v1beta1", "DaemonSet", "1.9", "1.16", "apps/v1 DaemonSet"},
+	{"extensions/v1beta1", "ReplicaSet", "1.9", "1.16", "apps/v1 ReplicaSet"},
+	{"extensions/v1beta1", "NetworkPolicy", "1.9", "1.16", "networking.k8s.io/v1 NetworkPolicy"},
+	{"extensions/v1beta1", "PodSecurityPolicy", "1.11", "1.16", "Pod Security Admission"},
+	{"apps/v1beta1", "", "1.9", "1.16", "apps/v1"},
+	{"apps/v1beta2", "", "1.9", "1.16", "apps/v1"},
+	// Removed in 1.22
+	{"admissionregistration.k8s.io/v1beta1", "", "1.16", "1.22", "admissionregistration.k8s.io/v1"},
+	{"apiextensions.k8s.io/v1beta1", "CustomResourceDefinition", "1.16", "1.22", "apiextensions.k8s.io/v1 CustomResourceDefinition"},
+	{"apiregistration.k8s.io/v1beta1", "APIService", "1.19", "1.22", "apiregistration.k8s.io/v1 APIService"},
+	{"authentication.k8s.io/v1beta1", "TokenReview", "1.19", "1.22", "authentication.k8s.io/v1 TokenReview"},
+	{"authorization.k8s.io/v1beta1", "", "1.19", "1.22", "authorization.k8s.io/v1"},
+	{"certificates.k8s.io/v1beta1", "CertificateSigningRequest", "1.19", "1.22", "certificates.k8s.io/v1 CertificateSigningRequest"},
+	{"coordination.k8s.io/v1beta1", "Lease", "1.19", "1.22", "coordination.k8s.io/v1 Lease"},
+	{"extensions/v1beta1", "Ingress", "1.14", "1.22", "networking.k8s.io/v1 Ingress"},
+	{"networking.k8s.io/v1beta1", "Ingress", "1.19", "1.22", "networking.k8s.io/v1 Ingress"},
+	{"networking.k8s.io/v1beta1", "IngressClass", "1.19", "1.22", "networking.k8s.io/v1 IngressClass"},
+	{"rbac.authorization.k8s.io/v1beta1", "", "1.17", "1.22", "rbac.authorization.k8s.io/v1"},
+	{"scheduling.k8s.io/v1beta1", "PriorityClass", "1.14", "1.22", "scheduling.k8s.io/v1 PriorityClass"},
+	{"storage.k8s.io/v1beta1", "CSIDriver", "1.19", "1.22", "storage.k8s.io/v1 CSIDriver"},
+	{"storage.k8s.io/v1beta1", "CSINode", "1.19", "1.22", "storage.k8s.io/v1 CSINode"},
+	{"storage.k8s.io/v1beta1", "StorageClass", "1.19", "1.22", "storage.k8s.io/v1 StorageClass"},
+	{"storage.k8s.io/v1beta1", "VolumeAttachment", "1.19", "1.22", "storage.k8s.io/v1 VolumeAttachment"},
+	// Removed in 1.25
+	{"batch/v1beta1", "CronJob", "1.21", "1.25", "batch/v1 CronJob"},
+	{"discovery.k8s.io/v1beta1", "EndpointSlice", "1.21", "1.25", "discovery.k8s.io/v1 EndpointSlice"},
+	{"events.k8s.io/v1beta1", "Event", "1.19", "1.25", "events.k8s.io/v1 Event"},
+	{"autoscaling/v2beta1", "HorizontalPodAutoscaler", "1.22", "1.25", "autoscaling/v2 HorizontalPodAutoscaler"},
+	{"policy/v1beta1", "PodDisruptionBudget", "1.21", "1.25", "policy/v1 PodDisruptionBudget"},
+	{"policy/v1beta1", "PodSecurityPolicy", "1.21", "1.25", "Pod Security Admission"},
+	{"node.k8s.io/v1beta1", "RuntimeClass", "1.20", "1.25", "node.k8s.io/v1 RuntimeClass"},
+	// Removed in 1.26
+	{"flowcontrol.apiserver.k8s.io/v1beta1", "", "1.23", "1.26", "flowcontrol.apiserver.k8s.io/v1"},
+	{"autoscaling/v2beta2", "HorizontalPodAutoscaler", "1.23", "1.26", "autoscaling/v2 HorizontalPodAutoscaler"},
+	// Removed in 1.27
+	{"storage.k8s.io/v1beta1", "CSIStorageCapacity", "1.24", "1.27", "storage.k8s.io/v1 CSIStorageCapacity"},
+	// Removed in 1.29
+	{"flowcontrol.apiserver.k8s.io/v1beta2", "", "1.26", "1.29", "flowcontrol.apiserver.k8s.io/v1"},
+	// Removed in 1.32
+	{"flowcontrol.apiserver.k8s.io/v1beta3", "", "1.29", "1.32", "flowcontrol.apiserver.k8s.io/v1"},
+}
+
+// Parses the major and minor version from a Kubernetes version string such as `1.29`, `v1.29.3` or `1.29.0-eks`.
+func ParseKubernetesVersion(version string) (int, int, error) {
+	parts := strings.SplitN(strings.TrimPrefix(strings.TrimSpace(version), "v"), ".", 3)
+	if len(parts) < 2 {
+		return 0, 0, types.Kr8Error{Message: "invalid Kubernetes version", Value: version}
+	}
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, types.Kr8Error{Message: "invalid Kubernetes version", Value: version}
+	}
+	minor, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, 0, types.Kr8Error{Message: "invalid Kubernetes version", Value: version}
+	}
+
+	return major, minor, nil
+}
+
+// Checks if a Kubernetes version is the same or newer than a `major.minor` version from the deprecation table.
+func versionAtLeast(major int, minor int, version string) bool {
+	tableMajor, tableMinor, err := ParseKubernetesVersion(version)
+	if err != nil {
+		return false
+	}
+
+	return major > tableMajor || (major == tableMajor && minor >= tableMinor)
+}
+
+// Finds the deprecation table entry for an object, if any.
+func lookupDeprecation(table []APIDeprecation, obj Object) (APIDeprecation, bool) {
+	for _, entry := range table {
+		if entry.APIVersion == obj.APIVersion() && (entry.Kind == "" || entry.Kind == obj.Kind()) {
+			return entry, true
+		}
+	}
+
+	return APIDeprecation{}, false
+}
+
+// Reports objects that use APIs deprecated or removed in a Kubernetes version.
+// Removed APIs are reported with a deny severity, deprecated APIs with a warn severity.
+func FindDeprecatedAPIs(objects []Object, kubernetesVersion string, table []APIDeprecation) ([]Finding, error) {
+	major, minor, err := ParseKubernetesVersion(kubernetesVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	findings := []Finding{}
+	for _, obj := range objects {
+		entry, found := lookupDeprecation(table, obj)
+		if !found {
+			continue
+		}
+		api := obj.APIVersion() + " " + obj.Kind()
+		switch {
+		case versionAtLeast(major, minor, entry.RemovedIn):
+			findings = append(findings, NewFinding(CheckDeprecation, SeverityDeny, obj,
+				api+" was removed in Kubernetes "+entry.RemovedIn+", use "+entry.Replacement))
+		case versionAtLeast(major, minor, entry.DeprecatedIn):
+			findings = append(findings, NewFinding(CheckDeprecation, SeverityWarn, obj,
+				api+" is deprecated since Kubernetes "+entry.DeprecatedIn+
+					" and removed in "+entry.RemovedIn+", use "+entry.Replacement))
+		}
+	}
+
+	return findings, nil
+}
@@ -0,0 +1,82 @@
+package kr8_check_test
+
+import (
+	"testing"
+
+	"github.com/ice-bergtech/kr8/pkg/kr8_check"
+)
+
+func TestFindDeprecatedAPIs(t *testing.T) {
+	objects := []kr8_check.Object{
+		parseTestObject(t, "psp", "apiVersion: policy/v1beta1\nkind: PodSecurityPolicy\nmetadata: {name: restricted}\n"),
+		parseTestObject(t, "hpa", "apiVersion: autoscaling/v2beta2\nkind: HorizontalPodAutoscaler\nmetadata: {name: web}\n"),
+		parseTestObject(t, "rbac", "apiVersion: rbac.authorization.k8s.io/v1beta1\nkind: Role\nmetadata: {name: r}\n"),
+		parseTestObject(t, "app", "apiVersion: apps/v1\nkind: Deployment\nmetadata: {name: web}\n"),
+	}
+
+	tests := []struct {
+		name    string
+		version string
+		want    map[string]kr8_check.Severity
+		wantErr bool
+	}{
+		{
+			name:    "old cluster",
+			version: "1.16",
+			want:    map[string]kr8_check.Severity{},
+			wantErr: false,
+		},
+		{
+			name:    "deprecated but not removed",
+			version: "v1.21.4",
+			want: map[string]kr8_check.Severity{
+				"psp":  kr8_check.SeverityWarn,
+				"rbac": kr8_check.SeverityWarn,
+			},
+			wantErr: false,
+		},
+		{
+			name:    "removed and deprecated",
+			version: "1.23.0-eks",
+			want: map[string]kr8_check.Severity{
+				"psp":  kr8_check.SeverityWarn,
+				"hpa":  kr8_check.SeverityWarn,
+				"rbac": kr8_check.SeverityDeny,
+			},
+			wantErr: false,
+		},
+		{
+			name:    "all removed",
+			version: "1.29",
+			want: map[string]kr8_check.Severity{
+				"psp":  kr8_check.SeverityDeny,
+				"hpa":  kr8_check.SeverityDeny,
+				"rbac": kr8_check.SeverityDeny,
+			},
+			wantErr: false,
+		},
+		{
+			name:    "invalid version",
+			version: "latest",
+			want:    nil,
+			wantErr: true,
+		},
+	}
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			got, err := kr8_check.FindDeprecatedAPIs(objects, testCase.version, kr8_check.APIDeprecations)
+			if (err != nil) != testCase.wantErr {
+				t.Fatalf("FindDeprecatedAPIs() error = %v, wantErr %v", err, testCase.wantErr)
+			}
+			if len(got) != len(testCase.want) {
+				t.Fatalf("FindDeprecatedAPIs() = %v, want %v", got, testCase.want)
+			}
+			for _, finding := range got {
+				if finding.Severity != testCase.want[finding.Component] {
+					t.Errorf("FindDeprecatedAPIs() %s severity = %s, want %s",
+						finding.Component, finding.Severity, testCase.want[finding.Component])
+				}
+			}
+		})
+	}
+}
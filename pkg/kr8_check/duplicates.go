@@ -0,0 +1,53 @@
+package kr8_check
+
+// Name of the duplicate resource check.
+const CheckDuplicate = "duplicate"
+
+// Returns the identity of a resource in a cluster: `group/kind/namespace/name`.
+// The API version is not included, the same resource served in two versions is still one resource.
+func ResourceIdentity(obj Object) string {
+	return obj.Group() + "/" + obj.Kind() + "/" + obj.Namespace() + "/" + obj.Name()
+}
+
+// Finds objects that share a resource identity with another object in the cluster.
+// A finding is reported for each repeated object, naming the component and file of the first object.
+// Only duplicates that involve at least one object from a component in components are reported.
+// If components is nil, all duplicates are reported.
+func FindDuplicates(objects []Object, components []string, severity Severity) []Finding {
+	selected := map[string]bool{}
+	for _, component := range components {
+		selected[component] = true
+	}
+
+	index := map[string][]Object{}
+	order := []string{}
+	for _, obj := range objects {
+		identity := ResourceIdentity(obj)
+		if _, found := index[identity]; !found {
+			order = append(order, identity)
+		}
+		index[identity] = append(index[identity], obj)
+	}
+
+	findings := []Finding{}
+	for _, identity := range order {
+		matches := index[identity]
+		if len(matches) < 2 {
+			continue
+		}
+		involved := components == nil
+		for _, obj := range matches {
+			involved = involved || selected[obj.Component]
+		}
+		if !involved {
+			continue
+		}
+		first := matches[0]
+		for _, obj := range matches[1:] {
+			findings = append(findings, NewFinding(CheckDuplicate, severity, obj,
+				"duplicate resource, also generated by component "+first.Component+" in "+first.File))
+		}
+	}
+
+	return findings
+}
@@ -0,0 +1,107 @@
+package kr8_check_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ice-bergtech/kr8/pkg/kr8_check"
+)
+
+func TestFindDuplicates(t *testing.T) {
+	sa := "apiVersion: v1\nkind: ServiceAccount\nmetadata: {name: shared, namespace: default}\n"
+	objects := []kr8_check.Object{
+		parseTestObject(t, "app", sa),
+		parseTestObject(t, "monitoring", sa),
+		parseTestObject(t, "app", "apiVersion: v1\nkind: ServiceAccount\nmetadata: {name: shared, namespace: other}\n"),
+		parseTestObject(t, "app", "apiVersion: apps/v1\nkind: Deployment\nmetadata: {name: web, namespace: default}\n"),
+		parseTestObject(t, "web", "apiVersion: apps/v1beta1\nkind: Deployment\nmetadata: {name: web, namespace: default}\n"),
+	}
+
+	tests := []struct {
+		name       string
+		components []string
+		want       []string
+	}{
+		{
+			name:       "all components",
+			components: nil,
+			want: []string{
+				"monitoring: duplicate resource, also generated by component app in app/file.yaml",
+				"web: duplicate resource, also generated by component app in app/file.yaml",
+			},
+		},
+		{
+			name:       "duplicate involving a selected component",
+			components: []string{"web"},
+			want:       []string{"web: duplicate resource, also generated by component app in app/file.yaml"},
+		},
+		{
+			name:       "no selected component involved",
+			components: []string{"other"},
+			want:       []string{},
+		},
+	}
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			got := kr8_check.FindDuplicates(objects, testCase.components, kr8_check.SeverityDeny)
+			if len(got) != len(testCase.want) {
+				t.Fatalf("FindDuplicates() = %v, want %v", got, testCase.want)
+			}
+			for idx, finding := range got {
+				if finding.Component+": "+finding.Message != testCase.want[idx] {
+					t.Errorf("FindDuplicates() = %s: %s, want %s", finding.Component, finding.Message, testCase.want[idx])
+				}
+				if finding.Severity != kr8_check.SeverityDeny || finding.Check != kr8_check.CheckDuplicate {
+					t.Errorf("FindDuplicates() check = %s severity = %s", finding.Check, finding.Severity)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadGeneratedObjects(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"app/app.yaml":     "apiVersion: v1\nkind: Service\nmetadata: {name: a}\n---\napiVersion: v1\nkind: ConfigMap\nmetadata: {name: b}\n",
+		"app/docs/README":  "not a manifest",
+		"app/broken.yaml":  "key: [unclosed\n",
+		"other/list.json":  `[{"apiVersion": "v1", "kind": "Secret", "metadata": {"name": "c"}}]`,
+		".kr8_cache":       "{}",
+		"other/notes.yaml": "just: data\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	objects, findings, err := kr8_check.LoadGeneratedObjects(dir)
+	if err != nil {
+		t.Fatalf("LoadGeneratedObjects() failed: %v", err)
+	}
+	got := []string{}
+	for _, obj := range objects {
+		got = append(got, obj.Component+" "+obj.File+" "+obj.String())
+	}
+	want := []string{
+		"app app/app.yaml v1/Service a",
+		"app app/app.yaml v1/ConfigMap b",
+		"other other/list.json v1/Secret c",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("LoadGeneratedObjects() = %v, want %v", got, want)
+	}
+	for idx := range want {
+		if got[idx] != want[idx] {
+			t.Errorf("LoadGeneratedObjects() object %d = %s, want %s", idx, got[idx], want[idx])
+		}
+	}
+	if len(findings) != 1 || findings[0].File != "app/broken.yaml" || findings[0].Severity != kr8_check.SeverityWarn {
+		t.Errorf("LoadGeneratedObjects() findings = %v, want a warning for app/broken.yaml", findings)
+	}
+}
@@ -0,0 +1,104 @@
+package kr8_check
+
+import (
+	"sort"
+
+	"github.com/rs/zerolog"
+)
+
+// How severe a finding is.
+type Severity string
+
+const (
+	// Finding is reported, but does not fail generation
+	SeverityWarn Severity = "warn"
+	// Finding is reported and fails generation
+	SeverityDeny Severity = "deny"
+)
+
+// Parses a severity string. Unknown or empty values default to the given fallback.
+func ParseSeverity(input string, fallback Severity) Severity {
+	switch Severity(input) {
+	case SeverityWarn:
+		return SeverityWarn
+	case SeverityDeny:
+		return SeverityDeny
+	default:
+		return fallback
+	}
+}
+
+// A single issue found by a check.
+type Finding struct {
+	// Name of the check that produced the finding
+	Check string `json:"check"`
+	// Severity of the finding
+	Severity Severity `json:"severity"`
+	// Cluster the object was generated for
+	Cluster string `json:"cluster,omitempty"`
+	// Component that generated the object
+	Component string `json:"component"`
+	// Generated file the object is in, relative to the cluster output directory
+	File string `json:"file"`
+	// Identity of the object, see [Object.String]
+	Object string `json:"object"`
+	// Description of the issue
+	Message string `json:"message"`
+}
+
+// Creates a finding for an object.
+func NewFinding(check string, severity Severity, obj Object, message string) Finding {
+	return Finding{
+		Check:     check,
+		Severity:  severity,
+		Cluster:   "",
+		Component: obj.Component,
+		File:      obj.File,
+		Object:    obj.String(),
+		Message:   message,
+	}
+}
+
+// Sets the cluster of each finding.
+func SetCluster(findings []Finding, cluster string) {
+	for idx := range findings {
+		findings[idx].Cluster = cluster
+	}
+}
+
+// Sorts findings by component, file, object and message for stable output.
+func SortFindings(findings []Finding) {
+	sort.SliceStable(findings, func(i, j int) bool {
+		if findings[i].Component != findings[j].Component {
+			return findings[i].Component < findings[j].Component
+		}
+		if findings[i].File != findings[j].File {
+			return findings[i].File < findings[j].File
+		}
+		if findings[i].Object != findings[j].Object {
+			return findings[i].Object < findings[j].Object
+		}
+
+		return findings[i].Message < findings[j].Message
+	})
+}
+
+// Logs each finding with its context.
+// Returns the number of findings with a deny severity.
+func LogFindings(findings []Finding, logger zerolog.Logger) int {
+	denied := 0
+	for _, finding := range findings {
+		event := logger.Warn()
+		if finding.Severity == SeverityDeny {
+			event = logger.Error()
+			denied++
+		}
+		event.Str("check", finding.Check).
+			Str("component", finding.Component).
+			Str("file", finding.File).
+			Str("object", finding.Object).
+			Msg(finding.Message)
+	}
+
+	return denied
+}
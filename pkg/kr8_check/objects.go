@@ -0,0 +1,259 @@
+// Package kr8_check implements checks that run against the Kubernetes objects kr8+ generates.
+//
+// Generated output is loaded from a cluster's output directory into a list of objects,
+// each annotated with the component and file that produced it.
+// Checks inspect the objects and report findings with a severity.
+// Findings with a deny severity fail generation.
+package kr8_check
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/yaml"
+
+	"github.com/ice-bergtech/kr8/pkg/util"
+)
+
+// A Kubernetes object generated by a component.
+type Object struct {
+	// Name of the component that generated the object
+	Component string
+	// Path of the generated file, relative to the cluster output directory
+	File string
+	// Index of the object within the generated file
+	Index int
+	// The object contents
+	Data map[string]any
+}
+
+// Returns the apiVersion of the object.
+func (o Object) APIVersion() string {
+	return o.getString("apiVersion")
+}
+
+// Returns the kind of the object.
+func (o Object) Kind() string {
+	return o.getString("kind")
+}
+
+// Returns the name of the object from metadata.
+func (o Object) Name() string {
+	return o.getMetadataString("name")
+}
+
+// Returns the namespace of the object from metadata.
+func (o Object) Namespace() string {
+	return o.getMetadataString("namespace")
+}
+
+// Returns the API group of the object. The core group is an empty string.
+func (o Object) Group() string {
+	group, _, found := strings.Cut(o.APIVersion(), "/")
+	if !found {
+		return ""
+	}
+
+	return group
+}
+
+// Returns the API version of the object without the group.
+func (o Object) Version() string {
+	apiVersion := o.APIVersion()
+	if idx := strings.LastIndex(apiVersion, "/"); idx >= 0 {
+		return apiVersion[idx+1:]
+	}
+
+	return apiVersion
+}
+
+// Returns a human readable identity for the object: `apiVersion/kind namespace/name`.
+func (o Object) String() string {
+	name := o.Name()
+	if ns := o.Namespace(); ns != "" {
+		name = ns + "/" + name
+	}
+
+	return o.APIVersion() + "/" + o.Kind() + " " + name
+}
+
+func (o Object) getString(key string) string {
+	if val, ok := o.Data[key].(string); ok {
+		return val
+	}
+
+	return ""
+}
+
+func (o Object) getMetadataString(key string) string {
+	metadata, ok := o.Data["metadata"].(map[string]any)
+	if !ok {
+		return ""
+	}
+	if val, ok := metadata[key].(string); ok {
+		return val
+	}
+
+	return ""
+}
+
+// Checks if a generated file should be parsed for Kubernetes objects.
+func IsManifestFile(file string) bool {
+	ext := filepath.Ext(file)
+
+	return ext == ".yaml" || ext == ".yml" || ext == ".json"
+}
+
+// Name of the check reporting generated files that could not be parsed.
+const CheckLoad = "load"
+
+// Loads the Kubernetes objects generated for a cluster.
+// Each directory in the cluster output directory is treated as a component.
+// Documents that are not objects with an `apiVersion` and `kind` are skipped.
+// Files that can't be parsed are returned as warning findings.
+func LoadGeneratedObjects(clusterOutputDir string) ([]Object, []Finding, error) {
+	entries, err := os.ReadDir(clusterOutputDir)
+	if err != nil {
+		return nil, nil, util.ErrorIfCheck("error reading cluster output directory", err)
+	}
+
+	objects := []Object{}
+	findings := []Finding{}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		compObjects, compFindings, err := LoadComponentObjects(clusterOutputDir, entry.Name())
+		if err != nil {
+			return nil, nil, err
+		}
+		objects = append(objects, compObjects...)
+		findings = append(findings, compFindings...)
+	}
+
+	return objects, findings, nil
+}
+
+// Loads the Kubernetes objects generated for a single component of a cluster.
+// Files that can't be parsed are returned as warning findings.
+func LoadComponentObjects(clusterOutputDir string, component string) ([]Object, []Finding, error) {
+	files, err := util.BuildDirFileList(filepath.Join(clusterOutputDir, component))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return LoadFileObjects(clusterOutputDir, component, files)
+}
+
+// Loads the Kubernetes objects in a list of files generated for a component of a cluster.
+// Used when the files of a component are not in a single directory.
+// Files that can't be parsed are returned as warning findings.
+func LoadFileObjects(clusterOutputDir string, component string, files []string) ([]Object, []Finding, error) {
+	objects := []Object{}
+	findings := []Finding{}
+	for _, file := range files {
+		if !IsManifestFile(file) {
+			continue
+		}
+		relPath, err := filepath.Rel(clusterOutputDir, file)
+		if err != nil {
+			return nil, nil, util.ErrorIfCheck("error calculating relative path", err)
+		}
+		relPath = filepath.ToSlash(relPath)
+		data, err := os.ReadFile(filepath.Clean(file))
+		if err != nil {
+			return nil, nil, util.ErrorIfCheck("error reading "+relPath, err)
+		}
+		fileObjects, err := ParseObjects(data)
+		if err != nil {
+			findings = append(findings, Finding{
+				Check:     CheckLoad,
+				Severity:  SeverityWarn,
+				Cluster:   "",
+				Component: component,
+				File:      relPath,
+				Object:    "",
+				Message:   "unable to parse generated file, objects are not checked: " + err.Error(),
+			})
+
+			continue
+		}
+		for idx, data := range fileObjects {
+			objects = append(objects, Object{
+				Component: component,
+				File:      relPath,
+				Index:     idx,
+				Data:      data,
+			})
+		}
+	}
+
+	return objects, findings, nil
+}
+
+// Parses a yaml or json stream file into a list of Kubernetes objects.
+func LoadObjectsFromFile(file string) ([]map[string]any, error) {
+	data, err := os.ReadFile(filepath.Clean(file))
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseObjects(data)
+}
+
+// Parses a yaml or json stream into a list of Kubernetes objects.
+// Documents that are not objects with an `apiVersion` and `kind` are skipped.
+func ParseObjects(data []byte) ([]map[string]any, error) {
+	reader := yaml.NewYAMLReader(bufio.NewReader(bytes.NewReader(data)))
+	objects := []map[string]any{}
+	for {
+		doc, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		jsonData, err := yaml.ToJSON(doc)
+		if err != nil {
+			return nil, err
+		}
+		var value any
+		if err := json.Unmarshal(jsonData, &value); err != nil {
+			return nil, err
+		}
+		objects = append(objects, collectObjects(value)...)
+	}
+
+	return objects, nil
+}
+
+// Extracts Kubernetes objects from a parsed document.
+// Lists, such as json output files, are flattened.
+func collectObjects(value any) []map[string]any {
+	switch val := value.(type) {
+	case []any:
+		result := []map[string]any{}
+		for _, item := range val {
+			result = append(result, collectObjects(item)...)
+		}
+
+		return result
+	case map[string]any:
+		if _, ok := val["apiVersion"].(string); !ok {
+			return nil
+		}
+		if _, ok := val["kind"].(string); !ok {
+			return nil
+		}
+
+		return []map[string]any{val}
+	default:
+		return nil
+	}
+}
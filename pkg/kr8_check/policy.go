@@ -0,0 +1,140 @@
+package kr8_check
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	jsonnet "github.com/google/go-jsonnet"
+
+	"github.com/ice-bergtech/kr8/pkg/kr8_types"
+	"github.com/ice-bergtech/kr8/pkg/util"
+)
+
+// Name of the policy check.
+const CheckPolicy = "policy"
+
+// Jsonnet snippet that applies a policy to every object.
+// Policies return null, true or an empty list when an object passes,
+// otherwise a violation message or a list of messages.
+const policySnippet = `
+local policy = (import %s)%s;
+local config = std.extVar('kr8_policy_config');
+local objects = std.extVar('kr8_policy_objects');
+local normalize(result) =
+  if result == null || result == true then []
+  else if std.isString(result) then [result]
+  else if std.isArray(result) then result
+  else error 'policy must return null, a string or a list of strings, got ' + std.type(result);
+[
+  normalize(policy(entry.object, {
+    cluster: std.get(config, '_cluster', {}),
+    component: entry.component,
+    params: std.get(config, entry.component, {}),
+    file: entry.file,
+  }))
+  for entry in objects
+]
+`
+
+type policyInput struct {
+	Object    map[string]any `json:"object"`
+	Component string         `json:"component"`
+	File      string         `json:"file"`
+}
+
+// Evaluates jsonnet policies against a list of objects.
+// Each policy is called as `policy(object, context)` for every object, where context contains:
+//
+//   - `cluster`: the cluster's `_cluster` parameters
+//   - `component`: name of the component that generated the object
+//   - `params`: the component's parameters
+//   - `file`: generated file the object is in
+//
+// Policy files are imported relative to baseDir, falling back to the VM's library paths.
+// config is the rendered cluster configuration containing `_cluster` and component parameters.
+func EvaluatePolicies(
+	jvm *jsonnet.VM,
+	baseDir string,
+	policies []kr8_types.Kr8PolicySpec,
+	objects []Object,
+	config string,
+) ([]Finding, error) {
+	if len(policies) == 0 || len(objects) == 0 {
+		return nil, nil
+	}
+
+	inputs := make([]policyInput, len(objects))
+	for idx, obj := range objects {
+		inputs[idx] = policyInput{Object: obj.Data, Component: obj.Component, File: obj.File}
+	}
+	inputJSON, err := json.Marshal(inputs)
+	if err != nil {
+		return nil, util.ErrorIfCheck("error encoding objects for policies", err)
+	}
+	if config == "" {
+		config = "{}"
+	}
+	jvm.ExtCode("kr8_policy_config", config)
+	jvm.ExtCode("kr8_policy_objects", string(inputJSON))
+
+	findings := []Finding{}
+	for _, policy := range policies {
+		selector := ""
+		if policy.Function != "" {
+			selector = "[" + util.QuoteJsonnet(policy.Function) + "]"
+		}
+		output, err := jvm.EvaluateAnonymousSnippet(
+			"policy "+policy.Name,
+			fmt.Sprintf(policySnippet, util.QuoteJsonnet(resolvePolicyFile(baseDir, policy.File)), selector),
+		)
+		if err != nil {
+			return nil, util.ErrorIfCheck("error evaluating policy "+policy.Name, err)
+		}
+
+		var results [][]any
+		if err := json.Unmarshal([]byte(output), &results); err != nil {
+			return nil, util.ErrorIfCheck("error parsing result of policy "+policy.Name, err)
+		}
+		severity := ParseSeverity(policy.Severity, SeverityDeny)
+		for idx, violations := range results {
+			for _, violation := range violations {
+				finding := NewFinding(CheckPolicy, severity, objects[idx], policyMessage(violation))
+				finding.Check = CheckPolicy + "/" + policy.Name
+				findings = append(findings, finding)
+			}
+		}
+	}
+
+	return findings, nil
+}
+
+// Resolves a policy file relative to the base directory.
+// Files that don't exist there are left for the VM's library paths to resolve.
+func resolvePolicyFile(baseDir string, file string) string {
+	if filepath.IsAbs(file) {
+		return file
+	}
+	path := filepath.Join(baseDir, file)
+	if _, err := os.Stat(path); err == nil {
+		if absPath, err := filepath.Abs(path); err == nil {
+			return absPath
+		}
+	}
+
+	return file
+}
+
+// Converts a violation returned by a policy into a message.
+func policyMessage(violation any) string {
+	if message, ok := violation.(string); ok {
+		return message
+	}
+	message, err := json.Marshal(violation)
+	if err != nil {
+		return fmt.Sprint(violation)
+	}
+
+	return string(message)
+}
@@ -0,0 +1,126 @@
+package kr8_check_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	jsonnet "github.com/google/go-jsonnet"
+
+	"github.com/ice-bergtech/kr8/pkg/kr8_check"
+	"github.com/ice-bergtech/kr8/pkg/kr8_types"
+)
+
+const testPolicies = `{
+  noLatest(object, context)::
+    [
+      'container ' + c.name + ' uses a latest image'
+      for c in std.get(std.get(std.get(std.get(object, 'spec', {}), 'template', {}), 'spec', {}), 'containers', [])
+      if std.endsWith(c.image, ':latest')
+    ],
+  hostNetwork(object, context)::
+    if std.get(std.get(object, 'spec', {}), 'hostNetwork', false) && context.params.namespace != 'kube-system'
+    then 'hostNetwork is only allowed in kube-system'
+    else null,
+  clusterTier(object, context)::
+    if context.cluster.tier == 'prod' && context.component == 'debug' then 'debug is not allowed in ' + context.file,
+  invalid(object, context):: 42,
+}
+`
+
+func TestEvaluatePolicies(t *testing.T) {
+	baseDir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(baseDir, "lib"), 0750); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(baseDir, "lib", "policies.libsonnet"), []byte(testPolicies), 0600); err != nil {
+		t.Fatal(err)
+	}
+	quotedPolicies := `{ "it's"(object, context):: if object.metadata.name == 'agent' then 'quoted \\ policy' }`
+	if err := os.WriteFile(filepath.Join(baseDir, "lib", `team's\policies.libsonnet`), []byte(quotedPolicies), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	objects := []kr8_check.Object{
+		parseTestObject(t, "app",
+			"apiVersion: apps/v1\nkind: Deployment\nmetadata: {name: app}\n"+
+				"spec: {template: {spec: {containers: [{name: web, image: 'web:latest'}, {name: sidecar, image: 'sc:1.0'}]}}}\n"),
+		parseTestObject(t, "agent", "apiVersion: v1\nkind: Pod\nmetadata: {name: agent}\nspec: {hostNetwork: true}\n"),
+		parseTestObject(t, "debug", "apiVersion: v1\nkind: Pod\nmetadata: {name: debug}\nspec: {}\n"),
+	}
+	config := `{"_cluster": {"tier": "prod"}, "app": {"namespace": "app"}, ` +
+		`"agent": {"namespace": "monitoring"}, "debug": {"namespace": "debug"}}`
+
+	tests := []struct {
+		name     string
+		policy   kr8_types.Kr8PolicySpec
+		want     []string
+		severity kr8_check.Severity
+		wantErr  bool
+	}{
+		{
+			name:     "list of violations",
+			policy:   kr8_types.Kr8PolicySpec{Name: "no-latest", File: "lib/policies.libsonnet", Function: "noLatest", Severity: "deny"},
+			want:     []string{"container web uses a latest image"},
+			severity: kr8_check.SeverityDeny,
+			wantErr:  false,
+		},
+		{
+			name:     "single violation using component params",
+			policy:   kr8_types.Kr8PolicySpec{Name: "host-network", File: "policies.libsonnet", Function: "hostNetwork", Severity: "warn"},
+			want:     []string{"hostNetwork is only allowed in kube-system"},
+			severity: kr8_check.SeverityWarn,
+			wantErr:  false,
+		},
+		{
+			name:     "cluster and file context",
+			policy:   kr8_types.Kr8PolicySpec{Name: "tier", File: "lib/policies.libsonnet", Function: "clusterTier", Severity: "deny"},
+			want:     []string{"debug is not allowed in debug/file.yaml"},
+			severity: kr8_check.SeverityDeny,
+			wantErr:  false,
+		},
+		{
+			name:     "quotes in file and function names",
+			policy:   kr8_types.Kr8PolicySpec{Name: "quoted", File: `lib/team's\policies.libsonnet`, Function: "it's", Severity: "deny"},
+			want:     []string{`quoted \ policy`},
+			severity: kr8_check.SeverityDeny,
+			wantErr:  false,
+		},
+		{
+			name:    "invalid return type",
+			policy:  kr8_types.Kr8PolicySpec{Name: "invalid", File: "lib/policies.libsonnet", Function: "invalid", Severity: "deny"},
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name:    "missing file",
+			policy:  kr8_types.Kr8PolicySpec{Name: "missing", File: "lib/missing.libsonnet", Function: "", Severity: "deny"},
+			want:    nil,
+			wantErr: true,
+		},
+	}
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			jvm := jsonnet.MakeVM()
+			jvm.Importer(&jsonnet.FileImporter{JPaths: []string{filepath.Join(baseDir, "lib")}})
+			got, err := kr8_check.EvaluatePolicies(jvm, baseDir, []kr8_types.Kr8PolicySpec{testCase.policy}, objects, config)
+			if (err != nil) != testCase.wantErr {
+				t.Fatalf("EvaluatePolicies() error = %v, wantErr %v", err, testCase.wantErr)
+			}
+			if len(got) != len(testCase.want) {
+				t.Fatalf("EvaluatePolicies() = %v, want %v", got, testCase.want)
+			}
+			for idx, finding := range got {
+				if finding.Message != testCase.want[idx] {
+					t.Errorf("EvaluatePolicies() message = %s, want %s", finding.Message, testCase.want[idx])
+				}
+				if finding.Severity != testCase.severity {
+					t.Errorf("EvaluatePolicies() severity = %s, want %s", finding.Severity, testCase.severity)
+				}
+				if finding.Check != kr8_check.CheckPolicy+"/"+testCase.policy.Name {
+					t.Errorf("EvaluatePolicies() check = %s", finding.Check)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,367 @@
+package kr8_check
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/santhosh-tekuri/jsonschema/v6"
+
+	"github.com/ice-bergtech/kr8/pkg/util"
+)
+
+// Name of the schema validation check.
+const CheckSchema = "schema"
+
+// Options for validating generated objects against Kubernetes schemas.
+type SchemaOptions struct {
+	// Directory containing Kubernetes JSON schemas
+	SchemaDir string
+	// Kubernetes version used to select schemas, e.g. `1.29.0`
+	KubernetesVersion string
+	// If true, schemas derived from CRDs reject unknown fields
+	Strict bool
+	// If true, objects without a schema are not reported
+	IgnoreMissing bool
+}
+
+// Validates Kubernetes objects against JSON schemas.
+//
+// Schemas are taken from CRDs in the generated output first, then from the schema directory.
+// The schema directory is searched using the layouts of the
+// [kubernetes-json-schema](https://github.com/yannh/kubernetes-json-schema) project and
+// [CRDs-catalog](https://github.com/datreeio/CRDs-catalog):
+//
+//   - `<dir>/v<version>-standalone-strict/<kind>-<group>-<version>.json`
+//   - `<dir>/v<version>-standalone/<kind>-<group>-<version>.json`
+//   - `<dir>/v<version>/<kind>-<group>-<version>.json`
+//   - `<dir>/<kind>-<group>-<version>.json`
+//   - `<dir>/<full group>/<kind>_<version>.json`
+//
+// The group is the first segment of the API group and omitted for the core API group.
+type SchemaValidator struct {
+	options SchemaOptions
+	// Compiled schemas from CRDs, keyed by `group/version/kind`
+	crdSchemas map[string]*jsonschema.Schema
+	// Compiled schemas from the schema directory, keyed by file path. nil if not found
+	fileSchemas map[string]*jsonschema.Schema
+}
+
+// Creates a schema validator.
+// CRDs found in crdSources are converted to schemas for the custom resources they define.
+// Returns findings for CRDs whose schemas could not be loaded.
+func NewSchemaValidator(options SchemaOptions, crdSources []Object) (*SchemaValidator, []Finding) {
+	validator := SchemaValidator{
+		options:     options,
+		crdSchemas:  map[string]*jsonschema.Schema{},
+		fileSchemas: map[string]*jsonschema.Schema{},
+	}
+	findings := []Finding{}
+	for _, obj := range crdSources {
+		if obj.Kind() != "CustomResourceDefinition" || obj.Group() != "apiextensions.k8s.io" {
+			continue
+		}
+		if err := validator.addCRD(obj); err != nil {
+			findings = append(findings, NewFinding(CheckSchema, SeverityWarn, obj, "unable to load CRD schema: "+err.Error()))
+		}
+	}
+
+	return &validator, findings
+}
+
+// Validates a list of objects, returning a finding for each schema violation.
+func (v *SchemaValidator) ValidateObjects(objects []Object) []Finding {
+	findings := []Finding{}
+	for _, obj := range objects {
+		findings = append(findings, v.Validate(obj)...)
+	}
+
+	return findings
+}
+
+// Validates a single object against its schema.
+func (v *SchemaValidator) Validate(obj Object) []Finding {
+	schema, err := v.lookupSchema(obj)
+	if err != nil {
+		return []Finding{NewFinding(CheckSchema, SeverityWarn, obj, "unable to load schema: "+err.Error())}
+	}
+	if schema == nil {
+		if v.options.IgnoreMissing {
+			return nil
+		}
+
+		return []Finding{NewFinding(CheckSchema, SeverityWarn, obj, "no schema found for "+obj.APIVersion()+"/"+obj.Kind())}
+	}
+
+	err = schema.Validate(map[string]any(obj.Data))
+	var validationErr *jsonschema.ValidationError
+	if errors.As(err, &validationErr) {
+		findings := []Finding{}
+		for _, message := range flattenValidationError(validationErr.BasicOutput()) {
+			findings = append(findings, NewFinding(CheckSchema, SeverityDeny, obj, message))
+		}
+
+		return findings
+	} else if err != nil {
+		return []Finding{NewFinding(CheckSchema, SeverityDeny, obj, err.Error())}
+	}
+
+	return nil
+}
+
+// Collects the leaf messages from a validation output.
+func flattenValidationError(unit *jsonschema.OutputUnit) []string {
+	messages := []string{}
+	for _, cause := range unit.Errors {
+		if cause.Error == nil {
+			continue
+		}
+		message := cause.Error.String()
+		// Skip summary messages that only point at nested causes
+		if strings.HasPrefix(message, "validation failed") {
+			continue
+		}
+		location := cause.InstanceLocation
+		if location == "" {
+			location = "/"
+		}
+		messages = append(messages, "at '"+location+"': "+message)
+	}
+	if len(messages) == 0 && unit.Error != nil {
+		messages = append(messages, unit.Error.String())
+	}
+
+	return messages
+}
+
+func crdKey(group string, version string, kind string) string {
+	return group + "/" + version + "/" + kind
+}
+
+// Finds the schema for an object.
+// Returns nil without an error if no schema exists.
+func (v *SchemaValidator) lookupSchema(obj Object) (*jsonschema.Schema, error) {
+	if schema, ok := v.crdSchemas[crdKey(obj.Group(), obj.Version(), obj.Kind())]; ok {
+		return schema, nil
+	}
+	if v.options.SchemaDir == "" {
+		return nil, nil
+	}
+	for _, file := range v.schemaFileCandidates(obj) {
+		schema, err := v.loadSchemaFile(file)
+		if err != nil || schema != nil {
+			return schema, err
+		}
+	}
+
+	return nil, nil
+}
+
+// Lists the schema files that may describe an object, in order of preference.
+func (v *SchemaValidator) schemaFileCandidates(obj Object) []string {
+	kind := strings.ToLower(obj.Kind())
+	version := strings.ToLower(obj.Version())
+	group := strings.ToLower(obj.Group())
+	fileName := kind + "-" + version + ".json"
+	if group != "" {
+		groupPrefix, _, _ := strings.Cut(group, ".")
+		fileName = kind + "-" + groupPrefix + "-" + version + ".json"
+	}
+
+	dirs := []string{}
+	if k8sVersion := NormalizeKubernetesVersion(v.options.KubernetesVersion); k8sVersion != "" {
+		if v.options.Strict {
+			dirs = append(dirs, k8sVersion+"-standalone-strict", k8sVersion+"-standalone")
+		} else {
+			dirs = append(dirs, k8sVersion+"-standalone", k8sVersion+"-standalone-strict")
+		}
+		dirs = append(dirs, k8sVersion)
+	}
+	dirs = append(dirs, "")
+
+	candidates := make([]string, 0, len(dirs)+1)
+	for _, dir := range dirs {
+		candidates = append(candidates, filepath.Join(v.options.SchemaDir, dir, fileName))
+	}
+	if group != "" {
+		candidates = append(candidates, filepath.Join(v.options.SchemaDir, group, kind+"_"+version+".json"))
+	}
+
+	return candidates
+}
+
+// Normalizes a Kubernetes version string into the `vMAJOR.MINOR.PATCH` form used by schema directories.
+// Returns an empty string for an empty version.
+func NormalizeKubernetesVersion(version string) string {
+	version = strings.TrimPrefix(strings.TrimSpace(version), "v")
+	if version == "" {
+		return ""
+	}
+	if version == "master" {
+		return version
+	}
+	if strings.Count(version, ".") == 1 {
+		version += ".0"
+	}
+
+	return "v" + version
+}
+
+// Loads and compiles a schema file, caching the result.
+// Returns nil without an error if the file does not exist.
+func (v *SchemaValidator) loadSchemaFile(file string) (*jsonschema.Schema, error) {
+	if schema, ok := v.fileSchemas[file]; ok {
+		return schema, nil
+	}
+	data, err := os.ReadFile(filepath.Clean(file))
+	if errors.Is(err, os.ErrNotExist) {
+		v.fileSchemas[file] = nil
+
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(data))
+	if err != nil {
+		return nil, util.ErrorIfCheck("error parsing schema "+file, err)
+	}
+	schema, err := compileSchema(file, doc)
+	if err != nil {
+		return nil, util.ErrorIfCheck("error compiling schema "+file, err)
+	}
+	v.fileSchemas[file] = schema
+
+	return schema, nil
+}
+
+// Compiles a schema document.
+// The `$schema` keyword is dropped, Kubernetes schemas reference meta-schemas that are not available offline.
+func compileSchema(location string, doc any) (*jsonschema.Schema, error) {
+	if docMap, ok := doc.(map[string]any); ok {
+		delete(docMap, "$schema")
+	}
+	absLocation, err := filepath.Abs(location)
+	if err != nil {
+		return nil, err
+	}
+	compiler := jsonschema.NewCompiler()
+	compiler.DefaultDraft(jsonschema.Draft7)
+	if err := compiler.AddResource(absLocation, doc); err != nil {
+		return nil, err
+	}
+
+	return compiler.Compile(absLocation)
+}
+
+// Adds the schemas for every served version of a CRD.
+func (v *SchemaValidator) addCRD(obj Object) error {
+	spec, _ := obj.Data["spec"].(map[string]any)
+	group, _ := spec["group"].(string)
+	names, _ := spec["names"].(map[string]any)
+	kind, _ := names["kind"].(string)
+	versions, _ := spec["versions"].([]any)
+	if group == "" || kind == "" {
+		return util.ErrorIfCheck("CRD is missing spec.group or spec.names.kind", os.ErrInvalid)
+	}
+
+	for _, rawVersion := range versions {
+		version, _ := rawVersion.(map[string]any)
+		name, _ := version["name"].(string)
+		schemaObj, _ := version["schema"].(map[string]any)
+		openAPISchema, ok := schemaObj["openAPIV3Schema"].(map[string]any)
+		if name == "" || !ok {
+			continue
+		}
+		doc, err := convertOpenAPISchema(openAPISchema, v.options.Strict)
+		if err != nil {
+			return err
+		}
+		schema, err := compileSchema(filepath.Join("crds", group, kind+"_"+name+".json"), doc)
+		if err != nil {
+			return err
+		}
+		v.crdSchemas[crdKey(group, name, kind)] = schema
+	}
+
+	return nil
+}
+
+// Converts a CRD OpenAPI v3 schema into a JSON schema document.
+// `nullable` is translated to a null type.
+// In strict mode, objects with declared properties reject unknown fields,
+// unless they preserve unknown fields.
+func convertOpenAPISchema(openAPISchema map[string]any, strict bool) (any, error) {
+	// deep copy through json so the original object is left untouched
+	raw, err := json.Marshal(openAPISchema)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(raw))
+	if err != nil {
+		return nil, err
+	}
+	root, ok := doc.(map[string]any)
+	if !ok {
+		return doc, nil
+	}
+	if strict {
+		// The top level object always allows the standard object fields
+		props, _ := root["properties"].(map[string]any)
+		if props != nil {
+			for _, field := range []string{"apiVersion", "kind", "metadata"} {
+				if _, ok := props[field]; !ok {
+					props[field] = map[string]any{}
+				}
+			}
+		}
+	}
+	convertOpenAPINode(root, strict)
+
+	return root, nil
+}
+
+// Converts a schema node of a CRD OpenAPI v3 schema in place.
+// Only schema positions are followed, so fields named like schema keywords,
+// such as a field called `properties`, are converted as fields.
+func convertOpenAPINode(node any, strict bool) {
+	val, ok := node.(map[string]any)
+	if !ok {
+		return
+	}
+	if nullable, _ := val["nullable"].(bool); nullable {
+		if typeName, ok := val["type"].(string); ok {
+			val["type"] = []any{typeName, "null"}
+		}
+	}
+	_, hasProps := val["properties"]
+	_, hasAdditional := val["additionalProperties"]
+	preserve, _ := val["x-kubernetes-preserve-unknown-fields"].(bool)
+	if strict && hasProps && !hasAdditional && !preserve {
+		val["additionalProperties"] = false
+	}
+	for _, keyword := range []string{"properties", "patternProperties", "definitions"} {
+		if schemas, ok := val[keyword].(map[string]any); ok {
+			for _, child := range schemas {
+				convertOpenAPINode(child, strict)
+			}
+		}
+	}
+	for _, keyword := range []string{"items", "additionalProperties", "not"} {
+		convertOpenAPINode(val[keyword], strict)
+	}
+	if items, ok := val["items"].([]any); ok {
+		for _, child := range items {
+			convertOpenAPINode(child, strict)
+		}
+	}
+	for _, keyword := range []string{"allOf", "anyOf", "oneOf"} {
+		if schemas, ok := val[keyword].([]any); ok {
+			for _, child := range schemas {
+				convertOpenAPINode(child, strict)
+			}
+		}
+	}
+}
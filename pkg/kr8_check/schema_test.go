@@ -0,0 +1,199 @@
+package kr8_check_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ice-bergtech/kr8/pkg/kr8_check"
+)
+
+const deploymentSchema = `{
+  "$schema": "http://json-schema.org/schema#",
+  "type": "object",
+  "additionalProperties": false,
+  "properties": {
+    "apiVersion": {"type": "string"},
+    "kind": {"type": "string"},
+    "metadata": {"type": "object"},
+    "spec": {
+      "type": "object",
+      "additionalProperties": false,
+      "properties": {
+        "replicas": {"type": "integer"},
+        "containers": {"type": "array"}
+      }
+    }
+  }
+}`
+
+const crdManifest = `apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+metadata:
+  name: widgets.example.com
+spec:
+  group: example.com
+  names:
+    kind: Widget
+  versions:
+  - name: v1
+    schema:
+      openAPIV3Schema:
+        type: object
+        properties:
+          spec:
+            type: object
+            properties:
+              size:
+                type: integer
+              labels:
+                type: object
+                x-kubernetes-preserve-unknown-fields: true
+                properties:
+                  properties:
+                    type: object
+                    additionalProperties:
+                      type: string
+`
+
+func parseTestObject(t *testing.T, component string, manifest string) kr8_check.Object {
+	t.Helper()
+	objects, err := kr8_check.ParseObjects([]byte(manifest))
+	if err != nil || len(objects) != 1 {
+		t.Fatalf("ParseObjects() = %v, %v", objects, err)
+	}
+
+	return kr8_check.Object{Component: component, File: component + "/file.yaml", Index: 0, Data: objects[0]}
+}
+
+func TestSchemaValidator(t *testing.T) {
+	schemaDir := t.TempDir()
+	versionDir := filepath.Join(schemaDir, "v1.29.0-standalone-strict")
+	if err := os.MkdirAll(versionDir, 0750); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(versionDir, "deployment-apps-v1.json"), []byte(deploymentSchema), 0600); err != nil {
+		t.Fatal(err)
+	}
+	crd := parseTestObject(t, "crds", crdManifest)
+
+	tests := []struct {
+		name          string
+		manifest      string
+		strict        bool
+		ignoreMissing bool
+		wantFindings  int
+		wantSeverity  kr8_check.Severity
+	}{
+		{
+			name:         "valid deployment",
+			manifest:     "apiVersion: apps/v1\nkind: Deployment\nmetadata: {name: app}\nspec: {replicas: 2}\n",
+			wantFindings: 0,
+		},
+		{
+			name:         "deployment with typo",
+			manifest:     "apiVersion: apps/v1\nkind: Deployment\nmetadata: {name: app}\nspec: {contianers: []}\n",
+			wantFindings: 1,
+			wantSeverity: kr8_check.SeverityDeny,
+		},
+		{
+			name:         "deployment with wrong type",
+			manifest:     "apiVersion: apps/v1\nkind: Deployment\nmetadata: {name: app}\nspec: {replicas: two}\n",
+			wantFindings: 1,
+			wantSeverity: kr8_check.SeverityDeny,
+		},
+		{
+			name:         "missing schema",
+			manifest:     "apiVersion: v1\nkind: Service\nmetadata: {name: app}\n",
+			wantFindings: 1,
+			wantSeverity: kr8_check.SeverityWarn,
+		},
+		{
+			name:          "missing schema ignored",
+			manifest:      "apiVersion: v1\nkind: Service\nmetadata: {name: app}\n",
+			ignoreMissing: true,
+			wantFindings:  0,
+		},
+		{
+			name:         "custom resource from generated CRD",
+			manifest:     "apiVersion: example.com/v1\nkind: Widget\nmetadata: {name: w}\nspec: {size: 3}\n",
+			wantFindings: 0,
+		},
+		{
+			name:         "custom resource unknown field allowed when not strict",
+			manifest:     "apiVersion: example.com/v1\nkind: Widget\nmetadata: {name: w}\nspec: {sise: 3}\n",
+			wantFindings: 0,
+		},
+		{
+			name:         "custom resource unknown field rejected when strict",
+			manifest:     "apiVersion: example.com/v1\nkind: Widget\nmetadata: {name: w}\nspec: {sise: 3}\n",
+			strict:       true,
+			wantFindings: 1,
+			wantSeverity: kr8_check.SeverityDeny,
+		},
+		{
+			name: "custom resource field named properties when strict",
+			manifest: "apiVersion: example.com/v1\nkind: Widget\nmetadata: {name: w}\n" +
+				"spec: {labels: {properties: {team: web}, additionalProperties: kept}}\n",
+			strict:       true,
+			wantFindings: 0,
+		},
+		{
+			name: "custom resource field named properties with wrong type",
+			manifest: "apiVersion: example.com/v1\nkind: Widget\nmetadata: {name: w}\n" +
+				"spec: {labels: {properties: {team: 3}}}\n",
+			strict:       true,
+			wantFindings: 1,
+			wantSeverity: kr8_check.SeverityDeny,
+		},
+	}
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			validator, crdFindings := kr8_check.NewSchemaValidator(kr8_check.SchemaOptions{
+				SchemaDir:         schemaDir,
+				KubernetesVersion: "1.29",
+				Strict:            testCase.strict,
+				IgnoreMissing:     testCase.ignoreMissing,
+			}, []kr8_check.Object{crd})
+			if len(crdFindings) > 0 {
+				t.Fatalf("NewSchemaValidator() findings = %v", crdFindings)
+			}
+			got := validator.Validate(parseTestObject(t, "comp", testCase.manifest))
+			if len(got) != testCase.wantFindings {
+				t.Fatalf("Validate() = %v, want %d findings", got, testCase.wantFindings)
+			}
+			for _, finding := range got {
+				if finding.Severity != testCase.wantSeverity {
+					t.Errorf("Validate() severity = %s, want %s", finding.Severity, testCase.wantSeverity)
+				}
+				if finding.Component != "comp" || finding.File != "comp/file.yaml" {
+					t.Errorf("Validate() context = %s %s", finding.Component, finding.File)
+				}
+			}
+		})
+	}
+}
+
+func TestParseObjects(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  int
+	}{
+		{name: "yaml stream", input: "apiVersion: v1\nkind: A\n---\napiVersion: v1\nkind: B\n", want: 2},
+		{name: "skips non objects", input: "foo: bar\n---\n- 1\n---\napiVersion: v1\nkind: B\n", want: 1},
+		{name: "json list", input: `[{"apiVersion": "v1", "kind": "A"}, {"apiVersion": "v1", "kind": "B"}]`, want: 2},
+		{name: "empty", input: "", want: 0},
+	}
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			got, err := kr8_check.ParseObjects([]byte(testCase.input))
+			if err != nil {
+				t.Fatalf("ParseObjects() failed: %v", err)
+			}
+			if len(got) != testCase.want {
+				t.Errorf("ParseObjects() = %v, want %d objects", got, testCase.want)
+			}
+		})
+	}
+}
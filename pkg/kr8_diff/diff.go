@@ -0,0 +1,167 @@
+// Package kr8_diff computes structural differences between JSON values,
+// such as the params or generated objects of two clusters.
+//
+// Objects are compared key by key. Other values, including arrays, are compared whole.
+// Each difference is reported with the gjson path of the changed value.
+package kr8_diff
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"github.com/tidwall/gjson"
+
+	"github.com/ice-bergtech/kr8/pkg/types"
+)
+
+// The type of a change between two values.
+type ChangeType string
+
+const (
+	// The value is only in the second input
+	ChangeAdded ChangeType = "added"
+	// The value is only in the first input
+	ChangeRemoved ChangeType = "removed"
+	// The value differs between the inputs
+	ChangeModified ChangeType = "changed"
+)
+
+// A difference between two values.
+type Change struct {
+	// gjson path of the value. Empty if the inputs differ as a whole
+	Path string `json:"path"`
+	// How the value changed
+	Type ChangeType `json:"type"`
+	// Value in the first input, as compact json
+	Old json.RawMessage `json:"old,omitempty"`
+	// Value in the second input, as compact json
+	New json.RawMessage `json:"new,omitempty"`
+}
+
+// Differences within a named part of the inputs, such as a component.
+type Section struct {
+	// Name of the compared part
+	Name string `json:"name"`
+	// Differences, sorted by path
+	Changes []Change `json:"changes"`
+}
+
+// Computes the differences from a to b, sorted by path.
+// Values missing from an input are reported as added or removed.
+func Diff(a gjson.Result, b gjson.Result) []Change {
+	changes := diff(a, b, nil, []Change{})
+	sort.SliceStable(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+
+	return changes
+}
+
+func diff(a gjson.Result, b gjson.Result, keys []string, changes []Change) []Change {
+	path := joinPath(keys)
+	switch {
+	case !a.Exists() && !b.Exists():
+		return changes
+	case !a.Exists():
+		return append(changes, Change{Path: path, Type: ChangeAdded, Old: nil, New: compact(b.Raw)})
+	case !b.Exists():
+		return append(changes, Change{Path: path, Type: ChangeRemoved, Old: compact(a.Raw), New: nil})
+	case a.IsObject() && b.IsObject():
+		aMap, bMap := a.Map(), b.Map()
+		names := make([]string, 0, len(aMap)+len(bMap))
+		for name := range aMap {
+			names = append(names, name)
+		}
+		for name := range bMap {
+			if _, ok := aMap[name]; !ok {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			childKeys := append(append([]string{}, keys...), name)
+			changes = diff(aMap[name], bMap[name], childKeys, changes)
+		}
+
+		return changes
+	}
+	oldValue, newValue := compact(a.Raw), compact(b.Raw)
+	if bytes.Equal(oldValue, newValue) {
+		return changes
+	}
+
+	return append(changes, Change{Path: path, Type: ChangeModified, Old: oldValue, New: newValue})
+}
+
+// Joins object keys into a gjson path.
+func joinPath(keys []string) string {
+	escaped := make([]string, len(keys))
+	for idx, key := range keys {
+		escaped[idx] = gjson.Escape(key)
+	}
+
+	return strings.Join(escaped, ".")
+}
+
+// Compacts json, so formatting doesn't count as a difference.
+func compact(raw string) json.RawMessage {
+	var buffer bytes.Buffer
+	if err := json.Compact(&buffer, []byte(raw)); err != nil {
+		return json.RawMessage(raw)
+	}
+
+	return buffer.Bytes()
+}
+
+// Replaces a string with another, to normalize expected differences between inputs, such as cluster names.
+type Substitution struct {
+	// String to replace
+	Old string `json:"old"`
+	// Replacement
+	New string `json:"new"`
+}
+
+// Parses a substitution in the form `old=new`.
+func ParseSubstitution(input string) (Substitution, error) {
+	oldValue, newValue, found := strings.Cut(input, "=")
+	if !found || oldValue == "" {
+		return Substitution{Old: "", New: ""}, types.Kr8Error{
+			Message: "invalid substitution, expected old=new",
+			Value:   input,
+		}
+	}
+
+	return Substitution{Old: oldValue, New: newValue}, nil
+}
+
+// Applies substitutions, in order, to every string in a value decoded from json, including object keys.
+func Substitute(value any, substitutions []Substitution) any {
+	if len(substitutions) == 0 {
+		return value
+	}
+	switch val := value.(type) {
+	case string:
+		for _, sub := range substitutions {
+			val = strings.ReplaceAll(val, sub.Old, sub.New)
+		}
+
+		return val
+	case []any:
+		result := make([]any, len(val))
+		for idx, item := range val {
+			result[idx] = Substitute(item, substitutions)
+		}
+
+		return result
+	case map[string]any:
+		result := make(map[string]any, len(val))
+		for key, item := range val {
+			newKey, _ := Substitute(key, substitutions).(string)
+			result[newKey] = Substitute(item, substitutions)
+		}
+
+		return result
+	default:
+		return value
+	}
+}
@@ -0,0 +1,121 @@
+package kr8_diff_test
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/tidwall/gjson"
+
+	"github.com/ice-bergtech/kr8/pkg/kr8_diff"
+)
+
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		name string
+		a    string
+		b    string
+		want []kr8_diff.Change
+	}{
+		{
+			name: "equal with different formatting",
+			a:    `{"a": 1, "b": [1, 2]}`,
+			b:    `{"b":[1,2],"a":1}`,
+			want: []kr8_diff.Change{},
+		},
+		{
+			name: "nested changes",
+			a:    `{"name": "staging", "net": {"cidr": "10.0.0.0/16", "zones": ["a"]}, "old": true}`,
+			b:    `{"name": "prod", "net": {"cidr": "10.0.0.0/16", "zones": ["a", "b"]}, "new": 1}`,
+			want: []kr8_diff.Change{
+				{Path: "name", Type: kr8_diff.ChangeModified, Old: json.RawMessage(`"staging"`), New: json.RawMessage(`"prod"`)},
+				{Path: "net.zones", Type: kr8_diff.ChangeModified, Old: json.RawMessage(`["a"]`), New: json.RawMessage(`["a","b"]`)},
+				{Path: "new", Type: kr8_diff.ChangeAdded, Old: nil, New: json.RawMessage(`1`)},
+				{Path: "old", Type: kr8_diff.ChangeRemoved, Old: json.RawMessage(`true`), New: nil},
+			},
+		},
+		{
+			name: "escaped keys",
+			a:    `{"metadata": {"labels": {"app.kubernetes.io/name": "a"}}}`,
+			b:    `{"metadata": {"labels": {"app.kubernetes.io/name": "b"}}}`,
+			want: []kr8_diff.Change{
+				{
+					Path: `metadata.labels.app\.kubernetes\.io\/name`, Type: kr8_diff.ChangeModified,
+					Old: json.RawMessage(`"a"`), New: json.RawMessage(`"b"`),
+				},
+			},
+		},
+		{
+			name: "object replaced by scalar",
+			a:    `{"a": {"b": 1}}`,
+			b:    `{"a": null}`,
+			want: []kr8_diff.Change{
+				{Path: "a", Type: kr8_diff.ChangeModified, Old: json.RawMessage(`{"b":1}`), New: json.RawMessage(`null`)},
+			},
+		},
+		{
+			name: "missing input",
+			a:    ``,
+			b:    `{"a": 1}`,
+			want: []kr8_diff.Change{{Path: "", Type: kr8_diff.ChangeAdded, Old: nil, New: json.RawMessage(`{"a":1}`)}},
+		},
+	}
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			got := kr8_diff.Diff(gjson.Parse(testCase.a), gjson.Parse(testCase.b))
+			if !reflect.DeepEqual(got, testCase.want) {
+				t.Errorf("Diff() = %+v, want %+v", got, testCase.want)
+			}
+		})
+	}
+}
+
+func TestParseSubstitution(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    kr8_diff.Substitution
+		wantErr bool
+	}{
+		{name: "substitution", input: "staging=prod", want: kr8_diff.Substitution{Old: "staging", New: "prod"}},
+		{name: "empty replacement", input: "-staging=", want: kr8_diff.Substitution{Old: "-staging", New: ""}},
+		{name: "equals in replacement", input: "a=b=c", want: kr8_diff.Substitution{Old: "a", New: "b=c"}},
+		{name: "missing equals", input: "staging", wantErr: true},
+		{name: "empty old", input: "=prod", wantErr: true},
+	}
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			got, err := kr8_diff.ParseSubstitution(testCase.input)
+			if (err != nil) != testCase.wantErr {
+				t.Fatalf("ParseSubstitution(%q) error = %v, wantErr %v", testCase.input, err, testCase.wantErr)
+			}
+			if !testCase.wantErr && got != testCase.want {
+				t.Errorf("ParseSubstitution(%q) = %+v, want %+v", testCase.input, got, testCase.want)
+			}
+		})
+	}
+}
+
+func TestSubstitute(t *testing.T) {
+	input := map[string]any{
+		"name":  "staging-db",
+		"hosts": []any{"db.staging.example.com", float64(5432)},
+		"labels": map[string]any{
+			"staging/tier": "staging",
+		},
+	}
+	substitutions := []kr8_diff.Substitution{
+		{Old: "staging-db", New: "prod-rds"},
+		{Old: "staging", New: "prod"},
+	}
+	want := map[string]any{
+		"name":  "prod-rds",
+		"hosts": []any{"db.prod.example.com", float64(5432)},
+		"labels": map[string]any{
+			"prod/tier": "prod",
+		},
+	}
+	if got := kr8_diff.Substitute(input, substitutions); !reflect.DeepEqual(got, want) {
+		t.Errorf("Substitute() = %v, want %v", got, want)
+	}
+}
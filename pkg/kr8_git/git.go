@@ -0,0 +1,333 @@
+// Package kr8_git commits generated output to a git repository.
+//
+// The output repository is a local clone, such as a "rendered manifests" repository.
+// kr8+ checks out a branch, generates into the repository, then stages and commits only the files it changed.
+// It also lists the files changed in a source repository, see [ChangedFiles].
+// All git operations use go-git, so no git binary is needed.
+package kr8_git
+
+import (
+	"errors"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+
+	"github.com/ice-bergtech/kr8/pkg/types"
+	"github.com/ice-bergtech/kr8/pkg/util"
+)
+
+const (
+	// Name of the remote branches are created from if they don't exist locally.
+	DefaultRemote = "origin"
+	// Author name used if the repository has no `user.name` configured.
+	DefaultAuthorName = "kr8+"
+	// Author email used if the repository has no `user.email` configured.
+	DefaultAuthorEmail = "kr8@localhost"
+	// Maximum number of clusters listed in the commit subject.
+	maxSubjectClusters = 3
+)
+
+// A local git repository generated output is committed to.
+type OutputRepo struct {
+	// Root directory of the repository worktree
+	Dir string
+	// Branch the output is committed to
+	Branch string
+
+	repo     *git.Repository
+	worktree *git.Worktree
+}
+
+// Opens a local git repository and checks out the branch generated output is committed to.
+// If branch is empty, the current branch is used.
+// A branch that doesn't exist is created from the remote branch of the same name if there is one, otherwise from HEAD.
+// In a repository without commits, the branch becomes the initial branch.
+// Switching branches requires a clean worktree.
+func OpenOutputRepo(dir string, branch string) (*OutputRepo, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	repo, err := git.PlainOpen(dir)
+	if err != nil {
+		return nil, types.Kr8Error{Message: "error opening git output repository " + dir, Value: err}
+	}
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return nil, util.ErrorIfCheck("error opening git output worktree", err)
+	}
+	outputRepo := &OutputRepo{Dir: dir, Branch: branch, repo: repo, worktree: worktree}
+
+	head, err := repo.Reference(plumbing.HEAD, false)
+	if err != nil {
+		return nil, util.ErrorIfCheck("error reading git output HEAD", err)
+	}
+	if branch == "" {
+		if head.Type() != plumbing.SymbolicReference {
+			return nil, types.Kr8Error{Message: "git output repository HEAD is detached, set a branch", Value: dir}
+		}
+		outputRepo.Branch = head.Target().Short()
+
+		return outputRepo, nil
+	}
+
+	branchRef := plumbing.NewBranchReferenceName(branch)
+	if head.Type() == plumbing.SymbolicReference && head.Target() == branchRef {
+		return outputRepo, nil
+	}
+	if _, err := repo.Head(); errors.Is(err, plumbing.ErrReferenceNotFound) {
+		// No commits yet, the first commit creates the branch
+		return outputRepo, repo.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, branchRef))
+	}
+
+	status, err := worktree.Status()
+	if err != nil {
+		return nil, util.ErrorIfCheck("error reading git output status", err)
+	}
+	if !status.IsClean() {
+		return nil, types.Kr8Error{Message: "git output repository has uncommitted changes, can't switch to branch", Value: branch}
+	}
+	//nolint:exhaustruct
+	checkout := &git.CheckoutOptions{Branch: branchRef}
+	if _, err := repo.Reference(branchRef, true); err != nil {
+		checkout.Create = true
+		remoteRef, err := repo.Reference(plumbing.NewRemoteReferenceName(DefaultRemote, branch), true)
+		if err == nil {
+			checkout.Hash = remoteRef.Hash()
+		}
+	}
+	if err := worktree.Checkout(checkout); err != nil {
+		return nil, types.Kr8Error{Message: "error checking out git output branch", Value: err}
+	}
+
+	return outputRepo, nil
+}
+
+// Stages the changes to files within a directory of the repository.
+// Changes outside the directory are left alone, but changes that are already staged outside it are an error,
+// as they would be committed along with the output.
+// Returns the changed paths relative to dir, sorted.
+func (o *OutputRepo) StageChanges(dir string) ([]string, error) {
+	prefix, err := filepath.Rel(o.Dir, dir)
+	if err != nil || strings.HasPrefix(prefix, "..") {
+		return nil, types.Kr8Error{Message: "output directory is not in the git output repository", Value: dir}
+	}
+	prefix = filepath.ToSlash(prefix)
+	inOutputDir := func(path string) bool {
+		return prefix == "." || path == prefix || strings.HasPrefix(path, prefix+"/")
+	}
+
+	status, err := o.worktree.Status()
+	if err != nil {
+		return nil, util.ErrorIfCheck("error reading git output status", err)
+	}
+	changed := []string{}
+	for path, fileStatus := range status {
+		if !inOutputDir(path) {
+			if fileStatus.Staging != git.Unmodified && fileStatus.Staging != git.Untracked {
+				return nil, types.Kr8Error{Message: "git output repository has staged changes outside the output directory", Value: path}
+			}
+
+			continue
+		}
+		switch fileStatus.Worktree {
+		case git.Unmodified:
+			if fileStatus.Staging == git.Unmodified {
+				continue
+			}
+		case git.Deleted:
+			if _, err := o.worktree.Remove(path); err != nil {
+				return nil, util.ErrorIfCheck("error staging removal of "+path, err)
+			}
+		default:
+			if _, err := o.worktree.Add(path); err != nil {
+				return nil, util.ErrorIfCheck("error staging "+path, err)
+			}
+		}
+		relPath := path
+		if prefix != "." {
+			relPath = strings.TrimPrefix(path, prefix+"/")
+		}
+		changed = append(changed, relPath)
+	}
+	sort.Strings(changed)
+
+	return changed, nil
+}
+
+// Commits the staged changes.
+// The author is read from the repository's git config, falling back to a kr8+ author.
+func (o *OutputRepo) Commit(message string) (plumbing.Hash, error) {
+	author := &object.Signature{Name: DefaultAuthorName, Email: DefaultAuthorEmail, When: time.Now()}
+	if cfg, err := o.repo.ConfigScoped(config.SystemScope); err == nil && cfg.User.Name != "" && cfg.User.Email != "" {
+		author.Name = cfg.User.Name
+		author.Email = cfg.User.Email
+	}
+	//nolint:exhaustruct
+	hash, err := o.worktree.Commit(message, &git.CommitOptions{Author: author})
+	if err != nil {
+		return plumbing.ZeroHash, util.ErrorIfCheck("error committing generated output", err)
+	}
+
+	return hash, nil
+}
+
+// Describes the commit checked out in the repository containing dir.
+// The hash is suffixed with `-dirty` if the worktree has uncommitted changes.
+// Returns an empty string if dir is not in a git repository.
+func SourceRevision(dir string) string {
+	//nolint:exhaustruct
+	repo, err := git.PlainOpenWithOptions(dir, &git.PlainOpenOptions{DetectDotGit: true})
+	if err != nil {
+		return ""
+	}
+	head, err := repo.Head()
+	if err != nil {
+		return ""
+	}
+	revision := head.Hash().String()
+	if worktree, err := repo.Worktree(); err == nil {
+		if status, err := worktree.Status(); err == nil && !status.IsClean() {
+			revision += "-dirty"
+		}
+	}
+
+	return revision
+}
+
+// Lists the files changed in the repository containing dir since a revision.
+// Includes the files changed between the merge base of since and HEAD, and HEAD,
+// plus uncommitted and untracked changes in the worktree.
+// An empty since only lists uncommitted changes.
+// Returns sorted absolute paths, deleted and renamed files are listed by their old and new path.
+func ChangedFiles(dir string, since string) ([]string, error) {
+	//nolint:exhaustruct
+	repo, err := git.PlainOpenWithOptions(dir, &git.PlainOpenOptions{DetectDotGit: true})
+	if err != nil {
+		return nil, types.Kr8Error{Message: "error opening git repository " + dir, Value: err}
+	}
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return nil, util.ErrorIfCheck("error opening git worktree", err)
+	}
+	root := worktree.Filesystem.Root()
+	changed := map[string]bool{}
+
+	if since != "" {
+		sinceHash, err := repo.ResolveRevision(plumbing.Revision(since))
+		if err != nil {
+			return nil, types.Kr8Error{Message: "error resolving git revision " + since, Value: err}
+		}
+		head, err := repo.Head()
+		if err != nil {
+			return nil, util.ErrorIfCheck("error reading git HEAD", err)
+		}
+		committed, err := changedSince(repo, *sinceHash, head.Hash())
+		if err != nil {
+			return nil, err
+		}
+		for _, path := range committed {
+			changed[path] = true
+		}
+	}
+
+	status, err := worktree.Status()
+	if err != nil {
+		return nil, util.ErrorIfCheck("error reading git status", err)
+	}
+	for path, fileStatus := range status {
+		if fileStatus.Worktree != git.Unmodified || fileStatus.Staging != git.Unmodified {
+			changed[path] = true
+		}
+	}
+
+	files := make([]string, 0, len(changed))
+	for path := range changed {
+		files = append(files, filepath.Join(root, filepath.FromSlash(path)))
+	}
+	sort.Strings(files)
+
+	return files, nil
+}
+
+// Lists the paths changed between the merge base of two commits and the second commit.
+func changedSince(repo *git.Repository, since plumbing.Hash, head plumbing.Hash) ([]string, error) {
+	sinceCommit, err := repo.CommitObject(since)
+	if err != nil {
+		return nil, util.ErrorIfCheck("error reading commit "+since.String(), err)
+	}
+	headCommit, err := repo.CommitObject(head)
+	if err != nil {
+		return nil, util.ErrorIfCheck("error reading commit "+head.String(), err)
+	}
+	bases, err := sinceCommit.MergeBase(headCommit)
+	if err != nil {
+		return nil, util.ErrorIfCheck("error finding merge base", err)
+	}
+	if len(bases) > 0 {
+		sinceCommit = bases[0]
+	}
+	sinceTree, err := sinceCommit.Tree()
+	if err != nil {
+		return nil, util.ErrorIfCheck("error reading commit tree", err)
+	}
+	headTree, err := headCommit.Tree()
+	if err != nil {
+		return nil, util.ErrorIfCheck("error reading commit tree", err)
+	}
+	changes, err := object.DiffTree(sinceTree, headTree)
+	if err != nil {
+		return nil, util.ErrorIfCheck("error comparing commits", err)
+	}
+	paths := make([]string, 0, len(changes))
+	for _, change := range changes {
+		if change.From.Name != "" {
+			paths = append(paths, change.From.Name)
+		}
+		if change.To.Name != "" && change.To.Name != change.From.Name {
+			paths = append(paths, change.To.Name)
+		}
+	}
+
+	return paths, nil
+}
+
+// Builds the message of a generated output commit.
+// affected maps each changed cluster to its changed components.
+// source is the revision of the kr8+ configuration the output was generated from, if known.
+func CommitMessage(affected map[string][]string, source string) string {
+	clusters := make([]string, 0, len(affected))
+	for cluster := range affected {
+		clusters = append(clusters, cluster)
+	}
+	sort.Strings(clusters)
+
+	var message strings.Builder
+	if len(clusters) <= maxSubjectClusters {
+		message.WriteString("Update generated output for " + strings.Join(clusters, ", ") + "\n")
+	} else {
+		message.WriteString("Update generated output for " + strconv.Itoa(len(clusters)) + " clusters\n")
+	}
+	if source != "" {
+		message.WriteString("\nSource: " + source + "\n")
+	}
+	message.WriteString("\nClusters:\n")
+	for _, cluster := range clusters {
+		components := append([]string{}, affected[cluster]...)
+		sort.Strings(components)
+		if len(components) == 0 {
+			message.WriteString("- " + cluster + "\n")
+		} else {
+			message.WriteString("- " + cluster + ": " + strings.Join(components, ", ") + "\n")
+		}
+	}
+
+	return message.String()
+}
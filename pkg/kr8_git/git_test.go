@@ -0,0 +1,281 @@
+package kr8_git_test
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+
+	"github.com/ice-bergtech/kr8/pkg/kr8_git"
+)
+
+// Creates a local bare repository, and a repository with the bare repository as its origin.
+// Returns the paths of the bare repository and the repository.
+func initBareRepo(t *testing.T) (string, string) {
+	t.Helper()
+	bareDir := filepath.Join(t.TempDir(), "rendered.git")
+	if _, err := git.PlainInit(bareDir, true); err != nil {
+		t.Fatal(err)
+	}
+	repoDir := filepath.Join(t.TempDir(), "rendered")
+	repo, err := git.PlainInit(repoDir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//nolint:exhaustruct
+	if _, err := repo.CreateRemote(&config.RemoteConfig{Name: kr8_git.DefaultRemote, URLs: []string{bareDir}}); err != nil {
+		t.Fatal(err)
+	}
+
+	return bareDir, repoDir
+}
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestOutputRepo(t *testing.T) {
+	bareDir, repoDir := initBareRepo(t)
+	outputDir := filepath.Join(repoDir, "generated")
+
+	tests := []struct {
+		name        string
+		branch      string
+		write       map[string]string
+		remove      []string
+		wantChanged []string
+	}{
+		{
+			name:   "initial commit",
+			branch: "rendered",
+			write: map[string]string{
+				"generated/dev/web/web.yaml": "kind: Deployment\n",
+				"generated/dev/db/db.yaml":   "kind: StatefulSet\n",
+				"notes.txt":                  "not generated\n",
+			},
+			remove:      nil,
+			wantChanged: []string{"dev/db/db.yaml", "dev/web/web.yaml"},
+		},
+		{
+			name:        "modified and removed files",
+			branch:      "rendered",
+			write:       map[string]string{"generated/dev/web/web.yaml": "kind: Deployment\nreplicas: 2\n"},
+			remove:      []string{"generated/dev/db/db.yaml"},
+			wantChanged: []string{"dev/db/db.yaml", "dev/web/web.yaml"},
+		},
+		{
+			name:        "unchanged",
+			branch:      "rendered",
+			write:       nil,
+			remove:      nil,
+			wantChanged: []string{},
+		},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			outputRepo, err := kr8_git.OpenOutputRepo(repoDir, testCase.branch)
+			if err != nil {
+				t.Fatalf("OpenOutputRepo() error = %v", err)
+			}
+			writeFiles(t, repoDir, testCase.write)
+			for _, name := range testCase.remove {
+				if err := os.Remove(filepath.Join(repoDir, name)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			changed, err := outputRepo.StageChanges(outputDir)
+			if err != nil {
+				t.Fatalf("StageChanges() error = %v", err)
+			}
+			if !reflect.DeepEqual(changed, testCase.wantChanged) {
+				t.Fatalf("StageChanges() = %v, want %v", changed, testCase.wantChanged)
+			}
+			if len(changed) == 0 {
+				return
+			}
+			if _, err := outputRepo.Commit("update " + testCase.name); err != nil {
+				t.Fatalf("Commit() error = %v", err)
+			}
+		})
+	}
+
+	repo, err := git.PlainOpen(repoDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	head, err := repo.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if head.Name().Short() != "rendered" {
+		t.Errorf("HEAD = %s, want rendered", head.Name().Short())
+	}
+	commit, err := repo.CommitObject(head.Hash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tree.File("notes.txt"); err == nil {
+		t.Error("files outside the output directory were committed")
+	}
+	if _, err := tree.File("generated/dev/db/db.yaml"); err == nil {
+		t.Error("removed file is still committed")
+	}
+	if file, err := tree.File("generated/dev/web/web.yaml"); err != nil {
+		t.Errorf("generated file is not committed: %v", err)
+	} else if content, _ := file.Contents(); !strings.Contains(content, "replicas: 2") {
+		t.Errorf("generated file content = %q", content)
+	}
+
+	// Switching branches requires a clean worktree
+	if _, err := kr8_git.OpenOutputRepo(repoDir, "staging"); err == nil {
+		t.Error("OpenOutputRepo() switched branches with uncommitted changes")
+	}
+
+	// A branch that only exists on the remote is checked out from it, not from HEAD.
+	// The remote default branch is the first commit, the remote rendered branch the latest.
+	if err := repo.Storer.SetReference(plumbing.NewHashReference("refs/heads/base", commit.ParentHashes[0])); err != nil {
+		t.Fatal(err)
+	}
+	//nolint:exhaustruct
+	err = repo.Push(&git.PushOptions{
+		RemoteName: kr8_git.DefaultRemote,
+		RefSpecs:   []config.RefSpec{"refs/heads/rendered:refs/heads/rendered", "refs/heads/base:refs/heads/master"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cloneDir := filepath.Join(t.TempDir(), "clone")
+	//nolint:exhaustruct
+	clone, err := git.PlainClone(cloneDir, false, &git.CloneOptions{URL: bareDir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := kr8_git.OpenOutputRepo(cloneDir, "rendered"); err != nil {
+		t.Fatalf("OpenOutputRepo() error = %v", err)
+	}
+	cloneHead, err := clone.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cloneHead.Name().Short() != "rendered" || cloneHead.Hash() != head.Hash() {
+		t.Errorf("OpenOutputRepo() checked out %s at %s, want rendered at %s",
+			cloneHead.Name().Short(), cloneHead.Hash(), head.Hash())
+	}
+}
+
+func TestCommitMessage(t *testing.T) {
+	got := kr8_git.CommitMessage(map[string][]string{"prod": {"web"}, "dev": {"web", "db"}}, "abc123")
+	want := `Update generated output for dev, prod
+
+Source: abc123
+
+Clusters:
+- dev: db, web
+- prod: web
+`
+	if got != want {
+		t.Errorf("CommitMessage() = %q, want %q", got, want)
+	}
+}
+
+func TestChangedFiles(t *testing.T) {
+	repoDir := t.TempDir()
+	repo, err := git.PlainInit(repoDir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	worktree, err := repo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	commit := func(message string) {
+		t.Helper()
+		//nolint:exhaustruct
+		if err := worktree.AddWithOptions(&git.AddOptions{All: true}); err != nil {
+			t.Fatal(err)
+		}
+		//nolint:exhaustruct
+		if _, err := worktree.Commit(message, &git.CommitOptions{
+			Author: &object.Signature{Name: "test", Email: "test@localhost", When: time.Now()},
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	writeFiles(t, repoDir, map[string]string{
+		"clusters/params.jsonnet":    "{}",
+		"components/web/web.jsonnet": "{}",
+		"lib/labels.libsonnet":       "{}",
+	})
+	commit("initial")
+	head, err := repo.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Storer.SetReference(plumbing.NewHashReference("refs/heads/main", head.Hash())); err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, repoDir, map[string]string{"lib/labels.libsonnet": "{ app: 'web' }"})
+	if err := os.Remove(filepath.Join(repoDir, "clusters/params.jsonnet")); err != nil {
+		t.Fatal(err)
+	}
+	commit("change labels")
+	writeFiles(t, repoDir, map[string]string{"components/db/db.jsonnet": "{}"})
+
+	tests := []struct {
+		name  string
+		since string
+		want  []string
+	}{
+		{
+			name:  "committed and uncommitted changes",
+			since: "main",
+			want:  []string{"clusters/params.jsonnet", "components/db/db.jsonnet", "lib/labels.libsonnet"},
+		},
+		{
+			name:  "uncommitted changes only",
+			since: "",
+			want:  []string{"components/db/db.jsonnet"},
+		},
+	}
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			got, err := kr8_git.ChangedFiles(filepath.Join(repoDir, "components"), testCase.since)
+			if err != nil {
+				t.Fatalf("ChangedFiles() error = %v", err)
+			}
+			want := make([]string, len(testCase.want))
+			for i, file := range testCase.want {
+				want[i] = filepath.Join(repoDir, file)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("ChangedFiles() = %v, want %v", got, want)
+			}
+		})
+	}
+
+	if _, err := kr8_git.ChangedFiles(repoDir, "missing"); err == nil {
+		t.Error("ChangedFiles() resolved a missing revision")
+	}
+}
@@ -22,11 +22,20 @@ func GenerateComponentJsonnet(componentOptions Kr8InitOptions, dstDir string) er
 		Kr8Spec: kr8_types.Kr8ComponentSpec{
 			Kr8_allParams:         false,
 			Kr8_allClusters:       false,
+			Kr8_outputs:           false,
 			DisableOutputDirClean: false,
 			Includes:              []kr8_types.Kr8ComponentSpecIncludeObject{},
 			ExtFiles:              map[string]string{},
 			JPaths:                []string{},
 			DisableCache:          false,
+			CommonLabels:          nil,
+			CommonAnnotations:     nil,
+			InjectNamespace:       nil,
+			PostProcessors:        nil,
+			ConfigHash:            "",
+			DependsOn:             nil,
+			ArgoCD:                nil,
+			Labels:                nil,
 		},
 		ReleaseName: strings.ReplaceAll(componentOptions.ComponentName, "_", "-"),
 		Namespace:   "default",
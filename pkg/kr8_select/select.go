@@ -0,0 +1,453 @@
+// Package kr8_select parses and evaluates selector expressions over cluster parameters.
+//
+// A selector combines conditions on gjson paths of an object:
+//
+//	env=prod && region~^us- && !(tier=legacy)
+//	replicas>=3 || exists(canary)
+//	size in (small, 'extra small')
+//
+// Conditions are joined with `&&` (AND), `||` or `,` (OR) and negated with `!`, grouped with parentheses.
+// `&&` binds tighter than `||`.
+// A term without an operator matches clusters whose `name` contains it, as in earlier kr8+ filters,
+// so comma separated filters such as `name=prod,region~^us-` keep their meaning.
+package kr8_select
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/tidwall/gjson"
+
+	"github.com/ice-bergtech/kr8/pkg/types"
+)
+
+// A parsed selector expression.
+type Selector struct {
+	source string
+	root   node
+}
+
+// Parses a selector expression.
+// Errors report the position of the problem in the expression.
+func Parse(expression string) (*Selector, error) {
+	p := &parser{input: expression, pos: 0}
+	p.skipSpace()
+	if p.done() {
+		return nil, p.errorf("empty selector")
+	}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if !p.done() {
+		return nil, p.errorf("unexpected %q", p.input[p.pos:])
+	}
+
+	return &Selector{source: expression, root: root}, nil
+}
+
+// Checks if an object matches the selector.
+func (s *Selector) Match(input gjson.Result) bool {
+	return s.root.match(input)
+}
+
+// Returns the expression the selector was parsed from.
+func (s *Selector) String() string {
+	return s.source
+}
+
+// A node of a parsed selector expression.
+type node interface {
+	match(input gjson.Result) bool
+}
+
+type orNode []node
+
+func (n orNode) match(input gjson.Result) bool {
+	for _, child := range n {
+		if child.match(input) {
+			return true
+		}
+	}
+
+	return false
+}
+
+type andNode []node
+
+func (n andNode) match(input gjson.Result) bool {
+	for _, child := range n {
+		if !child.match(input) {
+			return false
+		}
+	}
+
+	return true
+}
+
+type notNode struct {
+	child node
+}
+
+func (n notNode) match(input gjson.Result) bool {
+	return !n.child.match(input)
+}
+
+type existsNode struct {
+	path string
+}
+
+func (n existsNode) match(input gjson.Result) bool {
+	return input.Get(n.path).Exists()
+}
+
+// Matches objects whose name contains a string.
+type nameNode struct {
+	substring string
+}
+
+func (n nameNode) match(input gjson.Result) bool {
+	return strings.Contains(input.Get("name").String(), n.substring)
+}
+
+type equalNode struct {
+	path  string
+	value string
+}
+
+func (n equalNode) match(input gjson.Result) bool {
+	return input.Get(n.path).String() == n.value
+}
+
+type regexNode struct {
+	path  string
+	regex *regexp.Regexp
+}
+
+func (n regexNode) match(input gjson.Result) bool {
+	return n.regex.MatchString(input.Get(n.path).String())
+}
+
+type inNode struct {
+	path   string
+	values []string
+}
+
+func (n inNode) match(input gjson.Result) bool {
+	value := input.Get(n.path).String()
+	for _, candidate := range n.values {
+		if value == candidate {
+			return true
+		}
+	}
+
+	return false
+}
+
+type compareNode struct {
+	path  string
+	op    string
+	value float64
+}
+
+func (n compareNode) match(input gjson.Result) bool {
+	result := input.Get(n.path)
+	if !result.Exists() {
+		return false
+	}
+	number := result.Num
+	if result.Type != gjson.Number {
+		var err error
+		number, err = strconv.ParseFloat(strings.TrimSpace(result.String()), 64)
+		if err != nil {
+			return false
+		}
+	}
+	switch n.op {
+	case "<":
+		return number < n.value
+	case "<=":
+		return number <= n.value
+	case ">":
+		return number > n.value
+	default:
+		return number >= n.value
+	}
+}
+
+// A recursive descent parser over the selector expression.
+type parser struct {
+	input string
+	pos   int
+}
+
+func (p *parser) errorf(format string, args ...any) error {
+	return types.Kr8Error{
+		Message: "invalid selector, " + fmt.Sprintf(format, args...) + " at position " + strconv.Itoa(p.pos+1),
+		Value:   p.input,
+	}
+}
+
+func (p *parser) done() bool {
+	return p.pos >= len(p.input)
+}
+
+func (p *parser) skipSpace() {
+	for !p.done() && isSpace(p.input[p.pos]) {
+		p.pos++
+	}
+}
+
+// Consumes a token if the input continues with it, after any whitespace.
+func (p *parser) accept(token string) bool {
+	p.skipSpace()
+	if strings.HasPrefix(p.input[p.pos:], token) {
+		p.pos += len(token)
+
+		return true
+	}
+
+	return false
+}
+
+// or := and { ("||" | ",") and }.
+func (p *parser) parseOr() (node, error) {
+	children := orNode{}
+	for {
+		child, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, child)
+		if !p.accept("||") && !p.accept(",") {
+			break
+		}
+	}
+	if len(children) == 1 {
+		return children[0], nil
+	}
+
+	return children, nil
+}
+
+// and := unary { "&&" unary }.
+func (p *parser) parseAnd() (node, error) {
+	children := andNode{}
+	for {
+		child, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, child)
+		if !p.accept("&&") {
+			break
+		}
+	}
+	if len(children) == 1 {
+		return children[0], nil
+	}
+
+	return children, nil
+}
+
+// unary := "!" unary | "(" or ")" | "exists(" path ")" | condition.
+func (p *parser) parseUnary() (node, error) {
+	if p.accept("!") {
+		child, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+
+		return notNode{child: child}, nil
+	}
+	if p.accept("(") {
+		child, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.accept(")") {
+			return nil, p.errorf("expected \")\"")
+		}
+
+		return child, nil
+	}
+
+	return p.parseCondition()
+}
+
+// condition := path [ operator value | "in" "(" value { "," value } ")" ].
+func (p *parser) parseCondition() (node, error) {
+	p.skipSpace()
+	path := p.readPath()
+	if path == "" {
+		if p.done() {
+			return nil, p.errorf("expected a condition")
+		}
+
+		return nil, p.errorf("expected a condition, found %q", p.input[p.pos:p.pos+1])
+	}
+	if path == "exists" && p.accept("(") {
+		p.skipSpace()
+		path = p.readPath()
+		if path == "" {
+			return nil, p.errorf("expected a path in exists()")
+		}
+		if !p.accept(")") {
+			return nil, p.errorf("expected \")\"")
+		}
+
+		return existsNode{path: path}, nil
+	}
+
+	afterPath := p.pos
+	p.skipSpace()
+	if p.readPath() == "in" {
+		return p.parseIn(path)
+	}
+	p.pos = afterPath
+	p.skipSpace()
+
+	// Longer operators first, so `<=` isn't read as `<`
+	for _, op := range []string{"!=", "!~", "<=", ">=", "=", "~", "<", ">"} {
+		if !strings.HasPrefix(p.input[p.pos:], op) {
+			continue
+		}
+		p.pos += len(op)
+		valueStart := p.pos
+		value, err := p.readValue()
+		if err != nil {
+			return nil, err
+		}
+
+		return p.buildCondition(path, op, value, valueStart)
+	}
+	p.pos = afterPath
+
+	return nameNode{substring: path}, nil
+}
+
+// Builds the node of a `path op value` condition.
+func (p *parser) buildCondition(path string, op string, value string, valueStart int) (node, error) {
+	switch op {
+	case "=":
+		return equalNode{path: path, value: value}, nil
+	case "!=":
+		return notNode{child: equalNode{path: path, value: value}}, nil
+	case "~", "!~":
+		regex, err := regexp.Compile(value)
+		if err != nil {
+			p.pos = valueStart
+
+			return nil, p.errorf("invalid regular expression %q: %v", value, err)
+		}
+		if op == "!~" {
+			return notNode{child: regexNode{path: path, regex: regex}}, nil
+		}
+
+		return regexNode{path: path, regex: regex}, nil
+	default:
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			p.pos = valueStart
+
+			return nil, p.errorf("expected a number after %q, found %q", op, value)
+		}
+
+		return compareNode{path: path, op: op, value: number}, nil
+	}
+}
+
+// Parses the value list of `path in (value, ...)`, after the `in` keyword.
+func (p *parser) parseIn(path string) (node, error) {
+	if !p.accept("(") {
+		return nil, p.errorf("expected \"(\" after in")
+	}
+	values := []string{}
+	for {
+		value, err := p.readValue()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+		if p.accept(")") {
+			break
+		}
+		if !p.accept(",") {
+			return nil, p.errorf("expected \",\" or \")\" in value list")
+		}
+	}
+
+	return inNode{path: path, values: values}, nil
+}
+
+// Reads a gjson path, or a word such as a keyword or a name substring.
+func (p *parser) readPath() string {
+	start := p.pos
+	for !p.done() && !isSpace(p.input[p.pos]) && !strings.ContainsRune("=!~<>(),&|'\"", rune(p.input[p.pos])) {
+		p.pos++
+	}
+
+	return p.input[start:p.pos]
+}
+
+// Reads a value, either quoted with ' or ", or bare.
+// A bare value ends at whitespace, a comma, `&&`, `||` or an unbalanced ")",
+// so regular expressions such as `^(a|b)$` don't need quoting.
+func (p *parser) readValue() (string, error) {
+	p.skipSpace()
+	if !p.done() && (p.input[p.pos] == '\'' || p.input[p.pos] == '"') {
+		return p.readQuoted()
+	}
+	start := p.pos
+	depth := 0
+	for ; !p.done(); p.pos++ {
+		rest := p.input[p.pos:]
+		char := rest[0]
+		if isSpace(char) || char == ',' || strings.HasPrefix(rest, "&&") || strings.HasPrefix(rest, "||") {
+			break
+		}
+		if char == '(' {
+			depth++
+		} else if char == ')' {
+			if depth == 0 {
+				break
+			}
+			depth--
+		}
+	}
+
+	return p.input[start:p.pos], nil
+}
+
+// Reads a quoted value. A backslash escapes the quote character or a backslash.
+func (p *parser) readQuoted() (string, error) {
+	quote := p.input[p.pos]
+	start := p.pos
+	p.pos++
+	var value strings.Builder
+	for ; !p.done(); p.pos++ {
+		char := p.input[p.pos]
+		if char == '\\' && p.pos+1 < len(p.input) && (p.input[p.pos+1] == quote || p.input[p.pos+1] == '\\') {
+			p.pos++
+			value.WriteByte(p.input[p.pos])
+
+			continue
+		}
+		if char == quote {
+			p.pos++
+
+			return value.String(), nil
+		}
+		value.WriteByte(char)
+	}
+	p.pos = start
+
+	return "", p.errorf("unterminated quoted value")
+}
+
+func isSpace(char byte) bool {
+	return char == ' ' || char == '\t' || char == '\n' || char == '\r'
+}
@@ -0,0 +1,102 @@
+package kr8_select_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/tidwall/gjson"
+
+	"github.com/ice-bergtech/kr8/pkg/kr8_select"
+)
+
+const testCluster = `{
+  "name": "prod-us-1",
+  "env": "prod",
+  "region": "us-east-1",
+  "tier": "standard",
+  "replicas": 3,
+  "version": "1.29",
+  "canary": false,
+  "network": { "cidr": "10.0.0.0/16", "zones": ["a", "b"] }
+}`
+
+func TestSelectorMatch(t *testing.T) {
+	tests := []struct {
+		name       string
+		expression string
+		want       bool
+	}{
+		{name: "equality", expression: "env=prod", want: true},
+		{name: "inequality", expression: "env!=prod", want: false},
+		{name: "regex", expression: "region~^us-", want: true},
+		{name: "negated regex", expression: "region!~^us-", want: false},
+		{name: "regex with groups", expression: "region~^(us|eu)-east", want: true},
+		{name: "and", expression: "env=prod && region~^us- && !(tier=legacy)", want: true},
+		{name: "and mismatch", expression: "env=prod && tier=legacy", want: false},
+		{name: "or", expression: "env=dev || tier=standard", want: true},
+		{name: "comma is or", expression: "env=dev,tier=standard", want: true},
+		{name: "and binds tighter than or", expression: "env=dev && tier=legacy || replicas=3", want: true},
+		{name: "parentheses", expression: "env=dev && (tier=legacy || replicas=3)", want: false},
+		{name: "not", expression: "!env=dev", want: true},
+		{name: "spaces around operators", expression: "env = prod && replicas >= 3", want: true},
+		{name: "greater than", expression: "replicas>2", want: true},
+		{name: "less or equal", expression: "replicas<=2", want: false},
+		{name: "numeric string", expression: "version>=1.28", want: true},
+		{name: "non numeric value", expression: "env>1", want: false},
+		{name: "missing value", expression: "missing<1", want: false},
+		{name: "in", expression: "env in (dev, prod)", want: true},
+		{name: "in quoted", expression: "tier in ('extra small', \"standard\")", want: true},
+		{name: "not in", expression: "!(env in (dev,staging))", want: true},
+		{name: "exists", expression: "exists(canary)", want: true},
+		{name: "exists missing", expression: "exists(network.gateway)", want: false},
+		{name: "nested path", expression: "network.cidr=10.0.0.0/16", want: true},
+		{name: "array path", expression: "network.zones.#=2", want: true},
+		{name: "quoted value", expression: "name='prod-us-1'", want: true},
+		{name: "escaped quote", expression: `name='it\'s'`, want: false},
+		{name: "name substring", expression: "us-1", want: true},
+		{name: "name substring mismatch", expression: "eu", want: false},
+		{name: "empty value", expression: "missing=", want: true},
+	}
+	input := gjson.Parse(testCluster)
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			selector, err := kr8_select.Parse(testCase.expression)
+			if err != nil {
+				t.Fatalf("Parse(%q) error = %v", testCase.expression, err)
+			}
+			if got := selector.Match(input); got != testCase.want {
+				t.Errorf("Match(%q) = %v, want %v", testCase.expression, got, testCase.want)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		expression string
+		wantErr    string
+	}{
+		{name: "empty", expression: " ", wantErr: "empty selector"},
+		{name: "unclosed parenthesis", expression: "(env=prod", wantErr: `expected ")" at position 10`},
+		{name: "dangling and", expression: "env=prod &&", wantErr: "expected a condition at position 12"},
+		{name: "invalid regex", expression: "region~^(us", wantErr: "invalid regular expression"},
+		{name: "non numeric comparison", expression: "replicas>many", wantErr: `expected a number after ">", found "many" at position 10`},
+		{name: "unterminated quote", expression: "env='prod", wantErr: "unterminated quoted value at position 5"},
+		{name: "in without list", expression: "env in prod", wantErr: `expected "(" after in`},
+		{name: "unclosed in list", expression: "env in (dev prod)", wantErr: `expected "," or ")" in value list`},
+		{name: "trailing input", expression: "env=prod)", wantErr: `unexpected ")"`},
+		{name: "exists without path", expression: "exists()", wantErr: "expected a path in exists()"},
+	}
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			_, err := kr8_select.Parse(testCase.expression)
+			if err == nil {
+				t.Fatalf("Parse(%q) succeeded unexpectedly", testCase.expression)
+			}
+			if !strings.Contains(err.Error(), testCase.wantErr) {
+				t.Errorf("Parse(%q) error = %v, want %q", testCase.expression, err, testCase.wantErr)
+			}
+		})
+	}
+}
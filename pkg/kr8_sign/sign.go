@@ -0,0 +1,213 @@
+// Package kr8_sign creates and verifies signed digest manifests of generated cluster output.
+//
+// A manifest lists every file generated for a cluster along with its sha256 hash.
+// The manifest is signed with an ed25519 private key, producing a detached signature.
+// Deployment pipelines can verify a generated tree against the manifest and a public key
+// to confirm it was produced by a trusted kr8+ run and has not been modified since.
+package kr8_sign
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/ice-bergtech/kr8/pkg/types"
+	"github.com/ice-bergtech/kr8/pkg/util"
+)
+
+const (
+	// Name of the digest manifest file stored in the cluster output directory.
+	ManifestFile = ".kr8_manifest.json"
+	// Name of the detached signature file stored in the cluster output directory.
+	SignatureFile = ".kr8_manifest.sig"
+	// Hash algorithm used for manifest file digests.
+	HashAlgorithm = "sha256"
+)
+
+// A digest manifest of all files generated for a cluster.
+type Manifest struct {
+	// The name of the cluster the manifest describes
+	Cluster string `json:"cluster"`
+	// Algorithm used to compute file digests
+	Algorithm string `json:"algorithm"`
+	// Map of file paths, relative to the cluster output directory, to base64 encoded digests
+	Files map[string]string `json:"files"`
+}
+
+// Checks if a file in the cluster output directory is kr8+ bookkeeping, such as the cache or manifest.
+// Bookkeeping files are stored at the root of the cluster output directory and are prefixed with `.kr8_`.
+func IsBookkeepingFile(relPath string) bool {
+	return !strings.Contains(relPath, "/") && strings.HasPrefix(relPath, ".kr8_")
+}
+
+// Builds a digest manifest for all files in a cluster output directory.
+// Hashes are calculated with [util.HashFile], the same hashing used by the cache.
+func CreateManifest(clusterName string, clusterOutputDir string) (*Manifest, error) {
+	files, err := util.BuildDirFileList(clusterOutputDir)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := Manifest{
+		Cluster:   clusterName,
+		Algorithm: HashAlgorithm,
+		Files:     make(map[string]string, len(files)),
+	}
+	for _, file := range files {
+		relPath, err := filepath.Rel(clusterOutputDir, file)
+		if err != nil {
+			return nil, util.ErrorIfCheck("error calculating relative path", err)
+		}
+		relPath = filepath.ToSlash(relPath)
+		if IsBookkeepingFile(relPath) {
+			continue
+		}
+		hash, err := util.HashFile(file)
+		if err != nil {
+			return nil, util.ErrorIfCheck("error hashing file "+file, err)
+		}
+		manifest.Files[relPath] = hash
+	}
+
+	return &manifest, nil
+}
+
+// Load an ed25519 private key from a PEM encoded PKCS #8 file.
+// Keys can be created with `openssl genpkey -algorithm ed25519 -out kr8-sign.pem`.
+func LoadPrivateKey(keyFile string) (ed25519.PrivateKey, error) {
+	block, err := readPemFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, util.ErrorIfCheck("error parsing private key "+keyFile, err)
+	}
+	edKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, types.Kr8Error{Message: "private key is not an ed25519 key", Value: keyFile}
+	}
+
+	return edKey, nil
+}
+
+// Load an ed25519 public key from a PEM encoded PKIX file.
+// Keys can be created with `openssl pkey -in kr8-sign.pem -pubout -out kr8-sign.pub`.
+func LoadPublicKey(keyFile string) (ed25519.PublicKey, error) {
+	block, err := readPemFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, util.ErrorIfCheck("error parsing public key "+keyFile, err)
+	}
+	edKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, types.Kr8Error{Message: "public key is not an ed25519 key", Value: keyFile}
+	}
+
+	return edKey, nil
+}
+
+func readPemFile(keyFile string) (*pem.Block, error) {
+	data, err := os.ReadFile(filepath.Clean(keyFile))
+	if err != nil {
+		return nil, util.ErrorIfCheck("error reading key file", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, types.Kr8Error{Message: "no PEM data found in key file", Value: keyFile}
+	}
+
+	return block, nil
+}
+
+// Writes a digest manifest and detached signature for a cluster output directory.
+// The signature is the base64 encoded ed25519 signature of the manifest file contents.
+func SignClusterOutput(clusterName string, clusterOutputDir string, key ed25519.PrivateKey) error {
+	manifest, err := CreateManifest(clusterName, clusterOutputDir)
+	if err != nil {
+		return err
+	}
+	manifestBytes, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return util.ErrorIfCheck("error marshalling manifest", err)
+	}
+	signature := base64.StdEncoding.EncodeToString(ed25519.Sign(key, manifestBytes))
+
+	if err := util.WriteFile(manifestBytes, filepath.Join(clusterOutputDir, ManifestFile)); err != nil {
+		return util.ErrorIfCheck("error writing manifest", err)
+	}
+
+	return util.ErrorIfCheck("error writing manifest signature",
+		util.WriteFile([]byte(signature+"\n"), filepath.Join(clusterOutputDir, SignatureFile)),
+	)
+}
+
+// Verifies a cluster output directory against its signed digest manifest.
+// Returns an error if the signature is invalid, or if the manifest was signed for another cluster.
+// Otherwise returns a sorted list of files that are modified, missing or not present in the manifest.
+// An empty list means the generated tree matches the manifest.
+func VerifyClusterOutput(clusterName string, clusterOutputDir string, key ed25519.PublicKey) ([]string, error) {
+	manifestBytes, err := os.ReadFile(filepath.Join(clusterOutputDir, ManifestFile))
+	if err != nil {
+		return nil, util.ErrorIfCheck("error reading manifest", err)
+	}
+	sigText, err := os.ReadFile(filepath.Join(clusterOutputDir, SignatureFile))
+	if err != nil {
+		return nil, util.ErrorIfCheck("error reading manifest signature", err)
+	}
+	signature, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(sigText)))
+	if err != nil {
+		return nil, util.ErrorIfCheck("error decoding manifest signature", err)
+	}
+	if !ed25519.Verify(key, manifestBytes, signature) {
+		return nil, types.Kr8Error{Message: "manifest signature is invalid", Value: clusterOutputDir}
+	}
+
+	//nolint:exhaustruct
+	signed := Manifest{}
+	if err := json.Unmarshal(manifestBytes, &signed); err != nil {
+		return nil, util.ErrorIfCheck("error parsing manifest", err)
+	}
+	if signed.Cluster != clusterName {
+		return nil, types.Kr8Error{
+			Message: "manifest was signed for cluster " + signed.Cluster + ", not " + clusterName,
+			Value:   clusterOutputDir,
+		}
+	}
+	current, err := CreateManifest(signed.Cluster, clusterOutputDir)
+	if err != nil {
+		return nil, err
+	}
+
+	return compareManifests(signed, *current), nil
+}
+
+// Lists differences between a signed manifest and the current state of the output directory.
+func compareManifests(signed Manifest, current Manifest) []string {
+	issues := []string{}
+	for file, hash := range signed.Files {
+		currentHash, ok := current.Files[file]
+		if !ok {
+			issues = append(issues, "missing: "+file)
+		} else if currentHash != hash {
+			issues = append(issues, "modified: "+file)
+		}
+	}
+	for file := range current.Files {
+		if _, ok := signed.Files[file]; !ok {
+			issues = append(issues, "unexpected: "+file)
+		}
+	}
+	sort.Strings(issues)
+
+	return issues
+}
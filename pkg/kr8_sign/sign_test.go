@@ -0,0 +1,208 @@
+package kr8_sign_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/ice-bergtech/kr8/pkg/kr8_sign"
+)
+
+func writeTestTree(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	files := map[string]string{
+		"comp1/deployment.yaml": "kind: Deployment\n",
+		"comp1/service.yaml":    "kind: Service\n",
+		"comp2/docs/readme.md":  "# readme\n",
+		".kr8_cache":            "cache data",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return dir
+}
+
+func TestCreateManifest(t *testing.T) {
+	dir := writeTestTree(t)
+	manifest, err := kr8_sign.CreateManifest("test", dir)
+	if err != nil {
+		t.Fatalf("CreateManifest() failed: %v", err)
+	}
+	got := []string{}
+	for file := range manifest.Files {
+		got = append(got, file)
+	}
+	want := map[string]bool{"comp1/deployment.yaml": true, "comp1/service.yaml": true, "comp2/docs/readme.md": true}
+	if len(got) != len(want) {
+		t.Fatalf("CreateManifest() files = %v, want %v", got, want)
+	}
+	for _, file := range got {
+		if !want[file] {
+			t.Errorf("CreateManifest() unexpected file %s", file)
+		}
+	}
+}
+
+func TestVerifyClusterOutput(t *testing.T) {
+	pubKey, privKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		modify  func(dir string) error
+		cluster string
+		key     ed25519.PublicKey
+		want    []string
+		wantErr bool
+	}{
+		{
+			name:    "unmodified tree",
+			modify:  func(dir string) error { return nil },
+			cluster: "test",
+			key:     pubKey,
+			want:    []string{},
+			wantErr: false,
+		},
+		{
+			name: "modified, removed and added files",
+			modify: func(dir string) error {
+				if err := os.WriteFile(filepath.Join(dir, "comp1/service.yaml"), []byte("kind: Pod\n"), 0600); err != nil {
+					return err
+				}
+				if err := os.Remove(filepath.Join(dir, "comp1/deployment.yaml")); err != nil {
+					return err
+				}
+
+				return os.WriteFile(filepath.Join(dir, "comp2/extra.yaml"), []byte("kind: Secret\n"), 0600)
+			},
+			cluster: "test",
+			key:     pubKey,
+			want: []string{
+				"missing: comp1/deployment.yaml",
+				"modified: comp1/service.yaml",
+				"unexpected: comp2/extra.yaml",
+			},
+			wantErr: false,
+		},
+		{
+			name:    "cache changes are ignored",
+			modify:  func(dir string) error { return os.WriteFile(filepath.Join(dir, ".kr8_cache"), []byte("new"), 0600) },
+			cluster: "test",
+			key:     pubKey,
+			want:    []string{},
+			wantErr: false,
+		},
+		{
+			name:    "wrong public key",
+			modify:  func(dir string) error { return nil },
+			cluster: "test",
+			key:     otherPub,
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name: "tampered manifest",
+			modify: func(dir string) error {
+				return os.WriteFile(filepath.Join(dir, kr8_sign.ManifestFile), []byte(`{"files":{}}`), 0600)
+			},
+			cluster: "test",
+			key:     pubKey,
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name:    "manifest of another cluster",
+			modify:  func(dir string) error { return nil },
+			cluster: "prod",
+			key:     pubKey,
+			want:    nil,
+			wantErr: true,
+		},
+	}
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			dir := writeTestTree(t)
+			if err := kr8_sign.SignClusterOutput("test", dir, privKey); err != nil {
+				t.Fatalf("SignClusterOutput() failed: %v", err)
+			}
+			if err := testCase.modify(dir); err != nil {
+				t.Fatal(err)
+			}
+			got, gotErr := kr8_sign.VerifyClusterOutput(testCase.cluster, dir, testCase.key)
+			if gotErr != nil {
+				if !testCase.wantErr {
+					t.Errorf("VerifyClusterOutput() failed: %v", gotErr)
+				}
+
+				return
+			}
+			if testCase.wantErr {
+				t.Fatal("VerifyClusterOutput() succeeded unexpectedly")
+			}
+			if !reflect.DeepEqual(got, testCase.want) {
+				t.Errorf("VerifyClusterOutput() = %v, want %v", got, testCase.want)
+			}
+		})
+	}
+}
+
+func TestLoadKeys(t *testing.T) {
+	pubKey, privKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	privBytes, err := x509.MarshalPKCS8PrivateKey(privKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubBytes, err := x509.MarshalPKIXPublicKey(pubKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privFile := filepath.Join(dir, "key.pem")
+	pubFile := filepath.Join(dir, "key.pub")
+	if err := os.WriteFile(privFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privBytes}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(pubFile, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubBytes}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	gotPriv, err := kr8_sign.LoadPrivateKey(privFile)
+	if err != nil {
+		t.Fatalf("LoadPrivateKey() failed: %v", err)
+	}
+	if !gotPriv.Equal(privKey) {
+		t.Error("LoadPrivateKey() returned a different key")
+	}
+	gotPub, err := kr8_sign.LoadPublicKey(pubFile)
+	if err != nil {
+		t.Fatalf("LoadPublicKey() failed: %v", err)
+	}
+	if !gotPub.Equal(pubKey) {
+		t.Error("LoadPublicKey() returned a different key")
+	}
+	if _, err := kr8_sign.LoadPublicKey(privFile); err == nil {
+		t.Error("LoadPublicKey() succeeded on a private key")
+	}
+}
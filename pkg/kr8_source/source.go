@@ -0,0 +1,241 @@
+// Package kr8_source fetches remote component sources into a local component cache.
+//
+// A source is a git repository in go-getter syntax, with the component's directory as a subdirectory
+// and an optional ref, such as `git::https://example.com/components.git//components/foo?ref=v1.2.0`.
+// Fetching resolves the ref to a commit, pinned in a lockfile in the base directory,
+// and checks the commit out into the component cache, also in the base directory.
+// Components are then resolved from the lock and the cache only, so generating doesn't need the network.
+package kr8_source
+
+import (
+	"encoding/json"
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	getter "github.com/hashicorp/go-getter"
+	"github.com/rs/zerolog"
+
+	"github.com/ice-bergtech/kr8/pkg/kr8_git"
+	"github.com/ice-bergtech/kr8/pkg/types"
+	"github.com/ice-bergtech/kr8/pkg/util"
+)
+
+const (
+	// File in the base directory pinning each fetched repository to a commit.
+	LockFile = "sources.lock.json"
+	// Directory in the base directory the fetched commits are checked out into, one directory per commit.
+	CacheDir = ".kr8_sources"
+)
+
+// A parsed component source.
+type Source struct {
+	// Repository and ref to fetch, such as `git::https://example.com/components.git?ref=v1.2.0`
+	Repo string
+	// Directory of the component within the repository. Empty for the repository root
+	Subdir string
+}
+
+// Parses a component source.
+// Sources are detected as with go-getter, so `github.com/org/repo//components/foo` is a git source,
+// but must resolve to a git repository, as only git commits can be pinned.
+func ParseSource(src string) (Source, error) {
+	pwd, err := os.Getwd()
+	if err != nil {
+		return Source{Repo: "", Subdir: ""}, util.ErrorIfCheck("error getting working directory", err)
+	}
+	detected, err := getter.Detect(src, pwd, getter.Detectors)
+	if err != nil {
+		return Source{Repo: "", Subdir: ""}, types.Kr8Error{Message: "invalid component source " + src, Value: err}
+	}
+	if !strings.HasPrefix(detected, "git::") {
+		return Source{Repo: "", Subdir: ""}, types.Kr8Error{
+			Message: "component sources must be git repositories, such as git::https://example.com/repo.git//path",
+			Value:   src,
+		}
+	}
+	repo, subdir := getter.SourceDirSubdir(detected)
+	if subdir != "" && !filepath.IsLocal(subdir) {
+		return Source{Repo: "", Subdir: ""}, types.Kr8Error{Message: "invalid subdirectory of component source", Value: src}
+	}
+	if subdir != "" {
+		subdir = filepath.ToSlash(filepath.Clean(subdir))
+	}
+
+	return Source{Repo: repo, Subdir: subdir}, nil
+}
+
+// Pins fetched repositories to commits.
+type Lock struct {
+	// Pinned repositories, keyed by repository and ref
+	Sources map[string]LockedSource `json:"sources"`
+}
+
+// A repository pinned to a commit.
+type LockedSource struct {
+	// Commit the ref resolved to when fetched
+	Commit string `json:"commit"`
+}
+
+// Loads the lockfile of a base directory.
+// Returns an empty lock if there is no lockfile.
+func LoadLock(baseDir string) (*Lock, error) {
+	lock := &Lock{Sources: map[string]LockedSource{}}
+	data, err := os.ReadFile(filepath.Join(baseDir, LockFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return lock, nil
+	}
+	if err != nil {
+		return nil, util.ErrorIfCheck("error reading "+LockFile, err)
+	}
+	if err := json.Unmarshal(data, lock); err != nil {
+		return nil, util.ErrorIfCheck("error parsing "+LockFile, err)
+	}
+	if lock.Sources == nil {
+		lock.Sources = map[string]LockedSource{}
+	}
+
+	return lock, nil
+}
+
+// Writes the lockfile of a base directory.
+func (l *Lock) Write(baseDir string) error {
+	data, err := json.MarshalIndent(l, "", "  ")
+	if err != nil {
+		return util.ErrorIfCheck("error encoding "+LockFile, err)
+	}
+
+	err = os.WriteFile(filepath.Join(baseDir, LockFile), append(data, '\n'), 0600)
+
+	return util.ErrorIfCheck("error writing "+LockFile, err)
+}
+
+// Returns the directory of a source's component, relative to the base directory.
+// The source's repository must be locked and its commit fetched into the cache, nothing is fetched.
+func (l *Lock) ComponentPath(baseDir string, source Source) (string, error) {
+	locked, ok := l.Sources[source.Repo]
+	if !ok {
+		return "", types.Kr8Error{Message: "component source is not locked, run `kr8 sources fetch`", Value: source.Repo}
+	}
+	commitDir := filepath.Join(CacheDir, locked.Commit)
+	if _, err := os.Stat(filepath.Join(baseDir, commitDir)); err != nil {
+		return "", types.Kr8Error{
+			Message: "component source commit is not fetched, run `kr8 sources fetch`",
+			Value:   source.Repo + " " + locked.Commit,
+		}
+	}
+	path := filepath.Join(commitDir, source.Subdir)
+	if _, err := os.Stat(filepath.Join(baseDir, path)); err != nil {
+		return "", types.Kr8Error{Message: "component source directory not found", Value: source.Repo + "//" + source.Subdir}
+	}
+
+	return path, nil
+}
+
+// Fetches the repositories of sources into the cache and pins them in the lock.
+// Locked repositories are fetched at their locked commit, if not already cached,
+// unless update is set, in which case their ref is resolved again.
+// Repositories no longer used by a source are removed from the lock.
+func (l *Lock) Fetch(baseDir string, sources []Source, update bool, logger zerolog.Logger) error {
+	repos := map[string]bool{}
+	for _, source := range sources {
+		repos[source.Repo] = true
+	}
+	names := make([]string, 0, len(repos))
+	for repo := range repos {
+		names = append(names, repo)
+	}
+	sort.Strings(names)
+
+	for _, repo := range names {
+		locked, ok := l.Sources[repo]
+		if ok && !update {
+			if _, err := os.Stat(filepath.Join(baseDir, CacheDir, locked.Commit)); err == nil {
+				logger.Debug().Str("source", repo).Str("commit", locked.Commit).Msg("Source already fetched")
+
+				continue
+			}
+			logger.Info().Str("source", repo).Str("commit", locked.Commit).Msg("Fetching locked source")
+			pinned, err := pinRef(repo, locked.Commit)
+			if err != nil {
+				return err
+			}
+			if _, err := fetchCommit(baseDir, pinned); err != nil {
+				return err
+			}
+
+			continue
+		}
+		logger.Info().Str("source", repo).Msg("Fetching source")
+		commit, err := fetchCommit(baseDir, repo)
+		if err != nil {
+			return err
+		}
+		l.Sources[repo] = LockedSource{Commit: commit}
+	}
+	for repo := range l.Sources {
+		if !repos[repo] {
+			delete(l.Sources, repo)
+		}
+	}
+
+	return nil
+}
+
+// Clones a repository and moves its checkout into the cache, named after the commit checked out.
+// Returns the commit.
+func fetchCommit(baseDir string, repo string) (string, error) {
+	cacheDir := filepath.Join(baseDir, CacheDir)
+	if err := os.MkdirAll(cacheDir, 0750); err != nil {
+		return "", util.ErrorIfCheck("error creating "+CacheDir, err)
+	}
+	// Cloned next to the cache, so the checkout can be moved into it
+	tempDir, err := os.MkdirTemp(cacheDir, ".fetch-")
+	if err != nil {
+		return "", util.ErrorIfCheck("error creating temporary directory", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	cloneDir := filepath.Join(tempDir, "repo")
+	//nolint:exhaustruct
+	client := &getter.Client{
+		Src:  repo,
+		Dst:  cloneDir,
+		Pwd:  baseDir,
+		Mode: getter.ClientModeDir,
+	}
+	if err := client.Get(); err != nil {
+		return "", types.Kr8Error{Message: "error fetching component source " + repo, Value: err}
+	}
+	commit := kr8_git.SourceRevision(cloneDir)
+	if commit == "" {
+		return "", types.Kr8Error{Message: "error reading the commit of component source", Value: repo}
+	}
+	if err := os.RemoveAll(filepath.Join(cloneDir, ".git")); err != nil {
+		return "", util.ErrorIfCheck("error removing .git directory", err)
+	}
+
+	commitDir := filepath.Join(cacheDir, commit)
+	if _, err := os.Stat(commitDir); err == nil {
+		return commit, nil
+	}
+
+	return commit, util.ErrorIfCheck("error moving source into "+CacheDir, os.Rename(cloneDir, commitDir))
+}
+
+// Replaces the ref of a repository with a commit.
+func pinRef(repo string, commit string) (string, error) {
+	forced, address, _ := strings.Cut(repo, "::")
+	parsed, err := url.Parse(address)
+	if err != nil {
+		return "", types.Kr8Error{Message: "invalid component source " + repo, Value: err}
+	}
+	query := parsed.Query()
+	query.Set("ref", commit)
+	parsed.RawQuery = query.Encode()
+
+	return forced + "::" + parsed.String(), nil
+}
@@ -0,0 +1,173 @@
+package kr8_source_test
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/rs/zerolog"
+
+	"github.com/ice-bergtech/kr8/pkg/kr8_source"
+)
+
+func TestParseSource(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     string
+		want    kr8_source.Source
+		wantErr bool
+	}{
+		{
+			name: "git with subdirectory and ref",
+			src:  "git::https://example.com/components.git//components/foo?ref=v1.2.0",
+			want: kr8_source.Source{Repo: "git::https://example.com/components.git?ref=v1.2.0", Subdir: "components/foo"},
+		},
+		{
+			name: "repository root",
+			src:  "git::file:///srv/components.git",
+			want: kr8_source.Source{Repo: "git::file:///srv/components.git", Subdir: ""},
+		},
+		{
+			name: "detected github repository",
+			src:  "github.com/org/components//foo",
+			want: kr8_source.Source{Repo: "git::https://github.com/org/components.git", Subdir: "foo"},
+		},
+		{name: "not a git repository", src: "https://example.com/components.tar.gz", wantErr: true},
+		{name: "subdirectory outside the repository", src: "git::https://example.com/c.git//../foo", wantErr: true},
+	}
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			got, err := kr8_source.ParseSource(testCase.src)
+			if (err != nil) != testCase.wantErr {
+				t.Fatalf("ParseSource() error = %v, wantErr %v", err, testCase.wantErr)
+			}
+			if !testCase.wantErr && got != testCase.want {
+				t.Errorf("ParseSource() = %+v, want %+v", got, testCase.want)
+			}
+		})
+	}
+}
+
+// Commits a file to a repository and tags the commit, moving the tag if it exists.
+func commitTag(t *testing.T, repo *git.Repository, dir string, content string, tag string) plumbing.Hash {
+	t.Helper()
+	path := filepath.Join(dir, "components", "foo", "params.jsonnet")
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	worktree, err := repo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := worktree.Add("."); err != nil {
+		t.Fatal(err)
+	}
+	//nolint:exhaustruct
+	hash, err := worktree.Commit(content, &git.CommitOptions{
+		Author: &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = repo.DeleteTag(tag)
+	if _, err := repo.CreateTag(tag, hash, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	return hash
+}
+
+func TestLockFetch(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is required to fetch sources")
+	}
+	repoDir := t.TempDir()
+	repo, err := git.PlainInit(repoDir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := commitTag(t, repo, repoDir, "{ version: 1 }", "v1")
+
+	baseDir := t.TempDir()
+	source, err := kr8_source.ParseSource("git::file://" + filepath.ToSlash(repoDir) + "//components/foo?ref=v1")
+	if err != nil {
+		t.Fatalf("ParseSource() error = %v", err)
+	}
+	lock, err := kr8_source.LoadLock(baseDir)
+	if err != nil {
+		t.Fatalf("LoadLock() error = %v", err)
+	}
+	if _, err := lock.ComponentPath(baseDir, source); err == nil || !strings.Contains(err.Error(), "not locked") {
+		t.Errorf("ComponentPath() error = %v, want source not locked", err)
+	}
+	lock.Sources["git::file:///unused.git"] = kr8_source.LockedSource{Commit: "unused"}
+
+	if err := lock.Fetch(baseDir, []kr8_source.Source{source}, false, zerolog.Nop()); err != nil {
+		t.Fatalf("Fetch() error = %v", err)
+	}
+	if err := lock.Write(baseDir); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	lock, err = kr8_source.LoadLock(baseDir)
+	if err != nil {
+		t.Fatalf("LoadLock() error = %v", err)
+	}
+	if len(lock.Sources) != 1 || lock.Sources[source.Repo].Commit != first.String() {
+		t.Fatalf("Fetch() lock = %+v, want %s pinned to %s", lock.Sources, source.Repo, first)
+	}
+
+	readComponent := func() string {
+		t.Helper()
+		path, err := lock.ComponentPath(baseDir, source)
+		if err != nil {
+			t.Fatalf("ComponentPath() error = %v", err)
+		}
+		if want := filepath.Join(kr8_source.CacheDir, lock.Sources[source.Repo].Commit, "components", "foo"); path != want {
+			t.Errorf("ComponentPath() = %s, want %s", path, want)
+		}
+		content, err := os.ReadFile(filepath.Join(baseDir, path, "params.jsonnet"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return string(content)
+	}
+	if got := readComponent(); got != "{ version: 1 }" {
+		t.Errorf("fetched component = %s", got)
+	}
+
+	// The locked commit is fetched again, even though the tag moved
+	second := commitTag(t, repo, repoDir, "{ version: 2 }", "v1")
+	if err := os.RemoveAll(filepath.Join(baseDir, kr8_source.CacheDir)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lock.ComponentPath(baseDir, source); err == nil || !strings.Contains(err.Error(), "not fetched") {
+		t.Errorf("ComponentPath() error = %v, want commit not fetched", err)
+	}
+	if err := lock.Fetch(baseDir, []kr8_source.Source{source}, false, zerolog.Nop()); err != nil {
+		t.Fatalf("Fetch() error = %v", err)
+	}
+	if got := readComponent(); got != "{ version: 1 }" {
+		t.Errorf("fetched locked component = %s", got)
+	}
+
+	// Updating resolves the ref again
+	if err := lock.Fetch(baseDir, []kr8_source.Source{source}, true, zerolog.Nop()); err != nil {
+		t.Fatalf("Fetch() error = %v", err)
+	}
+	if lock.Sources[source.Repo].Commit != second.String() {
+		t.Errorf("Fetch() updated commit = %s, want %s", lock.Sources[source.Repo].Commit, second)
+	}
+	if got := readComponent(); got != "{ version: 2 }" {
+		t.Errorf("fetched updated component = %s", got)
+	}
+}
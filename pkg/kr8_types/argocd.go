@@ -0,0 +1,141 @@
+package kr8_types
+
+import (
+	"maps"
+	"path/filepath"
+
+	"github.com/tidwall/gjson"
+)
+
+// Default name of the directory ArgoCD Applications are written to, within the cluster output directory.
+const ArgoCDOutputDir = "_argocd"
+
+// Configures the ArgoCD Applications generated for a cluster's components.
+// Set in the cluster `_kr8_spec`, and overridden per component in the component `kr8_spec`.
+type Kr8ArgoCDSpec struct {
+	// If true, no Applications are generated. Used to exclude components
+	Disabled bool `json:"disabled,omitempty" jsonschema:"default=false"`
+	// Git repository containing the generated output
+	RepoURL string `json:"repo_url,omitempty" jsonschema:"example=https://github.com/example/deploy.git"`
+	// Revision of the repository to sync
+	TargetRevision string `json:"target_revision,omitempty" jsonschema:"default=HEAD"`
+	// Template for the path of the component output within the repository.
+	// Defaults to the generate directory relative to the base directory, followed by the cluster name
+	// and the component's output directory
+	Path string `json:"path,omitempty" jsonschema:"example={{ .Cluster }}/{{ .Component }}"`
+	// Template for the Application name
+	Name string `json:"name,omitempty" jsonschema:"default={{ .Cluster }}-{{ .Component }}"`
+	// ArgoCD project of the Application
+	Project string `json:"project,omitempty" jsonschema:"default=default"`
+	// Namespace the Application objects are created in
+	Namespace string `json:"namespace,omitempty" jsonschema:"default=argocd"`
+	// Destination cluster API server
+	DestinationServer string `json:"destination_server,omitempty" jsonschema:"default=https://kubernetes.default.svc"`
+	// Destination cluster name, used instead of `destination_server` if set
+	DestinationName string `json:"destination_name,omitempty"`
+	// Application `syncPolicy`, copied as is
+	SyncPolicy map[string]any `json:"sync_policy,omitempty"`
+	// Directory Applications are written to, one file per component. Cluster-level only.
+	// Relative paths are resolved from the base directory, and each cluster writes to a subdirectory named after it.
+	// Defaults to `_argocd` in the cluster output directory
+	OutputDir string `json:"output_dir,omitempty"`
+}
+
+// Extracts the ArgoCD configuration from a cluster or component spec.
+// Returns nil if it is not set.
+func ExtractArgoCD(spec gjson.Result) *Kr8ArgoCDSpec {
+	argocd := spec.Get("argocd")
+	if !argocd.IsObject() {
+		return nil
+	}
+	var syncPolicy map[string]any
+	if value, ok := argocd.Get("sync_policy").Value().(map[string]any); ok {
+		syncPolicy = value
+	}
+
+	return &Kr8ArgoCDSpec{
+		Disabled:          argocd.Get("disabled").Bool(),
+		RepoURL:           argocd.Get("repo_url").String(),
+		TargetRevision:    argocd.Get("target_revision").String(),
+		Path:              argocd.Get("path").String(),
+		Name:              argocd.Get("name").String(),
+		Project:           argocd.Get("project").String(),
+		Namespace:         argocd.Get("namespace").String(),
+		DestinationServer: argocd.Get("destination_server").String(),
+		DestinationName:   argocd.Get("destination_name").String(),
+		SyncPolicy:        syncPolicy,
+		OutputDir:         argocd.Get("output_dir").String(),
+	}
+}
+
+// Fills in defaults for the cluster ArgoCD configuration.
+// The default path is the generate directory relative to baseDir, followed by the cluster name
+// and the component's output directory.
+// A configured output directory is shared by clusters, so the cluster's Applications are written to a subdirectory.
+func (a *Kr8ArgoCDSpec) setDefaults(baseDir string, generateDir string, clusterName string, clusterOutputDir string) {
+	if a.TargetRevision == "" {
+		a.TargetRevision = "HEAD"
+	}
+	if a.Path == "" {
+		relGenerateDir, err := filepath.Rel(baseDir, generateDir)
+		if err != nil {
+			relGenerateDir = filepath.Base(generateDir)
+		}
+		a.Path = filepath.ToSlash(relGenerateDir) + "/{{ .Cluster }}/{{ .OutputDir }}"
+	}
+	if a.Name == "" {
+		a.Name = "{{ .Cluster }}-{{ .Component }}"
+	}
+	if a.Project == "" {
+		a.Project = "default"
+	}
+	if a.Namespace == "" {
+		a.Namespace = "argocd"
+	}
+	if a.DestinationServer == "" && a.DestinationName == "" {
+		a.DestinationServer = "https://kubernetes.default.svc"
+	}
+	switch {
+	case a.OutputDir == "":
+		a.OutputDir = filepath.Join(clusterOutputDir, ArgoCDOutputDir)
+	case !filepath.IsAbs(a.OutputDir):
+		a.OutputDir = filepath.Join(baseDir, a.OutputDir, clusterName)
+	default:
+		a.OutputDir = filepath.Join(a.OutputDir, clusterName)
+	}
+}
+
+// Returns the configuration with fields set in override replacing the current values.
+// The output directory can't be overridden.
+func (a Kr8ArgoCDSpec) Merge(override *Kr8ArgoCDSpec) Kr8ArgoCDSpec {
+	if override == nil {
+		return a
+	}
+	merged := a
+	merged.Disabled = a.Disabled || override.Disabled
+	for _, field := range []struct {
+		target *string
+		value  string
+	}{
+		{&merged.RepoURL, override.RepoURL},
+		{&merged.TargetRevision, override.TargetRevision},
+		{&merged.Path, override.Path},
+		{&merged.Name, override.Name},
+		{&merged.Project, override.Project},
+		{&merged.Namespace, override.Namespace},
+		{&merged.DestinationServer, override.DestinationServer},
+		{&merged.DestinationName, override.DestinationName},
+	} {
+		if field.value != "" {
+			*field.target = field.value
+		}
+	}
+	if override.DestinationName != "" && override.DestinationServer == "" {
+		merged.DestinationServer = ""
+	}
+	if override.SyncPolicy != nil {
+		merged.SyncPolicy = maps.Clone(override.SyncPolicy)
+	}
+
+	return merged
+}
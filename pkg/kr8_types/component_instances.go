@@ -0,0 +1,95 @@
+package kr8_types
+
+import (
+	"encoding/json"
+	"maps"
+	"sort"
+	"strings"
+
+	"github.com/ice-bergtech/kr8/pkg/types"
+)
+
+// Replaces each component with `instances` by its instances.
+// Each instance is a component named after the instance, sharing the component's path,
+// with the component's labels merged under its own.
+// An empty list of instances removes the component.
+// Returns an error if an instance name is invalid or already used by another component or instance.
+func ExpandComponentInstances(components map[string]Kr8ClusterComponentRef) (map[string]Kr8ClusterComponentRef, error) {
+	names := make([]string, 0, len(components))
+	for name := range components {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	expanded := make(map[string]Kr8ClusterComponentRef, len(components))
+	owners := make(map[string]string, len(components))
+	for _, name := range names {
+		if ref := components[name]; len(ref.Instances) == 0 {
+			expanded[name] = ref
+			owners[name] = name
+		}
+	}
+	for _, name := range names {
+		ref := components[name]
+		if len(ref.Instances) == 0 {
+			continue
+		}
+		instances, err := parseComponentInstances(ref.Instances)
+		if err != nil {
+			return nil, types.Kr8Error{Message: "invalid instances of component " + name, Value: err}
+		}
+		for instanceName, instance := range instances {
+			if instanceName == "" || strings.ContainsAny(instanceName, `/\.`) {
+				return nil, types.Kr8Error{Message: "invalid instance name of component " + name, Value: instanceName}
+			}
+			if owner, ok := owners[instanceName]; ok {
+				return nil, types.Kr8Error{
+					Message: "instance " + instanceName + " of component " + name + " has the name of a component",
+					Value:   owner,
+				}
+			}
+			var instanceLabels map[string]string
+			if len(ref.Labels) > 0 || len(instance.Labels) > 0 {
+				instanceLabels = map[string]string{}
+				maps.Copy(instanceLabels, ref.Labels)
+				maps.Copy(instanceLabels, instance.Labels)
+			}
+			expanded[instanceName] = Kr8ClusterComponentRef{
+				Path:       ref.Path,
+				Source:     ref.Source,
+				Labels:     instanceLabels,
+				Instances:  nil,
+				InstanceOf: name,
+				Params:     instance.Params,
+			}
+			owners[instanceName] = name
+		}
+	}
+
+	return expanded, nil
+}
+
+// Parses the `instances` of a component, a list of instance names or an object of instance name to instance.
+func parseComponentInstances(raw json.RawMessage) (map[string]Kr8ComponentInstance, error) {
+	var names []string
+	if err := json.Unmarshal(raw, &names); err == nil {
+		instances := make(map[string]Kr8ComponentInstance, len(names))
+		for _, name := range names {
+			if _, ok := instances[name]; ok {
+				return nil, types.Kr8Error{Message: "instance listed more than once", Value: name}
+			}
+			instances[name] = Kr8ComponentInstance{Params: nil, Labels: nil}
+		}
+
+		return instances, nil
+	}
+	var instances map[string]Kr8ComponentInstance
+	if err := json.Unmarshal(raw, &instances); err != nil {
+		return nil, types.Kr8Error{
+			Message: "`instances` must be a list of instance names or an object of instance name to instance",
+			Value:   string(raw),
+		}
+	}
+
+	return instances, nil
+}
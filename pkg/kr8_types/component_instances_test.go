@@ -0,0 +1,101 @@
+package kr8_types
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExpandComponentInstances(t *testing.T) {
+	tests := []struct {
+		name       string
+		components string
+		want       map[string]Kr8ClusterComponentRef
+		wantErr    string
+	}{
+		{
+			name:       "no instances",
+			components: `{ "web": { "path": "components/web" } }`,
+			want: map[string]Kr8ClusterComponentRef{
+				"web": {Path: "components/web", Labels: nil, Instances: nil, InstanceOf: "", Params: nil},
+			},
+		},
+		{
+			name:       "list of instances",
+			components: `{ "tenant": { "path": "components/tenant", "instances": ["acme", "globex"] } }`,
+			want: map[string]Kr8ClusterComponentRef{
+				"acme":   {Path: "components/tenant", Labels: nil, Instances: nil, InstanceOf: "tenant", Params: nil},
+				"globex": {Path: "components/tenant", Labels: nil, Instances: nil, InstanceOf: "tenant", Params: nil},
+			},
+		},
+		{
+			name: "object of instances",
+			components: `{ "tenant": { "path": "components/tenant", "labels": { "team": "apps", "tier": "shared" },
+				"instances": { "acme": { "params": { "replicas": 3 }, "labels": { "tier": "dedicated" } }, "globex": {} } } }`,
+			want: map[string]Kr8ClusterComponentRef{
+				"acme": {
+					Path: "components/tenant", Labels: map[string]string{"team": "apps", "tier": "dedicated"},
+					Instances: nil, InstanceOf: "tenant", Params: json.RawMessage(`{ "replicas": 3 }`),
+				},
+				"globex": {
+					Path: "components/tenant", Labels: map[string]string{"team": "apps", "tier": "shared"},
+					Instances: nil, InstanceOf: "tenant", Params: nil,
+				},
+			},
+		},
+		{
+			name:       "empty instances",
+			components: `{ "tenant": { "path": "components/tenant", "instances": [] } }`,
+			want:       map[string]Kr8ClusterComponentRef{},
+		},
+		{
+			name:       "instance named after a component",
+			components: `{ "web": { "path": "components/web" }, "tenant": { "path": "components/tenant", "instances": ["web"] } }`,
+			wantErr:    "instance web of component tenant has the name of a component",
+		},
+		{
+			name: "instance named after another instance",
+			components: `{ "a": { "path": "components/a", "instances": ["x"] },
+				"b": { "path": "components/b", "instances": ["x"] } }`,
+			wantErr: "instance x of component b has the name of a component",
+		},
+		{
+			name:       "invalid instance name",
+			components: `{ "tenant": { "path": "components/tenant", "instances": ["a.b"] } }`,
+			wantErr:    "invalid instance name",
+		},
+		{
+			name:       "duplicate instance",
+			components: `{ "tenant": { "path": "components/tenant", "instances": ["a", "a"] } }`,
+			wantErr:    "invalid instances of component tenant",
+		},
+		{
+			name:       "invalid instances",
+			components: `{ "tenant": { "path": "components/tenant", "instances": "acme" } }`,
+			wantErr:    "invalid instances of component tenant",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var components map[string]Kr8ClusterComponentRef
+			if err := json.Unmarshal([]byte(tt.components), &components); err != nil {
+				t.Fatal(err)
+			}
+			got, err := ExpandComponentInstances(components)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ExpandComponentInstances() error = %v, want %q", err, tt.wantErr)
+				}
+
+				return
+			}
+			if err != nil {
+				t.Fatalf("ExpandComponentInstances() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ExpandComponentInstances() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
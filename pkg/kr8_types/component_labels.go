@@ -0,0 +1,72 @@
+package kr8_types
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"github.com/tidwall/gjson"
+	"k8s.io/apimachinery/pkg/labels"
+
+	"github.com/ice-bergtech/kr8/pkg/types"
+)
+
+// Resolves the labels of each component in rendered cluster params.
+// The labels of a component's `_components` entry are merged over the `labels` in its `kr8_spec`.
+// Every component in `_components` has an entry, which is empty if it has no labels.
+func ComponentLabels(config string) map[string]map[string]string {
+	result := map[string]map[string]string{}
+	for name, ref := range gjson.Get(config, "_components").Map() {
+		componentLabels := map[string]string{}
+		for key, value := range ExtractStringMap(gjson.Get(config, gjson.Escape(name)+".kr8_spec"), "labels") {
+			componentLabels[key] = value
+		}
+		for key, value := range ExtractStringMap(ref, "labels") {
+			componentLabels[key] = value
+		}
+		result[name] = componentLabels
+	}
+
+	return result
+}
+
+// Filters a list of components to those whose labels match a Kubernetes label selector,
+// such as `layer=observability,team in (platform,sre),!deprecated`.
+// config is the rendered cluster params of at least the listed components.
+// Returns the matching components sorted by name.
+func SelectComponents(components []string, config string, selector string) ([]string, error) {
+	parsed, err := labels.Parse(selector)
+	if err != nil {
+		return nil, types.Kr8Error{Message: "invalid component selector", Value: err}
+	}
+	componentLabels := ComponentLabels(config)
+	selected := []string{}
+	for _, name := range components {
+		if parsed.Matches(labels.Set(componentLabels[name])) {
+			selected = append(selected, name)
+		}
+	}
+	sort.Strings(selected)
+
+	return selected, nil
+}
+
+// Removes the params of components that aren't selected from rendered cluster params.
+// Only the keys of components in `_components` are filtered:
+// keys starting with `_`, such as `_cluster` and `_components`, and other keys are kept.
+func FilterComponentParams(config string, selected map[string]bool) (string, error) {
+	components := gjson.Get(config, "_components").Map()
+	filtered := map[string]json.RawMessage{}
+	for key, value := range gjson.Parse(config).Map() {
+		if _, isComponent := components[key]; isComponent && !strings.HasPrefix(key, "_") && !selected[key] {
+			continue
+		}
+		filtered[key] = json.RawMessage(value.Raw)
+	}
+	out, err := json.Marshal(filtered)
+	if err != nil {
+		return "", types.Kr8Error{Message: "error encoding component params", Value: err}
+	}
+
+	return string(out), nil
+}
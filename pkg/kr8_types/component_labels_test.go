@@ -0,0 +1,145 @@
+package kr8_types
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+const labelsConfig = `{
+  "_components": {
+    "grafana": { "path": "components/grafana", "labels": { "team": "sre" } },
+    "loki": { "path": "components/loki" },
+    "ingress": { "path": "components/ingress" },
+    "legacy": { "path": "components/legacy" }
+  },
+  "grafana": { "kr8_spec": { "labels": { "layer": "observability", "team": "platform" } } },
+  "loki": { "kr8_spec": { "labels": { "layer": "observability", "team": "platform" } } },
+  "ingress": { "kr8_spec": { "labels": { "layer": "network", "team": "platform" } } },
+  "legacy": { "kr8_spec": {} }
+}`
+
+func TestComponentLabels(t *testing.T) {
+	want := map[string]map[string]string{
+		"grafana": {"layer": "observability", "team": "sre"},
+		"loki":    {"layer": "observability", "team": "platform"},
+		"ingress": {"layer": "network", "team": "platform"},
+		"legacy":  {},
+	}
+	if got := ComponentLabels(labelsConfig); !reflect.DeepEqual(got, want) {
+		t.Errorf("ComponentLabels() = %v, want %v", got, want)
+	}
+}
+
+func TestSelectComponents(t *testing.T) {
+	all := []string{"legacy", "loki", "ingress", "grafana"}
+	tests := []struct {
+		name       string
+		components []string
+		selector   string
+		want       []string
+		wantErr    bool
+	}{
+		{
+			name:       "equality",
+			components: all,
+			selector:   "layer=observability",
+			want:       []string{"grafana", "loki"},
+			wantErr:    false,
+		},
+		{
+			name:       "cluster labels override component labels",
+			components: all,
+			selector:   "layer=observability,team!=sre",
+			want:       []string{"loki"},
+			wantErr:    false,
+		},
+		{
+			name:       "set based",
+			components: all,
+			selector:   "layer in (network, observability),team notin (sre)",
+			want:       []string{"ingress", "loki"},
+			wantErr:    false,
+		},
+		{
+			name:       "label missing",
+			components: all,
+			selector:   "!layer",
+			want:       []string{"legacy"},
+			wantErr:    false,
+		},
+		{
+			name:       "only listed components",
+			components: []string{"grafana", "ingress"},
+			selector:   "team",
+			want:       []string{"grafana", "ingress"},
+			wantErr:    false,
+		},
+		{
+			name:       "invalid selector",
+			components: all,
+			selector:   "layer===observability",
+			want:       nil,
+			wantErr:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := SelectComponents(tt.components, labelsConfig, tt.selector)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SelectComponents() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("SelectComponents() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilterComponentParams(t *testing.T) {
+	config := `{
+  "_kr8_spec": { "generate_dir": "generated" },
+  "_cluster": { "cluster_name": "dev" },
+  "_components": { "grafana": { "path": "components/grafana" }, "loki": { "path": "components/loki" } },
+  "grafana": { "replicas": 1 },
+  "loki": { "replicas": 2 },
+  "extra": true
+}`
+	tests := []struct {
+		name     string
+		selected map[string]bool
+		want     []string
+	}{
+		{
+			name:     "selected component",
+			selected: map[string]bool{"grafana": true},
+			want:     []string{"_cluster", "_components", "_kr8_spec", "extra", "grafana"},
+		},
+		{
+			name:     "no component selected",
+			selected: map[string]bool{},
+			want:     []string{"_cluster", "_components", "_kr8_spec", "extra"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := FilterComponentParams(config, tt.selected)
+			if err != nil {
+				t.Fatalf("FilterComponentParams() error = %v", err)
+			}
+			keys := []string{}
+			for key := range gjson.Parse(got).Map() {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			if !reflect.DeepEqual(keys, tt.want) {
+				t.Errorf("FilterComponentParams() keys = %v, want %v", keys, tt.want)
+			}
+			if name := gjson.Get(got, "_cluster.cluster_name").String(); name != "dev" {
+				t.Errorf("FilterComponentParams() _cluster.cluster_name = %q, want dev", name)
+			}
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package kr8_types
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/tidwall/gjson"
+
+	"github.com/ice-bergtech/kr8/pkg/types"
+)
+
+// The order components of a cluster should be applied in, based on their `depends_on`.
+type Kr8ComponentOrder struct {
+	// Component names in dependency order.
+	// Components are sorted by wave, then by name.
+	Order []string `json:"order"`
+	// The wave of each component.
+	// Components without dependencies are in wave 0, other components are one wave after their latest dependency.
+	Waves map[string]int `json:"waves"`
+	// The dependencies of each component
+	DependsOn map[string][]string `json:"depends_on"`
+}
+
+// Builds the component order from rendered cluster params.
+// Every component in `_components` is ordered, using the `kr8_spec.depends_on` of each rendered component.
+func ResolveComponentOrder(config string) (*Kr8ComponentOrder, error) {
+	dependsOn := map[string][]string{}
+	for name := range gjson.Get(config, "_components").Map() {
+		dependsOn[name] = ExtractStringList(gjson.Get(config, gjson.Escape(name)+".kr8_spec"), "depends_on")
+	}
+
+	return OrderComponents(dependsOn)
+}
+
+// Orders components by their dependencies.
+// dependsOn is keyed by every component in the cluster.
+// Returns an error if a dependency is not a component of the cluster, or if the dependencies form a cycle.
+func OrderComponents(dependsOn map[string][]string) (*Kr8ComponentOrder, error) {
+	names := make([]string, 0, len(dependsOn))
+	for name := range dependsOn {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		for _, dependency := range dependsOn[name] {
+			if _, ok := dependsOn[dependency]; !ok {
+				return nil, types.Kr8Error{
+					Message: "component depends on a component not in the cluster's `_components`",
+					Value:   name + " -> " + dependency,
+				}
+			}
+		}
+	}
+
+	waves := make(map[string]int, len(names))
+	// Components on the current path, used to detect cycles
+	visiting := map[string]bool{}
+	var path []string
+	var visit func(name string) error
+	visit = func(name string) error {
+		if _, done := waves[name]; done {
+			return nil
+		}
+		if visiting[name] {
+			start := 0
+			for idx, entry := range path {
+				if entry == name {
+					start = idx
+				}
+			}
+
+			return types.Kr8Error{
+				Message: "component dependency cycle",
+				Value:   strings.Join(append(path[start:], name), " -> "),
+			}
+		}
+		visiting[name] = true
+		path = append(path, name)
+		wave := 0
+		for _, dependency := range dependsOn[name] {
+			if err := visit(dependency); err != nil {
+				return err
+			}
+			wave = max(wave, waves[dependency]+1)
+		}
+		path = path[:len(path)-1]
+		visiting[name] = false
+		waves[name] = wave
+
+		return nil
+	}
+	for _, name := range names {
+		if err := visit(name); err != nil {
+			return nil, err
+		}
+	}
+
+	order := append([]string{}, names...)
+	sort.SliceStable(order, func(i, j int) bool {
+		return waves[order[i]] < waves[order[j]]
+	})
+
+	return &Kr8ComponentOrder{Order: order, Waves: waves, DependsOn: dependsOn}, nil
+}
@@ -0,0 +1,90 @@
+package kr8_types
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestOrderComponents(t *testing.T) {
+	tests := []struct {
+		name      string
+		dependsOn map[string][]string
+		wantOrder []string
+		wantWaves map[string]int
+		wantErr   bool
+	}{
+		{
+			name:      "no dependencies",
+			dependsOn: map[string][]string{"b": nil, "a": nil},
+			wantOrder: []string{"a", "b"},
+			wantWaves: map[string]int{"a": 0, "b": 0},
+			wantErr:   false,
+		},
+		{
+			name: "waves follow the longest dependency chain",
+			dependsOn: map[string][]string{
+				"ingress":      {"cert-manager", "dns"},
+				"cert-manager": {"crds"},
+				"crds":         nil,
+				"dns":          nil,
+				"app":          {"ingress"},
+			},
+			wantOrder: []string{"crds", "dns", "cert-manager", "ingress", "app"},
+			wantWaves: map[string]int{"crds": 0, "dns": 0, "cert-manager": 1, "ingress": 2, "app": 3},
+			wantErr:   false,
+		},
+		{
+			name:      "unknown dependency",
+			dependsOn: map[string][]string{"ingress": {"cert-manager"}},
+			wantOrder: nil,
+			wantWaves: nil,
+			wantErr:   true,
+		},
+		{
+			name:      "cycle",
+			dependsOn: map[string][]string{"a": {"b"}, "b": {"c"}, "c": {"a"}},
+			wantOrder: nil,
+			wantWaves: nil,
+			wantErr:   true,
+		},
+		{
+			name:      "self dependency",
+			dependsOn: map[string][]string{"a": {"a"}},
+			wantOrder: nil,
+			wantWaves: nil,
+			wantErr:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := OrderComponents(tt.dependsOn)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("OrderComponents() `%v` error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !reflect.DeepEqual(got.Order, tt.wantOrder) {
+				t.Errorf("OrderComponents() `%v` order = %v, want %v", tt.name, got.Order, tt.wantOrder)
+			}
+			if !reflect.DeepEqual(got.Waves, tt.wantWaves) {
+				t.Errorf("OrderComponents() `%v` waves = %v, want %v", tt.name, got.Waves, tt.wantWaves)
+			}
+		})
+	}
+}
+
+func TestResolveComponentOrder(t *testing.T) {
+	config := `{
+		"_components": {"app": {"path": "components/app"}, "db": {"path": "components/db"}},
+		"app": {"kr8_spec": {"depends_on": ["db"]}}
+	}`
+	got, err := ResolveComponentOrder(config)
+	if err != nil {
+		t.Fatalf("ResolveComponentOrder() error = %v", err)
+	}
+	if want := []string{"db", "app"}; !reflect.DeepEqual(got.Order, want) {
+		t.Errorf("ResolveComponentOrder() order = %v, want %v", got.Order, want)
+	}
+}
@@ -37,8 +37,30 @@ type Kr8ClusterJsonnet struct {
 // A reference to a component folder that contains a params.jsonnet file.
 // This is used in the cluster jsonnet file to reference components.
 type Kr8ClusterComponentRef struct {
-	// The path to a component folder that contains a params.jsonnet file
+	// The path to a component folder that contains a params.jsonnet file.
+	// Set from the component cache for components with a source
 	Path string `json:"path" jsonschema:"example=components/service"`
+	// A git repository to fetch the component folder from, instead of a path.
+	// Fetched with `kr8 sources fetch`, and pinned to a commit in the sources lockfile
+	Source string `json:"source,omitempty" jsonschema:"example=git::https://example.com/components.git//service?ref=v1.2.0"`
+	// Labels used to select the component, merged over the labels in the component's `kr8_spec`
+	Labels map[string]string `json:"labels,omitempty"`
+	// Instances of the component, each processed as a component of its own sharing the component's path.
+	// A list of instance names, or an object of instance name to Kr8ComponentInstance.
+	// The component is replaced by its instances, see ExpandComponentInstances
+	Instances json.RawMessage `json:"instances,omitempty"`
+	// Name of the component an instance was expanded from. Not read from config
+	InstanceOf string `json:"instance_of,omitempty"`
+	// Params of an instance, merged over the component's default params. Not read from config
+	Params json.RawMessage `json:"-"`
+}
+
+// Options of a component instance, in the `instances` of a `_components` entry.
+type Kr8ComponentInstance struct {
+	// Params merged over the component's default params
+	Params json.RawMessage `json:"params,omitempty"`
+	// Labels merged over the labels of the component's `_components` entry
+	Labels map[string]string `json:"labels,omitempty"`
 }
 
 // The specification for how to process a cluster.
@@ -56,12 +78,144 @@ type Kr8ClusterSpec struct {
 	EnableCache bool `json:"cache_enable,omitempty" jsonschema:"default=false"`
 	// If true, kr8+ will compress the cache in a gzip file instead of raw json.
 	CompressCache bool `json:"cache_compress,omitempty" jsonschema:"default=true"`
+	// The Kubernetes version the cluster runs, e.g. `1.29.0`.
+	// Used to select schemas when validating generated output, and to report deprecated or removed APIs.
+	KubernetesVersion string `json:"kubernetes_version,omitempty" jsonschema:"example=1.29.0"`
+	// Configures validation of generated objects against Kubernetes schemas.
+	SchemaValidation *Kr8SchemaValidationSpec `json:"schema_validation,omitempty"`
+	// Jsonnet policies that every generated object is checked against
+	Policies []Kr8PolicySpec `json:"policies,omitempty"`
+	// How resources generated by more than one component are reported: `warn`, `deny` or `ignore`. Default `warn`
+	DuplicateResources string `json:"duplicate_resources,omitempty" jsonschema:"enum=warn,enum=deny,enum=ignore,default=warn"`
+	// Labels added to every generated object. Labels set on the object take precedence
+	CommonLabels map[string]string `json:"common_labels,omitempty"`
+	// Annotations added to every generated object. Annotations set on the object take precedence
+	CommonAnnotations map[string]string `json:"common_annotations,omitempty"`
+	// If true, namespaced objects without a namespace are placed in the component's `namespace`
+	InjectNamespace bool `json:"inject_namespace,omitempty" jsonschema:"default=false"`
+	// Kinds of custom resources that are cluster-scoped, in addition to the built-in list.
+	// Cluster-scoped objects never have a namespace injected.
+	ClusterScopedKinds []string `json:"cluster_scoped_kinds,omitempty"`
+	// Adds `checksum/<name>` annotations to pod templates referencing generated ConfigMaps and Secrets.
+	// `component` matches references within a component, `cluster` within the cluster. Default `none`
+	ConfigHash string `json:"config_hash,omitempty" jsonschema:"enum=none,enum=component,enum=cluster,default=none"`
+	// If true, every generated object is annotated with its component's ArgoCD sync wave.
+	// The wave is the component's depth in the `depends_on` graph
+	SyncWaves bool `json:"sync_waves,omitempty" jsonschema:"default=false"`
+	// Generates an ArgoCD Application for each component
+	ArgoCD *Kr8ArgoCDSpec `json:"argocd,omitempty"`
+	// Template for the path of each generated file, relative to the cluster output directory.
+	// Default `{{ .component }}/{{ .dest_dir }}/{{ .file }}`
+	OutputLayout string `json:"output_layout,omitempty" jsonschema:"example={{ .namespace }}/{{ .component }}/{{ .file }}"`
+	// Profiles merged into the cluster params, from `profiles/<name>.jsonnet` in the base directory.
+	// Merged in order after the directory `params.jsonnet` files, before `cluster.jsonnet`
+	Profiles []string `json:"profiles,omitempty"`
 	// The name of the cluster
 	// Not read from config.
 	Name string `json:"-"`
 	// Cluster output directory
 	// Not read from config.
 	ClusterOutputDir string `json:"-"`
+	// Dependency order of the cluster's components
+	// Not read from config.
+	ComponentOrder *Kr8ComponentOrder `json:"-"`
+	// Files generated for each component, relative to the cluster output directory.
+	// Only set if `output_layout` is set. Not read from config.
+	OutputFiles map[string][]string `json:"-"`
+}
+
+// Configures validation of generated Kubernetes objects against JSON schemas.
+// Schemas are read from a local directory, and from CRDs found in the cluster's generated output.
+type Kr8SchemaValidationSpec struct {
+	// If true, generated objects are validated after all components are generated
+	Enabled bool `json:"enabled,omitempty" jsonschema:"default=false"`
+	// Directory containing Kubernetes JSON schemas. Relative paths are resolved from the base directory.
+	SchemaDir string `json:"schema_dir,omitempty" jsonschema:"default=schemas"`
+	// If true, schemas derived from CRDs reject unknown fields
+	Strict bool `json:"strict,omitempty" jsonschema:"default=false"`
+	// If true, objects without a matching schema are not reported
+	IgnoreMissing bool `json:"ignore_missing,omitempty" jsonschema:"default=false"`
+}
+
+// Extracts the schema validation configuration from a cluster spec.
+// Returns nil if validation is not enabled.
+func ExtractSchemaValidation(spec gjson.Result, baseDir string) *Kr8SchemaValidationSpec {
+	validation := spec.Get("schema_validation")
+	if !validation.Get("enabled").Bool() {
+		return nil
+	}
+	schemaDir := validation.Get("schema_dir").String()
+	if schemaDir == "" {
+		schemaDir = "schemas"
+	}
+	if !filepath.IsAbs(schemaDir) {
+		schemaDir = filepath.Join(baseDir, schemaDir)
+	}
+
+	return &Kr8SchemaValidationSpec{
+		Enabled:       true,
+		SchemaDir:     schemaDir,
+		Strict:        validation.Get("strict").Bool(),
+		IgnoreMissing: validation.Get("ignore_missing").Bool(),
+	}
+}
+
+// A jsonnet policy that generated objects are checked against.
+// The policy is a function `function(object, context)` that returns null or an empty list if the object passes,
+// otherwise a violation message or a list of messages.
+type Kr8PolicySpec struct {
+	// Name of the policy, used when reporting violations. Defaults to the file name
+	Name string `json:"name,omitempty"`
+	// Jsonnet file containing the policy, imported relative to the base directory or the `lib` directory
+	File string `json:"file" jsonschema:"example=lib/policies/no-latest.libsonnet"`
+	// Optional field of the imported file that holds the policy function
+	Function string `json:"func,omitempty"`
+	// Severity of violations: `warn` or `deny`. Default `deny`
+	Severity string `json:"severity,omitempty" jsonschema:"enum=warn,enum=deny,default=deny"`
+}
+
+// Extracts policy definitions from a cluster spec.
+// Policies can be a list, or an object keyed by policy name.
+// Returns nil if no policies are defined.
+func ExtractPolicies(spec gjson.Result) ([]Kr8PolicySpec, error) {
+	var policies []Kr8PolicySpec
+	var err error
+	spec.Get("policies").ForEach(func(key, value gjson.Result) bool {
+		policy := Kr8PolicySpec{
+			Name:     value.Get("name").String(),
+			File:     value.Get("file").String(),
+			Function: value.Get("func").String(),
+			Severity: value.Get("severity").String(),
+		}
+		if policy.Name == "" && key.Type == gjson.String {
+			policy.Name = key.String()
+		}
+		if policy.File == "" {
+			err = types.Kr8Error{Message: "policy is missing `file`", Value: value.Raw}
+
+			return false
+		}
+		if policy.Name == "" {
+			policy.Name = strings.TrimSuffix(filepath.Base(policy.File), filepath.Ext(policy.File))
+		}
+		switch policy.Severity {
+		case "":
+			policy.Severity = "deny"
+		case "warn", "deny":
+		default:
+			err = types.Kr8Error{Message: "invalid policy severity for " + policy.Name, Value: policy.Severity}
+
+			return false
+		}
+		policies = append(policies, policy)
+
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return policies, nil
 }
 
 // This function creates a Kr8ClusterSpec from passed params.
@@ -99,6 +253,31 @@ func CreateClusterSpec(
 		compress = compressVar.Bool()
 	}
 
+	policies, err := ExtractPolicies(spec)
+	if err != nil {
+		return Kr8ClusterSpec{}, err
+	}
+	duplicates := spec.Get("duplicate_resources").String()
+	switch duplicates {
+	case "", "warn", "deny", "ignore":
+	default:
+		return Kr8ClusterSpec{}, types.Kr8Error{Message: "invalid `duplicate_resources` value", Value: duplicates}
+	}
+	configHash, err := ExtractConfigHash(spec)
+	if err != nil {
+		return Kr8ClusterSpec{}, err
+	}
+	outputLayout := spec.Get("output_layout").String()
+	if outputLayout != "" {
+		if _, err := ParseOutputLayout(outputLayout); err != nil {
+			return Kr8ClusterSpec{}, err
+		}
+	}
+	argocd := ExtractArgoCD(spec)
+	if argocd != nil {
+		argocd.setDefaults(kr8Opts.BaseDir, clGenerateDir, clusterName, clusterDir)
+	}
+
 	return Kr8ClusterSpec{
 		PostProcessor:      spec.Get("postprocessor").String(),
 		GenerateDir:        clGenerateDir,
@@ -106,6 +285,21 @@ func CreateClusterSpec(
 		PruneParams:        spec.Get("prune_params").Bool(),
 		EnableCache:        spec.Get("cache_enable").Bool(),
 		CompressCache:      compress,
+		KubernetesVersion:  spec.Get("kubernetes_version").String(),
+		SchemaValidation:   ExtractSchemaValidation(spec, kr8Opts.BaseDir),
+		Policies:           policies,
+		DuplicateResources: duplicates,
+		CommonLabels:       ExtractStringMap(spec, "common_labels"),
+		CommonAnnotations:  ExtractStringMap(spec, "common_annotations"),
+		InjectNamespace:    spec.Get("inject_namespace").Bool(),
+		ClusterScopedKinds: ExtractStringList(spec, "cluster_scoped_kinds"),
+		ConfigHash:         configHash,
+		SyncWaves:          spec.Get("sync_waves").Bool(),
+		ArgoCD:             argocd,
+		OutputLayout:       outputLayout,
+		Profiles:           ExtractStringList(spec, "profiles"),
+		ComponentOrder:     nil,
+		OutputFiles:        nil,
 		ClusterOutputDir:   clGenerateDir + "/" + clusterName,
 		Name:               clusterName,
 	}, nil
@@ -132,6 +326,8 @@ type Kr8ComponentSpec struct {
 	Kr8_allParams bool `json:"enable_kr8_allparams,omitempty"`
 	// If true, includes the parameters of all other clusters when generating this component
 	Kr8_allClusters bool `json:"enable_kr8_allclusters,omitempty"`
+	// If true, includes the rendered objects of the components in `depends_on` when generating this component
+	Kr8_outputs bool `json:"enable_kr8_outputs,omitempty"`
 	// If false, all non-generated files present in the output directory are removed
 	DisableOutputDirClean bool `json:"disable_output_clean,omitempty"`
 	// If true, component will not be cached if cluster caching is enabled.
@@ -142,6 +338,102 @@ type Kr8ComponentSpec struct {
 	JPaths []string `json:"jpaths,omitempty"`
 	// A list of filenames to include and output as files
 	Includes Kr8ComponentSpecIncludes `json:"includes"`
+	// Labels added to every object the component generates, merged over the cluster's `common_labels`
+	CommonLabels map[string]string `json:"common_labels,omitempty"`
+	// Annotations added to every object the component generates, merged over the cluster's `common_annotations`
+	CommonAnnotations map[string]string `json:"common_annotations,omitempty"`
+	// Overrides the cluster's `inject_namespace` for this component
+	InjectNamespace *bool `json:"inject_namespace,omitempty"`
+	// Ordered list of postprocessors applied to the component's output after the cluster `postprocessor`
+	PostProcessors []Kr8PostProcessorSpec `json:"postprocessors,omitempty"`
+	// Overrides the cluster's `config_hash` scope for this component
+	ConfigHash string `json:"config_hash,omitempty" jsonschema:"enum=none,enum=component,enum=cluster"`
+	// Names of components in the cluster's `_components` that must be applied before this component
+	DependsOn []string `json:"depends_on,omitempty"`
+	// Overrides the cluster's `argocd` configuration for this component's Application
+	ArgoCD *Kr8ArgoCDSpec `json:"argocd,omitempty"`
+	// Labels used to select the component with a label selector, such as its team or layer
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// A component postprocessor.
+// Written in the spec as a string of jsonnet code, or an object referencing a jsonnet file.
+// The postprocessor is a function `function(input)` or `function(input, context)`.
+type Kr8PostProcessorSpec struct {
+	// Jsonnet code that evaluates to the postprocessor function
+	Code string `json:"code,omitempty" jsonschema:"example=function(input) input"`
+	// Jsonnet file containing the postprocessor, imported relative to the component directory or library paths
+	File string `json:"file,omitempty" jsonschema:"example=postprocessors.libsonnet"`
+	// Optional field of the imported file that holds the postprocessor function
+	Function string `json:"func,omitempty"`
+}
+
+// Extracts the ordered list of postprocessors from a component spec.
+// Returns nil if no postprocessors are defined.
+func ExtractPostProcessors(spec gjson.Result) ([]Kr8PostProcessorSpec, error) {
+	var postProcessors []Kr8PostProcessorSpec
+	for _, entry := range spec.Get("postprocessors").Array() {
+		if entry.Type == gjson.String {
+			postProcessors = append(postProcessors, Kr8PostProcessorSpec{Code: entry.String(), File: "", Function: ""})
+
+			continue
+		}
+		postProcessor := Kr8PostProcessorSpec{
+			Code:     entry.Get("code").String(),
+			File:     entry.Get("file").String(),
+			Function: entry.Get("func").String(),
+		}
+		if (postProcessor.Code == "") == (postProcessor.File == "") {
+			return nil, types.Kr8Error{Message: "postprocessor must set one of `code` or `file`", Value: entry.Raw}
+		}
+		postProcessors = append(postProcessors, postProcessor)
+	}
+
+	return postProcessors, nil
+}
+
+// Config hash scopes, see [Kr8ClusterSpec.ConfigHash].
+const (
+	ConfigHashNone      = "none"
+	ConfigHashComponent = "component"
+	ConfigHashCluster   = "cluster"
+)
+
+// Extracts and validates the `config_hash` scope from a spec.
+// Returns an empty string if it is not set.
+func ExtractConfigHash(spec gjson.Result) (string, error) {
+	configHash := spec.Get("config_hash").String()
+	switch configHash {
+	case "", ConfigHashNone, ConfigHashComponent, ConfigHashCluster:
+		return configHash, nil
+	default:
+		return "", types.Kr8Error{Message: "invalid `config_hash` value", Value: configHash}
+	}
+}
+
+// Extracts a map of string values from a spec field.
+// Returns nil if the field is missing or empty.
+func ExtractStringMap(spec gjson.Result, key string) map[string]string {
+	var result map[string]string
+	for k, v := range spec.Get(key).Map() {
+		if result == nil {
+			result = make(map[string]string)
+		}
+		result[k] = v.String()
+	}
+
+	return result
+}
+
+// Extracts a list of string values from a spec field.
+// Returns nil if the field is missing or empty.
+func ExtractStringList(spec gjson.Result, key string) []string {
+	var result []string
+	for _, v := range spec.Get(key).Array() {
+		result = append(result, v.String())
+	}
+
+	return result
 }
 
 // Extract jsonnet extVar definitions from spec.
@@ -199,14 +491,36 @@ func CreateComponentSpec(spec gjson.Result, logger zerolog.Logger) (Kr8Component
 			types.Kr8Error{Message: "Component includes are malformed", Value: err}
 	}
 
+	postProcessors, err := ExtractPostProcessors(spec)
+	if err != nil {
+		return Kr8ComponentSpec{}, err
+	}
+	configHash, err := ExtractConfigHash(spec)
+	if err != nil {
+		return Kr8ComponentSpec{}, err
+	}
+
 	componentSpec := Kr8ComponentSpec{
 		Kr8_allParams:         spec.Get("enable_kr8_allparams").Bool(),
 		Kr8_allClusters:       spec.Get("enable_kr8_allclusters").Bool(),
+		Kr8_outputs:           spec.Get("enable_kr8_outputs").Bool(),
 		DisableOutputDirClean: spec.Get("disable_output_clean").Bool(),
 		ExtFiles:              ExtractExtFiles(spec),
 		JPaths:                ExtractJpaths(spec),
 		Includes:              includes,
 		DisableCache:          false,
+		CommonLabels:          ExtractStringMap(spec, "common_labels"),
+		CommonAnnotations:     ExtractStringMap(spec, "common_annotations"),
+		InjectNamespace:       nil,
+		PostProcessors:        postProcessors,
+		ConfigHash:            configHash,
+		DependsOn:             ExtractStringList(spec, "depends_on"),
+		ArgoCD:                ExtractArgoCD(spec),
+		Labels:                ExtractStringMap(spec, "labels"),
+	}
+	if injectNamespace := spec.Get("inject_namespace"); injectNamespace.Exists() {
+		inject := injectNamespace.Bool()
+		componentSpec.InjectNamespace = &inject
 	}
 
 	return componentSpec, nil
@@ -145,6 +145,32 @@ func TestCreateClusterSpec(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name:        "invalid duplicate_resources",
+			clusterName: "test-cluster",
+			spec:        gjson.Parse(`{"duplicate_resources": "fail"}`),
+			kr8Opts: types.Kr8Opts{
+				BaseDir:      "/path/to/kr8",
+				ComponentDir: "",
+				ClusterDir:   "",
+			},
+			genDirOverride:     "",
+			wantKr8ClusterSpec: Kr8ClusterSpec{},
+			wantErr:            true,
+		},
+		{
+			name:        "invalid config_hash",
+			clusterName: "test-cluster",
+			spec:        gjson.Parse(`{"config_hash": "namespace"}`),
+			kr8Opts: types.Kr8Opts{
+				BaseDir:      "/path/to/kr8",
+				ComponentDir: "",
+				ClusterDir:   "",
+			},
+			genDirOverride:     "",
+			wantKr8ClusterSpec: Kr8ClusterSpec{},
+			wantErr:            true,
+		},
 	}
 
 	for _, testEntry := range tests {
@@ -172,6 +198,128 @@ func TestCreateClusterSpec(t *testing.T) {
 	}
 }
 
+func TestExtractPolicies(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    gjson.Result
+		want    []Kr8PolicySpec
+		wantErr bool
+	}{
+		{
+			name:    "no policies",
+			spec:    gjson.Parse(`{}`),
+			want:    nil,
+			wantErr: false,
+		},
+		{
+			name: "policy list with defaults",
+			spec: gjson.Parse(`{
+				"policies": [
+					{"file": "lib/policies/no-latest.libsonnet"},
+					{"name": "limits", "file": "lib/policies.libsonnet", "func": "limits", "severity": "warn"}
+				]
+			}`),
+			want: []Kr8PolicySpec{
+				{Name: "no-latest", File: "lib/policies/no-latest.libsonnet", Function: "", Severity: "deny"},
+				{Name: "limits", File: "lib/policies.libsonnet", Function: "limits", Severity: "warn"},
+			},
+			wantErr: false,
+		},
+		{
+			name: "policy object keyed by name",
+			spec: gjson.Parse(`{
+				"policies": {
+					"host-network": {"file": "lib/policies.libsonnet", "func": "hostNetwork"}
+				}
+			}`),
+			want: []Kr8PolicySpec{
+				{Name: "host-network", File: "lib/policies.libsonnet", Function: "hostNetwork", Severity: "deny"},
+			},
+			wantErr: false,
+		},
+		{
+			name:    "missing file",
+			spec:    gjson.Parse(`{"policies": [{"name": "broken"}]}`),
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name:    "invalid severity",
+			spec:    gjson.Parse(`{"policies": [{"file": "p.libsonnet", "severity": "fatal"}]}`),
+			want:    nil,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ExtractPolicies(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ExtractPolicies() `%v` error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ExtractPolicies() `%v` got = \n%v\n-want-\n%v", tt.name, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractPostProcessors(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    gjson.Result
+		want    []Kr8PostProcessorSpec
+		wantErr bool
+	}{
+		{
+			name:    "no postprocessors",
+			spec:    gjson.Parse(`{}`),
+			want:    nil,
+			wantErr: false,
+		},
+		{
+			name: "code and file references",
+			spec: gjson.Parse(`{
+				"postprocessors": [
+					"function(input) input",
+					{"file": "lib/process.libsonnet", "func": "stripStatus"},
+					{"code": "function(input, ctx) input"}
+				]
+			}`),
+			want: []Kr8PostProcessorSpec{
+				{Code: "function(input) input", File: "", Function: ""},
+				{Code: "", File: "lib/process.libsonnet", Function: "stripStatus"},
+				{Code: "function(input, ctx) input", File: "", Function: ""},
+			},
+			wantErr: false,
+		},
+		{
+			name:    "missing code and file",
+			spec:    gjson.Parse(`{"postprocessors": [{"func": "stripStatus"}]}`),
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name:    "both code and file",
+			spec:    gjson.Parse(`{"postprocessors": [{"code": "function(input) input", "file": "p.libsonnet"}]}`),
+			want:    nil,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ExtractPostProcessors(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ExtractPostProcessors() `%v` error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ExtractPostProcessors() `%v` got = \n%v\n-want-\n%v", tt.name, got, tt.want)
+			}
+		})
+	}
+}
+
 func TestExtractExtFiles(t *testing.T) {
 	tests := []struct {
 		name string
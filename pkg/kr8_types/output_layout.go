@@ -0,0 +1,56 @@
+package kr8_types
+
+import (
+	"bytes"
+	"path"
+	"strings"
+	"text/template"
+
+	"github.com/Masterminds/sprig/v3"
+
+	types "github.com/ice-bergtech/kr8/pkg/types"
+)
+
+// Output layout equivalent to the default placement of generated files.
+const DefaultOutputLayout = "{{ .component }}/{{ .dest_dir }}/{{ .file }}"
+
+// Parses an output layout template.
+// Missing keys are an error, so typos in the template are reported instead of producing empty path segments.
+func ParseOutputLayout(layout string) (*template.Template, error) {
+	tmpl, err := template.New("output_layout").Funcs(sprig.FuncMap()).Option("missingkey=error").Parse(layout)
+	if err != nil {
+		return nil, types.Kr8Error{Message: "error parsing `output_layout` template", Value: err}
+	}
+
+	return tmpl, nil
+}
+
+// Renders the path of a generated file from an output layout template.
+// The returned path is cleaned, uses forward slashes and is relative to the cluster output directory.
+// Paths that are empty, absolute, leave the cluster output directory or shadow kr8+ bookkeeping files are an error.
+func RenderOutputLayout(layout string, data map[string]any) (string, error) {
+	tmpl, err := ParseOutputLayout(layout)
+	if err != nil {
+		return "", err
+	}
+	var buffer bytes.Buffer
+	if err := tmpl.Execute(&buffer, data); err != nil {
+		return "", types.Kr8Error{Message: "error executing `output_layout` template", Value: err}
+	}
+	rendered := strings.TrimSpace(buffer.String())
+	if rendered == "" || strings.HasSuffix(rendered, "/") {
+		return "", types.Kr8Error{Message: "`output_layout` must render a file path", Value: rendered}
+	}
+	if path.IsAbs(rendered) {
+		return "", types.Kr8Error{Message: "`output_layout` must render a relative path", Value: rendered}
+	}
+	cleaned := path.Clean(rendered)
+	if cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", types.Kr8Error{Message: "`output_layout` path leaves the cluster output directory", Value: rendered}
+	}
+	if !strings.Contains(cleaned, "/") && strings.HasPrefix(cleaned, ".kr8_") {
+		return "", types.Kr8Error{Message: "`output_layout` path is reserved for kr8+ bookkeeping", Value: rendered}
+	}
+
+	return cleaned, nil
+}
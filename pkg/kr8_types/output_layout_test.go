@@ -0,0 +1,75 @@
+package kr8_types
+
+import "testing"
+
+func TestRenderOutputLayout(t *testing.T) {
+	data := map[string]any{
+		"cluster":        "dev",
+		"component":      "web",
+		"namespace":      "apps",
+		"dest_dir":       "",
+		"file":           "web.yaml",
+		"cluster_params": map[string]any{"environment": "staging"},
+	}
+	tests := []struct {
+		name    string
+		layout  string
+		want    string
+		wantErr bool
+	}{
+		{
+			name:    "default layout",
+			layout:  DefaultOutputLayout,
+			want:    "web/web.yaml",
+			wantErr: false,
+		},
+		{
+			name:    "namespace and cluster params",
+			layout:  "{{ .cluster_params.environment }}/{{ .namespace }}/{{ .component }}/{{ .file }}",
+			want:    "staging/apps/web/web.yaml",
+			wantErr: false,
+		},
+		{
+			name:    "missing key",
+			layout:  "{{ .environment }}/{{ .file }}",
+			want:    "",
+			wantErr: true,
+		},
+		{
+			name:    "leaves cluster output directory",
+			layout:  "../{{ .cluster }}/{{ .file }}",
+			want:    "",
+			wantErr: true,
+		},
+		{
+			name:    "absolute path",
+			layout:  "/{{ .file }}",
+			want:    "",
+			wantErr: true,
+		},
+		{
+			name:    "directory",
+			layout:  "{{ .component }}/",
+			want:    "",
+			wantErr: true,
+		},
+		{
+			name:    "bookkeeping file",
+			layout:  ".kr8_cache",
+			want:    "",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := RenderOutputLayout(tt.layout, data)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("RenderOutputLayout() `%v` error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("RenderOutputLayout() `%v` = %v, want %v", tt.name, got, tt.want)
+			}
+		})
+	}
+}
@@ -9,6 +9,8 @@ import (
 type Kr8Cluster struct {
 	Name string `json:"name"`
 	Path string `json:"-"`
+	// Path to the `clusters.jsonnet` matrix defining the cluster. Empty for clusters with their own `cluster.jsonnet`
+	Matrix string `json:"-"`
 }
 
 // Options that configure where kr8+ looks for files.
@@ -0,0 +1,206 @@
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	types "github.com/ice-bergtech/kr8/pkg/types"
+)
+
+// File defining a single cluster, named after its directory.
+const ClusterFile = "cluster.jsonnet"
+
+// File defining a matrix of clusters, as an object of cluster name to cluster object.
+const ClusterMatrixFile = "clusters.jsonnet"
+
+// Separates the matrix file from the cluster name in the params entry of a matrix cluster.
+const clusterMatrixSeparator = "#"
+
+// Evaluates a cluster matrix file and returns the names of the clusters it defines.
+type ClusterMatrixLoader func(file string) ([]string, error)
+
+// An index of the clusters within a directory, keyed by cluster name.
+// A cluster is named after the directory containing its `cluster.jsonnet`,
+// or after its key in a `clusters.jsonnet` matrix,
+// so the name must be unique within the directory tree.
+type ClusterIndex struct {
+	clusters map[string]types.Kr8Cluster
+}
+
+// Walks a directory tree, skipping hidden directories, and indexes each cluster.jsonnet file found,
+// and each cluster of the clusters.jsonnet files found, listed with loadMatrix.
+// Matrix clusters get the path of a directory named after them, next to the matrix file,
+// so they can be referenced by path like other clusters.
+// Returns an error listing the paths of every cluster name that is used more than once,
+// as their generated output would overwrite each other.
+func LoadClusterIndex(searchDir string, loadMatrix ClusterMatrixLoader) (*ClusterIndex, error) {
+	found := map[string][]types.Kr8Cluster{}
+	err := filepath.Walk(searchDir, func(path string, f os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if f.IsDir() {
+			// Hidden directories, such as fetched component sources, aren't searched
+			if path != searchDir && strings.HasPrefix(f.Name(), ".") {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+		dir := filepath.ToSlash(filepath.Dir(path))
+		switch f.Name() {
+		case ClusterFile:
+			name := dir[strings.LastIndex(dir, "/")+1:]
+			found[name] = append(found[name], types.Kr8Cluster{Name: name, Path: dir, Matrix: ""})
+		case ClusterMatrixFile:
+			names, err := loadClusterMatrix(filepath.ToSlash(path), loadMatrix)
+			if err != nil {
+				return err
+			}
+			for _, name := range names {
+				found[name] = append(found[name], types.Kr8Cluster{
+					Name:   name,
+					Path:   dir + "/" + name,
+					Matrix: filepath.ToSlash(path),
+				})
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, ErrorIfCheck("error building cluster list", err)
+	}
+
+	index := &ClusterIndex{clusters: make(map[string]types.Kr8Cluster, len(found))}
+	duplicates := []string{}
+	for name, clusters := range found {
+		if len(clusters) > 1 {
+			locations := make([]string, len(clusters))
+			for idx, cluster := range clusters {
+				locations[idx] = cluster.Path
+				if cluster.Matrix != "" {
+					locations[idx] = cluster.Matrix
+				}
+			}
+			sort.Strings(locations)
+			duplicates = append(duplicates, name+" ("+strings.Join(locations, ", ")+")")
+
+			continue
+		}
+		index.clusters[name] = clusters[0]
+	}
+	if len(duplicates) > 0 {
+		sort.Strings(duplicates)
+
+		return nil, types.Kr8Error{
+			Message: "duplicate cluster names, cluster directory names must be unique",
+			Value:   strings.Join(duplicates, "; "),
+		}
+	}
+
+	return index, nil
+}
+
+// Lists the clusters of a matrix file and checks their names can be used as directory names.
+func loadClusterMatrix(file string, loadMatrix ClusterMatrixLoader) ([]string, error) {
+	if loadMatrix == nil {
+		return nil, types.Kr8Error{Message: "cluster matrix files are not supported here", Value: file}
+	}
+	names, err := loadMatrix(file)
+	if err != nil {
+		return nil, ErrorIfCheck("error loading cluster matrix "+file, err)
+	}
+	for _, name := range names {
+		if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`+clusterMatrixSeparator) {
+			return nil, types.Kr8Error{Message: "invalid cluster name in " + file, Value: name}
+		}
+	}
+
+	return names, nil
+}
+
+// Returns the entry of a cluster in its params chain:
+// the path of its `cluster.jsonnet`, or for matrix clusters the matrix file and cluster name,
+// see [SplitClusterParamsEntry].
+func ClusterParamsEntry(cluster types.Kr8Cluster) string {
+	if cluster.Matrix != "" {
+		return cluster.Matrix + clusterMatrixSeparator + cluster.Name
+	}
+
+	return cluster.Path + "/" + ClusterFile
+}
+
+// Splits a params chain entry into the file to import and,
+// if the entry is a matrix cluster, the name of the cluster within the file.
+func SplitClusterParamsEntry(entry string) (string, string) {
+	idx := strings.LastIndex(entry, clusterMatrixSeparator)
+	if idx < 0 || filepath.Base(entry[:idx]) != ClusterMatrixFile {
+		return entry, ""
+	}
+
+	return entry[:idx], entry[idx+1:]
+}
+
+// Lists the indexed clusters, sorted by name.
+func (i *ClusterIndex) List() []types.Kr8Cluster {
+	clusters := make([]types.Kr8Cluster, 0, len(i.clusters))
+	for _, cluster := range i.clusters {
+		clusters = append(clusters, cluster)
+	}
+	sort.Slice(clusters, func(a, b int) bool { return clusters[a].Name < clusters[b].Name })
+
+	return clusters
+}
+
+// Finds a cluster by reference.
+// A reference is a cluster name, or a path-qualified reference such as `aws/us-east-1/prod`,
+// matching the trailing directories of the cluster's path.
+func (i *ClusterIndex) Resolve(ref string) (types.Kr8Cluster, error) {
+	ref = strings.Trim(filepath.ToSlash(filepath.Clean(ref)), "/")
+	if !IsClusterPathRef(ref) {
+		if cluster, ok := i.clusters[ref]; ok {
+			return cluster, nil
+		}
+
+		return types.Kr8Cluster{Name: "", Path: "", Matrix: ""}, types.Kr8Error{Message: "error: could not find cluster: " + ref, Value: ""}
+	}
+
+	name := ref[strings.LastIndex(ref, "/")+1:]
+	cluster, ok := i.clusters[name]
+	if ok && (cluster.Path == ref || strings.HasSuffix(cluster.Path, "/"+ref)) {
+		return cluster, nil
+	}
+
+	return types.Kr8Cluster{Name: "", Path: "", Matrix: ""}, types.Kr8Error{Message: "error: could not find cluster: " + ref, Value: ""}
+}
+
+// Checks if a cluster reference is path-qualified, such as `aws/us-east-1/prod`.
+func IsClusterPathRef(ref string) bool {
+	return strings.Contains(ref, "/")
+}
+
+// Resolves the path-qualified references in a comma separated cluster filter.
+// Each is replaced with a regular expression matching exactly the referenced cluster's name.
+// Names and regular expressions are left unchanged.
+func (i *ClusterIndex) ResolveFilter(filter string) (string, error) {
+	if filter == "" {
+		return "", nil
+	}
+	entries := strings.Split(filter, ",")
+	for idx, entry := range entries {
+		if !IsClusterPathRef(entry) {
+			continue
+		}
+		cluster, err := i.Resolve(entry)
+		if err != nil {
+			return "", err
+		}
+		entries[idx] = "^" + regexp.QuoteMeta(cluster.Name) + "$"
+	}
+
+	return strings.Join(entries, ","), nil
+}
@@ -0,0 +1,169 @@
+package util_test
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	types "github.com/ice-bergtech/kr8/pkg/types"
+	util "github.com/ice-bergtech/kr8/pkg/util"
+)
+
+func writeClusters(t *testing.T, dirs ...string) string {
+	t.Helper()
+	clusterDir := filepath.ToSlash(t.TempDir())
+	for _, dir := range dirs {
+		if err := os.MkdirAll(filepath.Join(clusterDir, dir), 0750); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(clusterDir, dir, "cluster.jsonnet"), []byte("{}"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return clusterDir
+}
+
+func TestLoadClusterIndex(t *testing.T) {
+	clusterDir := writeClusters(t, "aws/us-east-1/prod", "aws/us-east-1/staging", "gcp/dev")
+	index, err := util.LoadClusterIndex(clusterDir, nil)
+	if err != nil {
+		t.Fatalf("LoadClusterIndex() error = %v", err)
+	}
+	want := []types.Kr8Cluster{
+		{Name: "dev", Path: clusterDir + "/gcp/dev", Matrix: ""},
+		{Name: "prod", Path: clusterDir + "/aws/us-east-1/prod", Matrix: ""},
+		{Name: "staging", Path: clusterDir + "/aws/us-east-1/staging", Matrix: ""},
+	}
+	if got := index.List(); !reflect.DeepEqual(got, want) {
+		t.Errorf("List() = %v, want %v", got, want)
+	}
+
+	tests := []struct {
+		name    string
+		ref     string
+		want    string
+		wantErr bool
+	}{
+		{name: "name", ref: "prod", want: "prod", wantErr: false},
+		{name: "path", ref: "aws/us-east-1/prod", want: "prod", wantErr: false},
+		{name: "partial path", ref: "us-east-1/staging", want: "staging", wantErr: false},
+		{name: "trailing slash", ref: "gcp/dev/", want: "dev", wantErr: false},
+		{name: "wrong path", ref: "gcp/prod", want: "", wantErr: true},
+		{name: "partial directory name", ref: "east-1/prod", want: "", wantErr: true},
+		{name: "unknown name", ref: "qa", want: "", wantErr: true},
+	}
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			got, err := index.Resolve(testCase.ref)
+			if (err != nil) != testCase.wantErr {
+				t.Fatalf("Resolve(%q) error = %v, wantErr %v", testCase.ref, err, testCase.wantErr)
+			}
+			if got.Name != testCase.want {
+				t.Errorf("Resolve(%q) = %v, want %v", testCase.ref, got.Name, testCase.want)
+			}
+		})
+	}
+
+	filter, err := index.ResolveFilter("aws/us-east-1/prod,dev,^stag")
+	if err != nil {
+		t.Fatalf("ResolveFilter() error = %v", err)
+	}
+	if filter != "^prod$,dev,^stag" {
+		t.Errorf("ResolveFilter() = %q", filter)
+	}
+}
+
+func TestLoadClusterIndexDuplicates(t *testing.T) {
+	clusterDir := writeClusters(t, "aws/prod", "gcp/prod", "gcp/dev")
+	_, err := util.LoadClusterIndex(clusterDir, nil)
+	if err == nil {
+		t.Fatal("LoadClusterIndex() succeeded with duplicate cluster names")
+	}
+	if !strings.Contains(err.Error(), "prod ("+clusterDir+"/aws/prod, "+clusterDir+"/gcp/prod)") {
+		t.Errorf("LoadClusterIndex() error = %v, want both paths listed", err)
+	}
+	if _, err := util.GetClusterPath(clusterDir, "dev", nil); err == nil {
+		t.Error("GetClusterPath() succeeded with duplicate cluster names")
+	}
+}
+
+func TestLoadClusterIndexMatrix(t *testing.T) {
+	clusterDir := writeClusters(t, "aws/prod")
+	for _, dir := range []string{"edge", "dup"} {
+		if err := os.MkdirAll(filepath.Join(clusterDir, dir), 0750); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(clusterDir, dir, util.ClusterMatrixFile), []byte("{}"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	edgeMatrix := clusterDir + "/edge/" + util.ClusterMatrixFile
+	dupMatrix := clusterDir + "/dup/" + util.ClusterMatrixFile
+
+	tests := []struct {
+		name    string
+		matrix  map[string][]string
+		want    []types.Kr8Cluster
+		wantErr string
+	}{
+		{
+			name:   "matrix clusters",
+			matrix: map[string][]string{edgeMatrix: {"edge-01", "edge-02"}},
+			want: []types.Kr8Cluster{
+				{Name: "edge-01", Path: clusterDir + "/edge/edge-01", Matrix: edgeMatrix},
+				{Name: "edge-02", Path: clusterDir + "/edge/edge-02", Matrix: edgeMatrix},
+				{Name: "prod", Path: clusterDir + "/aws/prod", Matrix: ""},
+			},
+		},
+		{
+			name:    "collision with folder cluster",
+			matrix:  map[string][]string{edgeMatrix: {"prod"}},
+			wantErr: "prod (" + clusterDir + "/aws/prod, " + edgeMatrix + ")",
+		},
+		{
+			name:    "collision between matrix files",
+			matrix:  map[string][]string{edgeMatrix: {"edge-01"}, dupMatrix: {"edge-01"}},
+			wantErr: "edge-01 (" + dupMatrix + ", " + edgeMatrix + ")",
+		},
+		{
+			name:    "invalid name",
+			matrix:  map[string][]string{edgeMatrix: {"edge/01"}},
+			wantErr: "invalid cluster name",
+		},
+	}
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			index, err := util.LoadClusterIndex(clusterDir, func(file string) ([]string, error) {
+				return testCase.matrix[file], nil
+			})
+			if testCase.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), testCase.wantErr) {
+					t.Fatalf("LoadClusterIndex() error = %v, want %q", err, testCase.wantErr)
+				}
+
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadClusterIndex() error = %v", err)
+			}
+			if got := index.List(); !reflect.DeepEqual(got, testCase.want) {
+				t.Errorf("List() = %v, want %v", got, testCase.want)
+			}
+			cluster, err := index.Resolve("edge/edge-02")
+			if err != nil {
+				t.Fatalf("Resolve() error = %v", err)
+			}
+			entry := util.ClusterParamsEntry(cluster)
+			if file, name := util.SplitClusterParamsEntry(entry); file != edgeMatrix || name != "edge-02" {
+				t.Errorf("SplitClusterParamsEntry(%q) = %q, %q", entry, file, name)
+			}
+		})
+	}
+
+	if _, err := util.LoadClusterIndex(clusterDir, nil); err == nil {
+		t.Error("LoadClusterIndex() succeeded reading a cluster matrix without a loader")
+	}
+}
@@ -15,59 +15,32 @@ import (
 )
 
 // Get a list of cluster from within a directory.
-// Walks the directory tree, creating a types.Kr8Cluster for each cluster.jsonnet file found.
-func GetClusterFilenames(searchDir string) ([]types.Kr8Cluster, error) {
-	fileList := make([]string, 0)
-	ClusterData := []types.Kr8Cluster{}
-
-	err := filepath.Walk(searchDir, func(path string, f os.FileInfo, err error) error {
-		fileList = append(fileList, path)
-		// Pass error through
-		return err
-	})
+// Walks the directory tree, creating a types.Kr8Cluster for each cluster.jsonnet file found,
+// and each cluster of the clusters.jsonnet matrix files found.
+// Returns an error if a cluster name is used more than once, see [LoadClusterIndex].
+func GetClusterFilenames(searchDir string, loadMatrix ClusterMatrixLoader) ([]types.Kr8Cluster, error) {
+	index, err := LoadClusterIndex(searchDir, loadMatrix)
 	if err != nil {
-		return ClusterData, ErrorIfCheck("error building cluster list", err)
-	}
-
-	for _, file := range fileList {
-		// get the filename
-		splitFile := strings.Split(file, "/")
-		fileName := splitFile[len(splitFile)-1]
-		// check if the filename is cluster.jsonnet
-		if fileName == "cluster.jsonnet" {
-			entry := types.Kr8Cluster{Name: splitFile[len(splitFile)-2], Path: strings.Join(splitFile[:len(splitFile)-1], "/")}
-			ClusterData = append(ClusterData, entry)
-		}
+		return []types.Kr8Cluster{}, err
 	}
 
-	return ClusterData, nil
+	return index.List(), nil
 }
 
-// Get a specific cluster within a directory by name.
-// [filepath.Walk]s the cluster directory tree searching for the given clusterName.
-// Returns the path to the cluster.jsonnet file.
-func GetClusterPath(searchDir string, clusterName string) (string, error) {
-	clusterPath := ""
-
-	err := filepath.Walk(searchDir, func(path string, f os.FileInfo, err error) error {
-		dir, file := filepath.Split(path)
-		if filepath.Base(dir) == clusterName && file == "cluster.jsonnet" {
-			clusterPath = path
-			// No error
-			return nil
-		} else {
-			// Pass back the error
-			return err
-		}
-	})
+// Get a specific cluster within a directory by name, or by a path-qualified reference such as `aws/us-east-1/prod`.
+// Indexes the cluster directory tree, see [LoadClusterIndex].
+// Returns the path to the cluster.jsonnet file, or the params entry of a matrix cluster, see [ClusterParamsEntry].
+func GetClusterPath(searchDir string, clusterName string, loadMatrix ClusterMatrixLoader) (string, error) {
+	index, err := LoadClusterIndex(searchDir, loadMatrix)
 	if err != nil {
-		return "", ErrorIfCheck("error building cluster list", err)
+		return "", err
 	}
-	if clusterPath == "" {
-		return "", types.Kr8Error{Message: "error: could not find cluster: " + clusterName, Value: ""}
+	cluster, err := index.Resolve(clusterName)
+	if err != nil {
+		return "", err
 	}
 
-	return clusterPath, nil
+	return ClusterParamsEntry(cluster), nil
 }
 
 // Get all cluster parameters within a directory.
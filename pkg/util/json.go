@@ -114,6 +114,14 @@ func FormatJsonnetStringCustom(input string, opts formatter.Options) (string, er
 	return formatter.Format("", input, opts)
 }
 
+// Quotes a string as a jsonnet string literal, such as a file to import or a field to index.
+// JSON string escapes are valid in jsonnet, so quotes and backslashes in the value can't end the literal.
+func QuoteJsonnet(value string) string {
+	quoted, _ := json.Marshal(value)
+
+	return string(quoted)
+}
+
 // Write out a struct to a specified path and file.
 // Marshals the given interface and generates a formatted json string.
 // All parent directories needed are created.
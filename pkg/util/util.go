@@ -16,6 +16,8 @@ import (
 	"strings"
 
 	"github.com/tidwall/gjson"
+
+	"github.com/ice-bergtech/kr8/pkg/kr8_select"
 )
 
 // Filter returns a new slice containing only the elements that satisfy the predicate function.
@@ -38,7 +40,6 @@ type PathFilterOptions struct {
 	//
 	// regex filters using the "~" operator. For example, "name~^myRegex$"
 	// equality matches using the "=" operator. For example, "name=myValue"
-	// substring matches using the "=" operator. For example, "name=myValue"
 	//
 	// If no operator is provided, it is treated as a substring match against the "name" field.
 	Includes string
@@ -47,15 +48,21 @@ type PathFilterOptions struct {
 	//
 	// regex filters using the "~" operator. For example, "name~^myRegex$"
 	// equality matches using the "=" operator. For example, "name=myValue"
-	// substring matches using the "=" operator. For example, "name=myValue"
 	//
 	// If no operator is provided, it is treated as a substring match against the "name" field.
 	Excludes string
+	// Selector expression clusters must match, see [kr8_select] for the grammar.
+	// Narrows the clusters selected by the other filters.
+	// For example, "env=prod && region~^us- && !(tier=legacy)".
+	Selector string
 	// Comma separated cluster names.
 	// Filters keys on exact match.
 	Clusters string
 	// Comma separated component names.
 	Components string
+	// Kubernetes label selector matched against component labels.
+	// For example, "layer=observability,team in (platform,sre)".
+	ComponentSelector string
 }
 
 // Checks if a input object matches a filter string.
@@ -155,6 +162,23 @@ func CalculateClusterIncludesExcludes(input map[string]string, filters PathFilte
 	return FilterItems(input, filters)
 }
 
+// Filters a list of keys of a map to those whose value, parsed as a gjson result, matches a selector expression.
+// See [kr8_select] for the grammar. Returns an error if the expression can't be parsed.
+func SelectItems(input map[string]string, keys []string, expression string) ([]string, error) {
+	selector, err := kr8_select.Parse(expression)
+	if err != nil {
+		return nil, ErrorIfCheck("error parsing select filter", err)
+	}
+	selected := []string{}
+	for _, key := range keys {
+		if selector.Match(gjson.Parse(input[key])) {
+			selected = append(selected, key)
+		}
+	}
+
+	return selected, nil
+}
+
 // Calculate the sha256 hash and returns the base64 encoded result.
 func HashFile(path string) (string, error) {
 	file, err := os.Open(filepath.Clean(path))
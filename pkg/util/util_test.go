@@ -0,0 +1,103 @@
+package util_test
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	util "github.com/ice-bergtech/kr8/pkg/util"
+)
+
+const (
+	clusterProdUS = `{"name": "prod-us", "env": "prod", "region": "us-east-1", "owner": "team a"}`
+	clusterProdEU = `{"name": "prod-eu", "env": "prod", "region": "eu-west-1", "owner": "team b"}`
+	clusterDevUS  = `{"name": "dev-us", "env": "dev", "region": "us-east-1", "owner": "team a"}`
+)
+
+func TestCalculateClusterIncludesExcludes(t *testing.T) {
+	clusters := map[string]string{"prod-us": clusterProdUS, "prod-eu": clusterProdEU, "dev-us": clusterDevUS}
+	tests := []struct {
+		name    string
+		filters util.PathFilterOptions
+		want    []string
+	}{
+		{
+			name:    "cluster names",
+			filters: util.PathFilterOptions{Clusters: "^prod", Includes: "", Excludes: ""},
+			want:    []string{"prod-eu", "prod-us"},
+		},
+		{
+			name:    "equality with spaces",
+			filters: util.PathFilterOptions{Clusters: "", Includes: "owner=team a", Excludes: ""},
+			want:    []string{"dev-us", "prod-us"},
+		},
+		{
+			name:    "regex and name substring",
+			filters: util.PathFilterOptions{Clusters: "", Includes: "region~^eu-,dev", Excludes: ""},
+			want:    []string{"dev-us", "prod-eu"},
+		},
+		{
+			name:    "excludes",
+			filters: util.PathFilterOptions{Clusters: "", Includes: "", Excludes: "env=dev"},
+			want:    []string{"prod-eu", "prod-us"},
+		},
+		{
+			name:    "cluster names override includes and excludes",
+			filters: util.PathFilterOptions{Clusters: "dev-us", Includes: "env=prod", Excludes: "env=dev"},
+			want:    []string{"dev-us"},
+		},
+	}
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			got := util.CalculateClusterIncludesExcludes(clusters, testCase.filters)
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, testCase.want) {
+				t.Errorf("CalculateClusterIncludesExcludes() = %v, want %v", got, testCase.want)
+			}
+		})
+	}
+}
+
+func TestSelectItems(t *testing.T) {
+	clusters := map[string]string{"prod-us": clusterProdUS, "prod-eu": clusterProdEU, "dev-us": clusterDevUS}
+	tests := []struct {
+		name     string
+		keys     []string
+		selector string
+		want     []string
+		wantErr  bool
+	}{
+		{
+			name:     "all clusters",
+			keys:     []string{"dev-us", "prod-eu", "prod-us"},
+			selector: "region~^us- && !(env=dev)",
+			want:     []string{"prod-us"},
+			wantErr:  false,
+		},
+		{
+			name:     "only listed clusters",
+			keys:     []string{"dev-us", "prod-eu"},
+			selector: "region~^us-",
+			want:     []string{"dev-us"},
+			wantErr:  false,
+		},
+		{
+			name:     "invalid selector",
+			keys:     []string{"dev-us"},
+			selector: "(env=prod",
+			want:     nil,
+			wantErr:  true,
+		},
+	}
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			got, err := util.SelectItems(clusters, testCase.keys, testCase.selector)
+			if (err != nil) != testCase.wantErr {
+				t.Fatalf("SelectItems() error = %v, wantErr %v", err, testCase.wantErr)
+			}
+			if !testCase.wantErr && !reflect.DeepEqual(got, testCase.want) {
+				t.Errorf("SelectItems() = %v, want %v", got, testCase.want)
+			}
+		})
+	}
+}